@@ -0,0 +1,195 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	maxBackfillPages     = 100
+	defaultBackfillLimit = 1000
+)
+
+type backfillOptions struct {
+	Limit int
+	Since time.Time
+	// Queue puts backfilled items into UnprocessedItems; otherwise they are
+	// only recorded as seen
+	Queue bool
+}
+
+type backfillResult struct {
+	Pages int
+	Items int
+}
+
+// backfillFeed imports the history of a feed. It walks RFC 5005 archives
+// (rel="prev-archive" of archived feeds, rel="next" of paged feeds) and,
+// when a feed links to neither, WordPress-style ?paged=N pages. It stops at
+// opts.Limit new items, at items older than opts.Since or when the history
+// runs out.
+func backfillFeed(ctx context.Context, userFeed *Feed, opts backfillOptions, log *slog.Logger) (backfillResult, error) {
+	var result backfillResult
+	if opts.Limit <= 0 {
+		opts.Limit = defaultBackfillLimit
+	}
+
+	visited := map[string]bool{}
+	pageURL := userFeed.Url
+	wordpress := false
+
+	for pageURL != "" && result.Pages < maxBackfillPages && result.Items < opts.Limit {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		visited[pageURL] = true
+
+		page, err := httpGet(ctx, pageURL)
+		if err != nil {
+			if result.Pages > 0 && errors.Is(err, ErrUnexpectedStatus) {
+				// ran past the last page
+				break
+			}
+			return result, err
+		}
+		remoteFeed, err := gofeed.NewParser().Parse(bytes.NewReader(page.Body))
+		if err != nil {
+			if result.Pages > 0 {
+				break
+			}
+			return result, err
+		}
+		result.Pages++
+
+		added, older := backfillItems(userFeed, remoteFeed.Items, opts, opts.Limit-result.Items)
+		result.Items += added
+		log.Info("backfilled page", "url", pageURL, "items", added)
+
+		if older || (wordpress && added == 0) {
+			break
+		}
+
+		links := feedLinkRels(page.Body)
+		next := links["prev-archive"]
+		if next == "" {
+			next = links["next"]
+		}
+		if next == "" && (result.Pages == 1 || wordpress) {
+			wordpress = true
+			next = pagedURL(userFeed.Url, result.Pages+1)
+		}
+		if next != "" {
+			if ref, err := page.URL.Parse(next); err == nil {
+				next = ref.String()
+			}
+		}
+		if visited[next] {
+			break
+		}
+		pageURL = next
+	}
+
+	return result, nil
+}
+
+// backfillItems records up to limit unseen items and reports whether it
+// reached items older than opts.Since.
+func backfillItems(userFeed *Feed, items []*gofeed.Item, opts backfillOptions, limit int) (int, bool) {
+	added, older := 0, false
+	for _, remoteItem := range items {
+		if added >= limit {
+			break
+		}
+		if !opts.Since.IsZero() {
+			if date := itemDate(remoteItem); date != nil && date.Before(opts.Since) {
+				older = true
+				continue
+			}
+		}
+		// the same check as applyUpdates, so history doesn't bring back
+		// what polling has already queued
+		id := itemID(remoteItem)
+		if userFeed.seenItem(remoteItem, id) {
+			continue
+		}
+		userFeed.markSeen(id)
+		if opts.Queue {
+			userFeed.UnprocessedItems = append(userFeed.UnprocessedItems, newUnprocessedItem(remoteItem))
+		}
+		added++
+	}
+	return added, older
+}
+
+func itemDate(item *gofeed.Item) *time.Time {
+	if item.PublishedParsed != nil {
+		return item.PublishedParsed
+	}
+	return item.UpdatedParsed
+}
+
+func pagedURL(feedURL string, page int) string {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("paged", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// feedLinkRels returns the first href of every feed-level <link rel="...">
+// in a feed document, both Atom links and atom:link inside RSS. Links of
+// entries and items are skipped.
+func feedLinkRels(body []byte) map[string]string {
+	rels := map[string]string{}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	decoder.CharsetReader = charsetReader
+
+	inItem := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return rels
+		}
+
+		switch t := token.(type) {
+		case xml.EndElement:
+			if t.Name.Local == "entry" || t.Name.Local == "item" {
+				inItem--
+			}
+		case xml.StartElement:
+			if t.Name.Local == "entry" || t.Name.Local == "item" {
+				inItem++
+			}
+			if inItem > 0 || t.Name.Local != "link" {
+				continue
+			}
+			var rel, href string
+			for _, attr := range t.Attr {
+				switch attr.Name.Local {
+				case "rel":
+					rel = strings.TrimSpace(attr.Value)
+				case "href":
+					href = strings.TrimSpace(attr.Value)
+				}
+			}
+			for _, r := range strings.Fields(rel) {
+				if _, exists := rels[r]; !exists && href != "" {
+					rels[r] = href
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func atomPage(links string, entries ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Archive</title><id>urn:a</id>`)
+	b.WriteString(links)
+	for _, e := range entries {
+		// e is "id@date"
+		id, date, _ := strings.Cut(e, "@")
+		fmt.Fprintf(&b, `<entry><id>%s</id><title>%s</title><link rel="next" href="/bogus"/><published>%sT00:00:00Z</published></entry>`, id, id, date)
+	}
+	b.WriteString(`</feed>`)
+	return b.String()
+}
+
+func rssPage(items ...string) string {
+	var b strings.Builder
+	b.WriteString(`<rss version="2.0"><channel><title>WP</title>`)
+	for _, id := range items {
+		fmt.Fprintf(&b, `<item><guid>%s</guid><title>%s</title></item>`, id, id)
+	}
+	b.WriteString(`</channel></rss>`)
+	return b.String()
+}
+
+func newBackfillTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/atom", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, atomPage(`<link rel="self" href="/atom"/><link rel="next" href="/atom/2"/>`, "a5@2024-05-01", "a4@2024-04-01"))
+	})
+	mux.HandleFunc("/atom/2", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, atomPage(`<link rel="prev-archive" href="/archive/2023"/>`, "a3@2024-03-01"))
+	})
+	mux.HandleFunc("/archive/2023", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, atomPage(`<link rel="prev-archive" href="/atom"/>`, "a2@2023-12-01", "a1@2023-06-01"))
+	})
+	mux.HandleFunc("/wp/feed", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("paged") {
+		case "":
+			io.WriteString(w, rssPage("w4", "w3"))
+		case "2":
+			io.WriteString(w, rssPage("w2", "w1"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_backfillFeed(t *testing.T) {
+	srv := newBackfillTestServer(t)
+	log := setupLogger(io.Discard)
+
+	t.Run("ArchivedFeed", func(t *testing.T) {
+		userFeed := newFeed(FEED_TYPE_RSS, srv.URL+"/atom")
+		userFeed.markSeen("a5")
+
+		result, err := backfillFeed(context.Background(), userFeed, backfillOptions{Queue: true}, log)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result.Pages != 3 || result.Items != 4 {
+			t.Errorf("expected 4 items from 3 pages, got %+v", result)
+		}
+		if len(userFeed.UnprocessedItems) != 4 || userFeed.UnprocessedItems[3].GUID != "a1" {
+			t.Errorf("expected history queued oldest last, got %+v", userFeed.UnprocessedItems)
+		}
+	})
+
+	t.Run("LimitAndSince", func(t *testing.T) {
+		userFeed := newFeed(FEED_TYPE_RSS, srv.URL+"/atom")
+		result, _ := backfillFeed(context.Background(), userFeed, backfillOptions{Limit: 2, Queue: true}, log)
+		if result.Items != 2 || len(userFeed.UnprocessedItems) != 2 {
+			t.Errorf("expected limit of 2 items, got %+v", result)
+		}
+
+		userFeed = newFeed(FEED_TYPE_RSS, srv.URL+"/atom")
+		since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		result, _ = backfillFeed(context.Background(), userFeed, backfillOptions{Since: since, Queue: true}, log)
+		if result.Items != 3 || userFeed.seen("a2") {
+			t.Errorf("expected items since 2024 only, got %+v", result)
+		}
+	})
+
+	t.Run("WordPressPaged", func(t *testing.T) {
+		userFeed := newFeed(FEED_TYPE_RSS, srv.URL+"/wp/feed")
+
+		result, err := backfillFeed(context.Background(), userFeed, backfillOptions{}, log)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result.Items != 4 || result.Pages != 2 {
+			t.Errorf("expected 4 items from 2 pages, got %+v", result)
+		}
+		if len(userFeed.UnprocessedItems) != 0 || !userFeed.seen("w1") {
+			t.Errorf("expected items recorded as seen without queueing")
+		}
+	})
+
+	t.Run("AfterUpdate", func(t *testing.T) {
+		page := rssPage("https://example.com/post?utm_source=rss", "plain-guid")
+		linkSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("paged") != "" {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, page)
+		}))
+		defer linkSrv.Close()
+
+		userFeed := newFeed(FEED_TYPE_RSS, linkSrv.URL+"/feed")
+		remoteFeed, err := gofeed.NewParser().ParseString(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remoteFeed.Updated = "2024-06-01T00:00:00Z"
+		if err := applyUpdates(context.Background(), userFeed, remoteFeed, log); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(userFeed.UnprocessedItems) != 2 {
+			t.Fatalf("expected 2 items from the update, got %d", len(userFeed.UnprocessedItems))
+		}
+
+		result, err := backfillFeed(context.Background(), userFeed, backfillOptions{Queue: true}, log)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result.Items != 0 || len(userFeed.UnprocessedItems) != 2 {
+			t.Errorf("expected backfill to skip items the update queued, got %+v and %d items", result, len(userFeed.UnprocessedItems))
+		}
+	})
+}
+
+func Test_backfillCommand(t *testing.T) {
+	srv := newBackfillTestServer(t)
+
+	var saved Feeds
+	mockFeedsIO := &MockFeedsIO{
+		LoadFeedsFunc: func(userFeedsFile string) (Feeds, error) {
+			return Feeds{Items: []*Feed{newFeed(FEED_TYPE_RSS, srv.URL+"/wp/feed")}}, nil
+		},
+		SaveUpdatesFunc: func(feeds Feeds, userFeedsFile string) error {
+			saved = feeds
+			return nil
+		},
+	}
+
+	var stdoutBuf bytes.Buffer
+	exitCode := run([]string{"rss_reader", "backfill", "-no-queue", TestAppArgs[1], srv.URL + "/wp/feed"}, mockFeedsIO, &MockGofeedParser{}, &stdoutBuf)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d. Output: %s", exitCode, stdoutBuf.String())
+	}
+	if !strings.Contains(stdoutBuf.String(), "backfilled 4 items from 2 pages") {
+		t.Errorf("unexpected output:\n%s", stdoutBuf.String())
+	}
+	if len(saved.Items) != 1 || len(saved.Items[0].UnprocessedGUID) != 4 {
+		t.Errorf("expected seen items to be saved, got %+v", saved.Items)
+	}
+
+	exitCode = run([]string{"rss_reader", "backfill", TestAppArgs[1], srv.URL + "/unknown"}, mockFeedsIO, &MockGofeedParser{}, io.Discard)
+	if exitCode != E_BAD_COMMAND_ARGS {
+		t.Errorf("expected exit code %d for unknown feed, got %d", E_BAD_COMMAND_ARGS, exitCode)
+	}
+}
@@ -0,0 +1,290 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// trackingParams are query parameters that only identify the campaign or
+// click that led to a link. Every "utm_" parameter is one as well.
+var trackingParams = map[string]bool{
+	"fbclid":      true,
+	"gclid":       true,
+	"gclsrc":      true,
+	"dclid":       true,
+	"msclkid":     true,
+	"yclid":       true,
+	"twclid":      true,
+	"igshid":      true,
+	"mc_cid":      true,
+	"mc_eid":      true,
+	"_hsenc":      true,
+	"_hsmi":       true,
+	"mkt_tok":     true,
+	"vero_id":     true,
+	"vero_conv":   true,
+	"oly_anon_id": true,
+	"oly_enc_id":  true,
+	"wt.mc_id":    true,
+	"wt_mc":       true,
+	"ncid":        true,
+	"ocid":        true,
+	"cmpid":       true,
+	"s_cid":       true,
+	"sr_share":    true,
+	"ref_src":     true,
+	"ref_url":     true,
+	"spm":         true,
+	"_ga":         true,
+	"_gl":         true,
+	"guccounter":  true,
+}
+
+// shorteners are hosts that only redirect to the real link.
+var shorteners = map[string]bool{
+	"bit.ly":               true,
+	"bitly.com":            true,
+	"buff.ly":              true,
+	"cutt.ly":              true,
+	"dlvr.it":              true,
+	"fb.me":                true,
+	"feedproxy.google.com": true,
+	"goo.gl":               true,
+	"is.gd":                true,
+	"lnkd.in":              true,
+	"ow.ly":                true,
+	"rebrand.ly":           true,
+	"shorturl.at":          true,
+	"t.co":                 true,
+	"tiny.cc":              true,
+	"tinyurl.com":          true,
+	"trib.al":              true,
+}
+
+// canonicalURL is the form links are stored, compared and shared in: host
+// in lower case, no default port, no fragment, no tracking parameters and
+// the publisher's page instead of its copy in an AMP cache. The remaining
+// query parameters are sorted. Anything but an absolute http(s) URL is
+// returned as is, so paths of local feeds pass through. AMP looking paths
+// of other hosts are left alone, only the canonicalizer can tell whether
+// they are AMP, see resolve.
+func canonicalURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return rawURL
+	}
+	if target := ampCacheTarget(u); target != nil {
+		// the cache serves the AMP variant, so its path is one
+		u = target
+		if p := ampPath(u.Path); p != u.Path {
+			u.Path, u.RawPath = p, ""
+		}
+	}
+
+	u.Host = strings.TrimSuffix(strings.ToLower(u.Host), ".")
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+
+	// "#!" fragments address content on single page sites
+	if !strings.HasPrefix(u.Fragment, "!") {
+		u.Fragment, u.RawFragment = "", ""
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	u.ForceQuery = false
+	if u.RawQuery != "" {
+		if values, err := url.ParseQuery(u.RawQuery); err == nil {
+			for key, vals := range values {
+				if isTrackingParam(key, vals) {
+					delete(values, key)
+				}
+			}
+			u.RawQuery = values.Encode()
+		}
+	}
+	return u.String()
+}
+
+func isTrackingParam(key string, values []string) bool {
+	key = strings.ToLower(key)
+	if strings.HasPrefix(key, "utm_") || trackingParams[key] {
+		return true
+	}
+	value := ""
+	if len(values) > 0 {
+		value = strings.ToLower(values[0])
+	}
+	switch key {
+	case "amp":
+		return value == "" || value == "1" || value == "true"
+	case "outputtype":
+		return value == "amp"
+	}
+	return false
+}
+
+// ampCacheTarget returns the publisher's URL of a page served from the
+// Google AMP viewer or the AMP cache, and nil for any other URL.
+func ampCacheTarget(u *url.URL) *url.URL {
+	host := strings.ToLower(u.Hostname())
+	var rest string
+	switch {
+	case (host == "www.google.com" || host == "google.com") && strings.HasPrefix(u.Path, "/amp/"):
+		rest = strings.TrimPrefix(u.Path, "/amp/")
+	case strings.HasSuffix(host, ".cdn.ampproject.org"):
+		// /c/ is a document, /v/ a viewer page, /i/ an image
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		if len(parts) != 2 || len(parts[0]) != 1 {
+			return nil
+		}
+		rest = parts[1]
+	default:
+		return nil
+	}
+
+	scheme := "http"
+	if after, ok := strings.CutPrefix(rest, "s/"); ok {
+		scheme, rest = "https", after
+	}
+	target, err := url.Parse(scheme + "://" + rest)
+	if err != nil || target.Host == "" {
+		return nil
+	}
+	target.RawQuery = u.RawQuery
+	target.Fragment = u.Fragment
+	return target
+}
+
+// ampPath maps the path of an AMP page to the regular one: "/post/amp",
+// "/amp/post", "/post.amp" and "/post.amp.html" all become "/post" or
+// "/post.html".
+func ampPath(p string) string {
+	trailing := strings.HasSuffix(p, "/")
+	trimmed := strings.TrimSuffix(p, "/")
+	switch {
+	case strings.HasSuffix(trimmed, "/amp"):
+		trimmed = strings.TrimSuffix(trimmed, "/amp")
+	case strings.HasPrefix(trimmed, "/amp/"):
+		trimmed = strings.TrimPrefix(trimmed, "/amp")
+	case strings.HasSuffix(trimmed, ".amp.html"):
+		trimmed = strings.TrimSuffix(trimmed, ".amp.html") + ".html"
+	case strings.HasSuffix(trimmed, ".amp"):
+		trimmed = strings.TrimSuffix(trimmed, ".amp")
+	default:
+		return p
+	}
+	if trailing && trimmed != "" && path.Ext(trimmed) == "" {
+		trimmed += "/"
+	}
+	if trimmed == "" {
+		trimmed = "/"
+	}
+	return trimmed
+}
+
+// looksAMP tells whether the path of rawURL is one AMP pages use, see
+// ampPath. Regular pages may have such paths too.
+func looksAMP(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Host != "" && ampPath(u.Path) != u.Path
+}
+
+func isShortener(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && shorteners[strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")]
+}
+
+// itemID is what identifies a remote item among the ones seen before: its
+// GUID, or its canonical link when it has none. GUIDs are opaque, even
+// those that look like links: two of them may differ in nothing but a
+// query parameter.
+func itemID(remoteItem *gofeed.Item) string {
+	guid := strings.TrimSpace(remoteItem.GUID)
+	if guid == "" {
+		return canonicalURL(remoteItem.Link)
+	}
+	return guid
+}
+
+// canonicalizer is the "canonicalize" middleware: it replaces item links
+// with what the publisher declares canonical and expands shortened ones.
+// Both need the page to be fetched, so they are turned on per user.
+type canonicalizer struct {
+	config *CanonicalConfig
+}
+
+func newCanonicalizer(config *CanonicalConfig) *canonicalizer {
+	return &canonicalizer{config: config}
+}
+
+func (c *canonicalizer) Name() string {
+	return "canonicalize"
+}
+
+func (c *canonicalizer) Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	if c.config == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, item := range items {
+		resolved, err := c.resolve(ctx, item.URL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", item.GUID, err))
+			continue
+		}
+		item.URL = resolved
+	}
+	return errors.Join(errs...)
+}
+
+// resolve follows the redirects of a shortened link and the page's
+// rel=canonical, as far as the config allows. Links with an AMP looking
+// path are fetched as well and lead to the regular page when the page
+// says it is AMP. Links it has no reason to fetch are returned unchanged.
+func (c *canonicalizer) resolve(ctx context.Context, rawURL string) (string, error) {
+	shortened := c.config.ExpandShorteners && isShortener(rawURL)
+	if rawURL == "" || (!shortened && !c.config.ResolveCanonical && !looksAMP(rawURL)) {
+		return rawURL, nil
+	}
+
+	page, err := httpGet(ctx, rawURL)
+	if err != nil {
+		return rawURL, err
+	}
+	resolved := canonicalURL(page.URL.String())
+
+	if c.config.ResolveCanonical || looksAMP(page.URL.String()) {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+		if err != nil {
+			return resolved, nil
+		}
+		href := strings.TrimSpace(doc.Find(`link[rel~="canonical"][href]`).First().AttrOr("href", ""))
+		if !c.config.ResolveCanonical && doc.Find(`html[amp], html[⚡]`).Length() == 0 {
+			href = ""
+		}
+		if href != "" {
+			declared, err := url.Parse(resolveURL(page.URL.String(), href))
+			if err == nil && (declared.Scheme == "http" || declared.Scheme == "https") && declared.Host != "" {
+				resolved = canonicalURL(declared.String())
+			}
+		}
+	}
+	return resolved, nil
+}
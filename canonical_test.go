@@ -0,0 +1,125 @@
+package rss_reader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func Test_canonicalURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://Example.COM:443/Post?utm_source=rss&utm_medium=feed&id=7", "https://example.com/Post?id=7"},
+		{"http://example.com:80", "http://example.com/"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"https://example.com/a?fbclid=abc&b=2&a=1#comments", "https://example.com/a?a=1&b=2"},
+		{"https://example.com/app#!/post/1", "https://example.com/app#!/post/1"},
+		{"https://example.com/2024/05/story/amp/", "https://example.com/2024/05/story/amp/"},
+		{"https://example.com/amp/2024/story", "https://example.com/amp/2024/story"},
+		{"https://example.com/story.amp.html", "https://example.com/story.amp.html"},
+		{"https://example.com/story?amp=1", "https://example.com/story"},
+		{"https://example.com/story?outputType=amp", "https://example.com/story"},
+		{"https://www.google.com/amp/s/example.com/story/amp", "https://example.com/story"},
+		{"https://example-com.cdn.ampproject.org/c/s/example.com/story?utm_campaign=x", "https://example.com/story"},
+		{"https://example-com.cdn.ampproject.org/c/s/example.com/story.amp.html", "https://example.com/story.html"},
+		{"https://example.com/champ", "https://example.com/champ"},
+		{" /home/user/Maildir ", "/home/user/Maildir"},
+		{"guid-123", "guid-123"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := canonicalURL(tt.in); got != tt.want {
+			t.Errorf("canonicalURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_itemID(t *testing.T) {
+	tests := []struct {
+		item *gofeed.Item
+		want string
+	}{
+		{&gofeed.Item{GUID: "tag:example.com,2024:1", Link: "https://example.com/1"}, "tag:example.com,2024:1"},
+		{&gofeed.Item{GUID: " https://example.com/1?utm_source=rss "}, "https://example.com/1?utm_source=rss"},
+		{&gofeed.Item{Link: "https://EXAMPLE.com/1?fbclid=x"}, "https://example.com/1"},
+	}
+	for _, tt := range tests {
+		if got := itemID(tt.item); got != tt.want {
+			t.Errorf("itemID(%+v) = %q, want %q", tt.item, got, tt.want)
+		}
+	}
+}
+
+func Test_canonicalizer(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article?utm_source=feed", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="canonical" href="/posts/article#top"></head><body>Article</body></html>`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body>No canonical</body></html>`)
+	})
+	mux.HandleFunc("/story/amp", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html amp><head><link rel="canonical" href="/story"></head><body>AMP</body></html>`)
+	})
+	mux.HandleFunc("/amp/guide", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="canonical" href="/amplifiers"></head><body>Amplifiers</body></html>`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	items := []*UnprocessedItem{
+		{GUID: "1", URL: srv.URL + "/moved"},
+		{GUID: "2", URL: srv.URL + "/plain?utm_medium=rss"},
+		{GUID: "3", URL: srv.URL + "/missing"},
+	}
+
+	if err := newCanonicalizer(nil).Process(ctx, nil, items); err != nil || items[0].URL != srv.URL+"/moved" {
+		t.Fatalf("expected nothing to happen without config, got %v %q", err, items[0].URL)
+	}
+
+	err := newCanonicalizer(&CanonicalConfig{ResolveCanonical: true}).Process(ctx, nil, items)
+	if err == nil {
+		t.Errorf("expected an error for the missing page")
+	}
+	if want := srv.URL + "/posts/article"; items[0].URL != want {
+		t.Errorf("expected %q, got %q", want, items[0].URL)
+	}
+	if want := srv.URL + "/plain"; items[1].URL != want {
+		t.Errorf("expected %q, got %q", want, items[1].URL)
+	}
+	if want := srv.URL + "/missing"; items[2].URL != want {
+		t.Errorf("expected the link of a failed item to stay, got %q", items[2].URL)
+	}
+
+	// only shortened links are fetched for expansion
+	item := &UnprocessedItem{GUID: "4", URL: srv.URL + "/moved"}
+	if err := newCanonicalizer(&CanonicalConfig{ExpandShorteners: true}).Process(ctx, nil, []*UnprocessedItem{item}); err != nil || item.URL != srv.URL+"/moved" {
+		t.Errorf("expected a regular link to be left alone, got %v %q", err, item.URL)
+	}
+	// AMP looking paths are rewritten only when the page says it is AMP
+	amp := &UnprocessedItem{GUID: "5", URL: srv.URL + "/story/amp"}
+	notAMP := &UnprocessedItem{GUID: "6", URL: srv.URL + "/amp/guide"}
+	if err := newCanonicalizer(&CanonicalConfig{}).Process(ctx, nil, []*UnprocessedItem{amp, notAMP}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if want := srv.URL + "/story"; amp.URL != want {
+		t.Errorf("expected %q, got %q", want, amp.URL)
+	}
+	if want := srv.URL + "/amp/guide"; notAMP.URL != want {
+		t.Errorf("expected a regular page with an AMP looking path to stay, got %q", notAMP.URL)
+	}
+
+	if !isShortener("https://bit.ly/abc") || !isShortener("http://www.t.co/x") || isShortener("https://example.com/") {
+		t.Errorf("unexpected shortener detection")
+	}
+}
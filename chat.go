@@ -0,0 +1,326 @@
+package rss_reader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Slack takes about a message a second per webhook, Discord 5 every 2
+	// seconds. Matrix servers differ; they answer 429 with the wait.
+	slackInterval   = time.Second
+	discordInterval = 400 * time.Millisecond
+	matrixInterval  = time.Second
+
+	slackBatchSize  = 10
+	matrixBatchSize = 10
+	// a Discord message has up to 10 embeds, their texts 6000 characters
+	// together
+	discordMaxEmbeds    = 10
+	discordMaxEmbedText = 6000
+
+	chatTitleRunes = 256
+)
+
+// slackNotifier posts new items to a Slack incoming webhook as Block Kit
+// messages, up to slackBatchSize items each.
+type slackNotifier struct {
+	config   *SlackConfig
+	endpoint *endpoint
+}
+
+type slackMessage struct {
+	Text        string        `json:"text"`
+	Blocks      []*slackBlock `json:"blocks"`
+	UnfurlLinks bool          `json:"unfurl_links"`
+}
+
+type slackBlock struct {
+	Type      string       `json:"type"`
+	Text      *slackText   `json:"text,omitempty"`
+	Accessory *slackImage  `json:"accessory,omitempty"`
+	Elements  []*slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackImage struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+func newSlackNotifier(config *SlackConfig, dead *deadLetters) *slackNotifier {
+	e := newEndpoint("slack", config.URL, config.MaxAttempts, dead)
+	e.interval = slackInterval
+	return &slackNotifier{config: config, endpoint: e}
+}
+
+func (s *slackNotifier) Name() string {
+	return "slack"
+}
+
+func (s *slackNotifier) ID() string {
+	return s.endpoint.consumer
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	return notifyBatches(ctx, s.endpoint, userFeed, items, chunksOf(slackBatchSize), func(key string, batch []*UnprocessedItem) (*message, error) {
+		body, err := json.Marshal(newSlackMessage(userFeed, batch))
+		return &message{key: key, url: s.config.URL, body: body}, err
+	})
+}
+
+func newSlackMessage(userFeed *Feed, batch []*UnprocessedItem) *slackMessage {
+	msg := &slackMessage{Text: fmt.Sprintf("%d new items from %s", len(batch), slackEscape(feedTitle(userFeed)))}
+	if len(batch) == 1 {
+		msg.Text = slackEscape(itemTitle(batch[0]))
+	}
+	for i, item := range batch {
+		if i > 0 {
+			msg.Blocks = append(msg.Blocks, &slackBlock{Type: "divider"})
+		}
+		title := slackEscape(truncateText(itemTitle(item), chatTitleRunes))
+		if link := itemLink(item); link != "" {
+			title = "<" + strings.NewReplacer("|", "%7C", ">", "%3E").Replace(link) + "|" + title + ">"
+		}
+		text := "*" + title + "*"
+		if body := notifyText(item, RENDER_TEXT); body != "" {
+			text += "\n" + slackEscape(body)
+		}
+		section := &slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+		if image := itemImage(item); image != "" {
+			section.Accessory = &slackImage{Type: "image", ImageURL: image, AltText: truncateText(itemTitle(item), 100)}
+		}
+		msg.Blocks = append(msg.Blocks, section, &slackBlock{
+			Type:     "context",
+			Elements: []*slackText{{Type: "mrkdwn", Text: slackEscape(byline(userFeed, item))}},
+		})
+	}
+	return msg
+}
+
+// slackEscape escapes the characters Slack reads as markup in mrkdwn.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// discordNotifier posts new items to a Discord webhook as embeds, as many
+// per message as Discord takes. Mentions in items are never resolved.
+type discordNotifier struct {
+	config   *DiscordConfig
+	endpoint *endpoint
+}
+
+type discordMessage struct {
+	Username        string          `json:"username,omitempty"`
+	Embeds          []*discordEmbed `json:"embeds"`
+	AllowedMentions discordMentions `json:"allowed_mentions"`
+}
+
+type discordMentions struct {
+	Parse []string `json:"parse"`
+}
+
+type discordEmbed struct {
+	Title       string        `json:"title"`
+	URL         string        `json:"url,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Timestamp   string        `json:"timestamp,omitempty"`
+	Author      *discordName  `json:"author,omitempty"`
+	Footer      *discordName  `json:"footer,omitempty"`
+	Thumbnail   *discordImage `json:"thumbnail,omitempty"`
+}
+
+// discordName is an embed author or footer; the footer's field is "text".
+type discordName struct {
+	Name string `json:"name,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+func newDiscordNotifier(config *DiscordConfig, dead *deadLetters) *discordNotifier {
+	e := newEndpoint("discord", config.URL, config.MaxAttempts, dead)
+	e.interval = discordInterval
+	return &discordNotifier{config: config, endpoint: e}
+}
+
+func (d *discordNotifier) Name() string {
+	return "discord"
+}
+
+func (d *discordNotifier) ID() string {
+	return d.endpoint.consumer
+}
+
+func (d *discordNotifier) Notify(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	split := func(items []*UnprocessedItem) [][]*UnprocessedItem {
+		return splitEmbeds(userFeed, items)
+	}
+	return notifyBatches(ctx, d.endpoint, userFeed, items, split, func(key string, batch []*UnprocessedItem) (*message, error) {
+		body, err := json.Marshal(newDiscordMessage(d.config, userFeed, batch))
+		return &message{key: key, url: d.config.URL, body: body}, err
+	})
+}
+
+func newDiscordMessage(config *DiscordConfig, userFeed *Feed, batch []*UnprocessedItem) *discordMessage {
+	msg := &discordMessage{Username: config.Username, AllowedMentions: discordMentions{Parse: []string{}}}
+	for _, item := range batch {
+		msg.Embeds = append(msg.Embeds, newDiscordEmbed(userFeed, item))
+	}
+	return msg
+}
+
+func newDiscordEmbed(userFeed *Feed, item *UnprocessedItem) *discordEmbed {
+	embed := &discordEmbed{
+		Title:       truncateText(itemTitle(item), chatTitleRunes),
+		URL:         itemLink(item),
+		Description: notifyText(item, RENDER_MARKDOWN),
+		Footer:      &discordName{Text: truncateText(feedTitle(userFeed), chatTitleRunes)},
+	}
+	if !item.Published.IsZero() {
+		embed.Timestamp = item.Published.UTC().Format(time.RFC3339)
+	}
+	if authors := itemAuthors(item); authors != "" {
+		embed.Author = &discordName{Name: truncateText(authors, chatTitleRunes)}
+	}
+	if image := itemImage(item); image != "" {
+		embed.Thumbnail = &discordImage{URL: image}
+	}
+	return embed
+}
+
+// splitEmbeds splits items into messages that stay within Discord's
+// limits on the number of embeds and on their text.
+func splitEmbeds(userFeed *Feed, items []*UnprocessedItem) [][]*UnprocessedItem {
+	var batches [][]*UnprocessedItem
+	var batch []*UnprocessedItem
+	text := 0
+	for _, item := range items {
+		n := newDiscordEmbed(userFeed, item).textLen()
+		if len(batch) == discordMaxEmbeds || (len(batch) > 0 && text+n > discordMaxEmbedText) {
+			batches = append(batches, batch)
+			batch, text = nil, 0
+		}
+		batch = append(batch, item)
+		text += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// textLen is what an embed counts against discordMaxEmbedText.
+func (e *discordEmbed) textLen() int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+	if e.Author != nil {
+		n += utf8.RuneCountInString(e.Author.Name)
+	}
+	if e.Footer != nil {
+		n += utf8.RuneCountInString(e.Footer.Text)
+	}
+	return n
+}
+
+// matrixNotifier sends new items to a Matrix room as m.notice messages
+// with an HTML formatted body, up to matrixBatchSize items each. The
+// message key is the transaction ID, so the server drops repeats itself.
+type matrixNotifier struct {
+	config   *MatrixConfig
+	endpoint *endpoint
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+func newMatrixNotifier(config *MatrixConfig, dead *deadLetters) *matrixNotifier {
+	e := newEndpoint("matrix", config.Homeserver+"/"+config.Room, config.MaxAttempts, dead)
+	e.method = http.MethodPut
+	e.interval = matrixInterval
+	return &matrixNotifier{config: config, endpoint: e}
+}
+
+func (m *matrixNotifier) Name() string {
+	return "matrix"
+}
+
+func (m *matrixNotifier) ID() string {
+	return m.endpoint.consumer
+}
+
+func (m *matrixNotifier) Notify(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	if m.config.Homeserver == "" || m.config.Room == "" || m.config.AccessToken == "" {
+		return fmt.Errorf("%w: matrix needs homeserver, room and access token", ErrNotifierNotConfigured)
+	}
+	room := strings.TrimRight(m.config.Homeserver, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(m.config.Room)
+	return notifyBatches(ctx, m.endpoint, userFeed, items, chunksOf(matrixBatchSize), func(key string, batch []*UnprocessedItem) (*message, error) {
+		body, err := json.Marshal(newMatrixMessage(userFeed, batch))
+		msg := &message{key: key, url: room + "/send/m.room.message/" + key, header: http.Header{}, body: body}
+		msg.header.Set("Authorization", "Bearer "+m.config.AccessToken)
+		return msg, err
+	})
+}
+
+func newMatrixMessage(userFeed *Feed, batch []*UnprocessedItem) *matrixMessage {
+	var text, formatted []string
+	for _, item := range batch {
+		title := itemTitle(item)
+		plain := title
+		markup := "<b>" + html.EscapeString(title) + "</b>"
+		if link := itemLink(item); link != "" {
+			plain += "\n" + link
+			markup = `<b><a href="` + html.EscapeString(link) + `">` + html.EscapeString(title) + "</a></b>"
+		}
+		plain += "\n" + byline(userFeed, item)
+		markup += "<br><i>" + html.EscapeString(byline(userFeed, item)) + "</i>"
+		if body := notifyText(item, RENDER_TEXT); body != "" {
+			plain += "\n" + body
+		}
+		if body := notifyText(item, RENDER_TELEGRAM); body != "" {
+			markup += "<br>" + strings.ReplaceAll(body, "\n", "<br>")
+		}
+		text = append(text, plain)
+		formatted = append(formatted, "<p>"+markup+"</p>")
+	}
+	return &matrixMessage{
+		MsgType:       "m.notice",
+		Body:          strings.Join(text, "\n\n"),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.Join(formatted, ""),
+	}
+}
+
+// byline names the feed and the authors of an item.
+func byline(userFeed *Feed, item *UnprocessedItem) string {
+	if authors := itemAuthors(item); authors != "" {
+		return feedTitle(userFeed) + " · " + authors
+	}
+	return feedTitle(userFeed)
+}
+
+// itemLink is the URL of an item if it is safe to link to.
+func itemLink(item *UnprocessedItem) string {
+	link, ok := defaultSanitizePolicy.safeURL(item.URL, nil)
+	if !ok || !strings.HasPrefix(link, "http") {
+		return ""
+	}
+	return link
+}
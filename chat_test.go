@@ -0,0 +1,211 @@
+package rss_reader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func chatItems() []*UnprocessedItem {
+	return []*UnprocessedItem{
+		{
+			GUID:        "https://example.com/1",
+			URL:         "https://example.com/1",
+			Title:       "Tom & Jerry <3",
+			Description: "<p>A <b>bold</b> move</p>",
+			Authors:     []*Author{{Name: "Ann"}},
+			Published:   time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+			LeadImage:   &LeadImage{URL: "https://example.com/1.jpg"},
+		},
+		{GUID: "https://example.com/2", URL: "javascript:alert(1)", Title: "Two", Summary: "Short *summary*."},
+	}
+}
+
+func Test_newSlackMessage(t *testing.T) {
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	feed.Title = "Example"
+	msg := newSlackMessage(feed, chatItems())
+
+	if msg.Text != "2 new items from Example" {
+		t.Errorf("unexpected fallback text %q", msg.Text)
+	}
+	if len(msg.Blocks) != 5 || msg.Blocks[2].Type != "divider" {
+		t.Fatalf("expected section, context, divider, section, context, got %d blocks", len(msg.Blocks))
+	}
+	if got := msg.Blocks[0].Text.Text; got != "*<https://example.com/1|Tom &amp; Jerry &lt;3>*\nA bold move" {
+		t.Errorf("unexpected section %q", got)
+	}
+	if msg.Blocks[0].Accessory == nil || msg.Blocks[0].Accessory.ImageURL != "https://example.com/1.jpg" {
+		t.Errorf("expected the lead image, got %+v", msg.Blocks[0].Accessory)
+	}
+	if got := msg.Blocks[1].Elements[0].Text; got != "Example · Ann" {
+		t.Errorf("unexpected context %q", got)
+	}
+	if got := msg.Blocks[3].Text.Text; got != "*Two*\nShort *summary*." {
+		t.Errorf("expected no link to an unsafe URL, got %q", got)
+	}
+}
+
+func Test_newDiscordMessage(t *testing.T) {
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	msg := newDiscordMessage(&DiscordConfig{Username: "reader"}, feed, chatItems())
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"allowed_mentions":{"parse":[]}`) {
+		t.Errorf("expected mentions turned off, got %s", body)
+	}
+	first, second := msg.Embeds[0], msg.Embeds[1]
+	if first.Description != "A **bold** move" || first.Timestamp != "2024-05-01T08:00:00Z" || first.Author.Name != "Ann" {
+		t.Errorf("unexpected embed %+v", first)
+	}
+	if first.Footer.Text != "https://example.com/feed" || first.Thumbnail.URL != "https://example.com/1.jpg" {
+		t.Errorf("unexpected footer or thumbnail %+v %+v", first.Footer, first.Thumbnail)
+	}
+	if second.URL != "" || second.Description != `Short \*summary\*.` {
+		t.Errorf("unexpected embed %+v", second)
+	}
+}
+
+func Test_splitEmbeds(t *testing.T) {
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	feed.Title = strings.Repeat("feed ", 100)
+
+	var long, short []*UnprocessedItem
+	for i := range 12 {
+		long = append(long, &UnprocessedItem{
+			GUID:    fmt.Sprint(i),
+			Title:   strings.Repeat("title ", 100),
+			Summary: strings.Repeat("a *b* ", 200),
+			Authors: []*Author{{Name: strings.Repeat("author ", 100)}},
+		})
+		short = append(short, &UnprocessedItem{GUID: fmt.Sprint(i), Title: "short"})
+	}
+
+	batches := splitEmbeds(feed, long)
+	count := 0
+	for _, batch := range batches {
+		msg := newDiscordMessage(&DiscordConfig{}, feed, batch)
+		text := 0
+		for _, embed := range msg.Embeds {
+			text += embed.textLen()
+		}
+		if text > discordMaxEmbedText {
+			t.Errorf("expected at most %d characters of embeds, got %d in %d embeds", discordMaxEmbedText, text, len(batch))
+		}
+		count += len(batch)
+	}
+	if count != len(long) || len(batches) < 3 {
+		t.Errorf("expected the long items in several messages, got %d items in %d", count, len(batches))
+	}
+
+	if batches := splitEmbeds(feed, short); len(batches) != 2 || len(batches[0]) != discordMaxEmbeds {
+		t.Errorf("expected short items in messages of %d embeds, got %d messages", discordMaxEmbeds, len(batches))
+	}
+}
+
+func Test_newMatrixMessage(t *testing.T) {
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	feed.Title = "Example"
+	msg := newMatrixMessage(feed, chatItems()[:1])
+
+	if msg.MsgType != "m.notice" || msg.Format != "org.matrix.custom.html" {
+		t.Errorf("unexpected message type %q %q", msg.MsgType, msg.Format)
+	}
+	if want := "Tom & Jerry <3\nhttps://example.com/1\nExample · Ann\nA bold move"; msg.Body != want {
+		t.Errorf("expected body %q, got %q", want, msg.Body)
+	}
+	want := `<p><b><a href="https://example.com/1">Tom &amp; Jerry &lt;3</a></b><br><i>Example · Ann</i><br>A <b>bold</b> move</p>`
+	if msg.FormattedBody != want {
+		t.Errorf("expected formatted body %q, got %q", want, msg.FormattedBody)
+	}
+}
+
+func Test_matrixNotifier(t *testing.T) {
+	feed, _, dead := webhookFixture(t)
+	items := chatItems()
+
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		paths = append(paths, r.URL.EscapedPath())
+		if len(paths) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"errcode":"M_LIMIT_EXCEEDED","retry_after_ms":1}`)
+			return
+		}
+		io.WriteString(w, `{"event_id":"$1"}`)
+	}))
+	defer srv.Close()
+
+	notifier := newMatrixNotifier(&MatrixConfig{Homeserver: srv.URL + "/", AccessToken: "token", Room: "!room:example.org"}, dead)
+	notifier.endpoint.interval = 0
+	if err := notifier.Notify(context.Background(), feed, items); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/" + batchKey(feed, items)
+	if len(paths) != 2 || paths[0] != want || paths[1] != want {
+		t.Errorf("expected the same transaction twice at %s, got %v", want, paths)
+	}
+
+	unconfigured := newMatrixNotifier(&MatrixConfig{Homeserver: srv.URL}, dead)
+	if err := unconfigured.Notify(context.Background(), feed, items); err == nil {
+		t.Error("expected an error without room and token")
+	}
+}
+
+func Test_retryHint(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		want   time.Duration
+	}{
+		{"Header", "2", `{"retry_after": 5}`, 2 * time.Second},
+		{"Discord", "", `{"message": "You are being rate limited.", "retry_after": 0.5}`, 500 * time.Millisecond},
+		{"Matrix", "", `{"errcode": "M_LIMIT_EXCEEDED", "retry_after_ms": 1500}`, 1500 * time.Millisecond},
+		{"None", "", "Too Many Requests", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Retry-After", tt.header)
+			}
+			if got := retryHint(header, []byte(tt.body)); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_chunkItems(t *testing.T) {
+	items := make([]*UnprocessedItem, 7)
+	var sizes []int
+	for _, batch := range chunkItems(items, 3) {
+		sizes = append(sizes, len(batch))
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[2] != 1 {
+		t.Errorf("unexpected batches %v", sizes)
+	}
+	if batches := chunkItems(nil, 3); len(batches) != 0 {
+		t.Errorf("expected no batches, got %d", len(batches))
+	}
+}
@@ -0,0 +1,192 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	defaultCommandTimeout = 60 * time.Second
+	defaultCommandCPU     = 30
+	defaultCommandMemory  = 512 << 20
+	defaultCommandFiles   = 64
+	defaultCommandFSize   = 16 << 20
+	maxStderrSize         = 4 << 10
+
+	// the shell of commandLine exits with this status, like env(1) and
+	// nice(1) do for their own failures, and says so on stderr
+	commandLimitsStatus = 125
+	commandLimitsMarker = "rss_reader: cannot set command limits"
+)
+
+var (
+	ErrCommandNotConfigured = errors.New("command feed has no executable")
+	ErrCommandFailed        = errors.New("command failed")
+	ErrCommandOutputTooBig  = errors.New("command output is too big")
+	ErrCommandLimits        = errors.New("can't limit command resources")
+)
+
+// commandEnv is all a producer gets from our environment; anything else,
+// credentials included, has to be listed in CommandConfig.Env explicitly.
+var commandEnv = []string{
+	"PATH=/usr/local/bin:/usr/bin:/bin",
+	"LANG=C.UTF-8",
+}
+
+// commandFeed runs the configured executable and parses RSS, Atom or JSON
+// Feed from its stdout. The process is killed when ctx is cancelled or the
+// timeout passes; its exit status and stderr end up in the log.
+func commandFeed(ctx context.Context, feedURL string, config *CommandConfig, log *slog.Logger) (*gofeed.Feed, error) {
+	// too much output kills the producer at once instead of leaving it
+	// blocked on a full pipe until its timeout
+	runCtx, kill := context.WithCancelCause(ctx)
+	defer kill(nil)
+
+	var stdout bytes.Buffer
+	stderr, err := runCommand(runCtx, config, nil, &limitedWriter{w: &stdout, n: maxResponseSize, overflow: kill})
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, ErrCommandNotConfigured) || errors.Is(err, ErrCommandOutputTooBig) {
+			return nil, err
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			log.Error("command feed failed", "url", feedURL, "exit_code", exitErr.ExitCode(), "stderr", stderr)
+		} else {
+			log.Error("command feed failed", "url", feedURL, "error", err, "stderr", stderr)
+		}
+		return nil, err
+	}
+	if stderr != "" {
+		log.Warn("command feed wrote to stderr", "url", feedURL, "stderr", stderr)
+	}
+
+	feed, err := gofeed.NewParser().Parse(&stdout)
+	if err != nil {
+		return nil, err
+	}
+	// producers often leave out the feed's "updated", the items version it
+	if feed.Updated == "" {
+		feed.Updated = itemsFingerprint(feed.Items)
+	}
+	return feed, nil
+}
+
+// runCommand runs the executable of config the way every command runs: in
+// the temporary directory with the environment of commandEnv and config,
+// in its own process group, under its rlimits and killed after its
+// timeout. stdin and stdout may be nil. It returns the tail of stderr.
+func runCommand(ctx context.Context, config *CommandConfig, stdin io.Reader, stdout io.Writer) (string, error) {
+	if config == nil || config.Path == "" {
+		return "", ErrCommandNotConfigured
+	}
+
+	timeout := defaultCommandTimeout
+	if config.Timeout > 0 {
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	name, args := commandLine(config)
+	cmd := exec.CommandContext(cmdCtx, name, args...)
+	cmd.Env = append(append([]string{"HOME=" + os.TempDir()}, commandEnv...), config.Env...)
+	cmd.Dir = os.TempDir()
+	cmd.WaitDelay = time.Second
+
+	stderr := &tailBuffer{max: maxStderrSize}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	prepareCommand(cmd)
+
+	err := cmd.Run()
+
+	switch {
+	case ctx.Err() != nil:
+		return stderr.String(), context.Cause(ctx)
+	case errors.Is(cmdCtx.Err(), context.DeadlineExceeded):
+		return stderr.String(), fmt.Errorf("%w: timed out after %s", ErrCommandFailed, timeout)
+	case err == nil:
+		return stderr.String(), nil
+	case limitsFailed(err, stderr.String()):
+		return stderr.String(), ErrCommandLimits
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stderr.String(), fmt.Errorf("%w: %w", ErrCommandFailed, exitErr)
+	}
+	return stderr.String(), err
+}
+
+// limitsFailed tells whether the shell of commandLine gave up on setting
+// the limits, before the producer ran.
+func limitsFailed(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == commandLimitsStatus &&
+		strings.Contains(stderr, commandLimitsMarker)
+}
+
+// limitedWriter refuses to grow w past n bytes. The write that would is
+// failed with ErrCommandOutputTooBig, which is also passed to overflow.
+type limitedWriter struct {
+	w        *bytes.Buffer
+	n        int
+	overflow context.CancelCauseFunc
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.w.Len()+len(p) > l.n {
+		if l.overflow != nil {
+			l.overflow(ErrCommandOutputTooBig)
+		}
+		return 0, ErrCommandOutputTooBig
+	}
+	return l.w.Write(p)
+}
+
+// tailBuffer keeps the last max bytes written to it, which is where the
+// reason of a failure usually is.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) Len() int {
+	return len(t.buf)
+}
+
+func (t *tailBuffer) String() string {
+	return string(bytes.TrimSpace(t.buf))
+}
+
+func (c *CommandConfig) limits() (cpu, memory, files, fsize uint64) {
+	cpu, memory, files, fsize = defaultCommandCPU, defaultCommandMemory, defaultCommandFiles, defaultCommandFSize
+	if c.MaxCPU > 0 {
+		cpu = uint64(c.MaxCPU)
+	}
+	if c.MaxMemory > 0 {
+		memory = uint64(c.MaxMemory)
+	}
+	if c.MaxFiles > 0 {
+		files = uint64(c.MaxFiles)
+	}
+	return cpu, memory, files, fsize
+}
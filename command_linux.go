@@ -0,0 +1,34 @@
+//go:build linux
+
+package rss_reader
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// commandShell sets the rlimits of a producer before it is exec'd.
+var commandShell = "/bin/sh"
+
+// prepareCommand puts the producer into its own process group, so a timeout
+// kills the scripts it started as well.
+func prepareCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// commandLine is the command that runs the producer of config under its
+// rlimits. os/exec has no hook between fork and exec, so a shell sets them
+// with ulimit and execs the producer in its place: the limits are there
+// before its first instruction and children inherit them. A limit the shell
+// can't set ends it with commandLimitsStatus, the producer never runs.
+func commandLine(config *CommandConfig) (string, []string) {
+	cpu, memory, files, fsize := config.limits()
+	// one limit per ulimit, as dash wants it; -v is in KiB, -f in 512 byte blocks
+	script := fmt.Sprintf(`ulimit -t %d && ulimit -v %d && ulimit -n %d && ulimit -f %d || { echo '%s' >&2; exit %d; }
+exec "$0" "$@"`, cpu, memory>>10, files, fsize>>9, commandLimitsMarker, commandLimitsStatus)
+	return commandShell, append([]string{"-c", script, config.Path}, config.Args...)
+}
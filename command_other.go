@@ -0,0 +1,15 @@
+//go:build !linux
+
+package rss_reader
+
+import (
+	"os/exec"
+)
+
+func prepareCommand(cmd *exec.Cmd) {}
+
+// commandLine runs the producer as it is where rlimits are not set; the
+// timeout still applies.
+func commandLine(config *CommandConfig) (string, []string) {
+	return config.Path, config.Args
+}
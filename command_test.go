@@ -0,0 +1,151 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestScript(t *testing.T, body string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not available")
+	}
+	path := filepath.Join(t.TempDir(), "producer.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_commandFeed(t *testing.T) {
+	t.Setenv("RSS_READER_SECRET", "leaked")
+
+	t.Run("Success", func(t *testing.T) {
+		script := writeTestScript(t, `cat <<EOF
+<rss version="2.0"><channel><title>Internal</title>
+<item><guid>$1</guid><title>secret=[$RSS_READER_SECRET] token=[$TOKEN]</title></item>
+</channel></rss>
+EOF
+`)
+		userFeed := newFeed(FEED_TYPE_COMMAND, "command:internal")
+		userFeed.Command = &CommandConfig{Path: script, Args: []string{"build-42"}, Env: []string{"TOKEN=t1"}}
+
+		err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, setupLogger(io.Discard))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(userFeed.UnprocessedItems) != 1 || userFeed.UnprocessedItems[0].GUID != "build-42" {
+			t.Fatalf("expected one item from stdout, got %+v", userFeed.UnprocessedItems)
+		}
+
+		feed, _ := commandFeed(context.Background(), userFeed.Url, userFeed.Command, setupLogger(io.Discard))
+		if title := feed.Items[0].Title; title != "secret=[] token=[t1]" {
+			t.Errorf("expected scrubbed environment, got %q", title)
+		}
+	})
+
+	t.Run("ExitStatus", func(t *testing.T) {
+		script := writeTestScript(t, "echo 'backend is down' >&2\nexit 3\n")
+
+		var logBuf bytes.Buffer
+		_, err := commandFeed(context.Background(), "command:broken", &CommandConfig{Path: script}, setupLogger(&logBuf))
+		if !errors.Is(err, ErrCommandFailed) {
+			t.Fatalf("expected ErrCommandFailed, got %v", err)
+		}
+		output := logBuf.String()
+		if !strings.Contains(output, "exit_code=3") || !strings.Contains(output, "backend is down") {
+			t.Errorf("expected exit status and stderr in log, got:\n%s", output)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		script := writeTestScript(t, "sleep 5\n")
+
+		start := time.Now()
+		_, err := commandFeed(context.Background(), "command:slow", &CommandConfig{Path: script, Timeout: 1}, setupLogger(io.Discard))
+		if !errors.Is(err, ErrCommandFailed) {
+			t.Fatalf("expected ErrCommandFailed on timeout, got %v", err)
+		}
+		if time.Since(start) > 4*time.Second {
+			t.Errorf("expected the command to be killed on timeout")
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		script := writeTestScript(t, "sleep 5\n")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+		}()
+
+		_, err := commandFeed(ctx, "command:slow", &CommandConfig{Path: script}, setupLogger(io.Discard))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("OutputTooBig", func(t *testing.T) {
+		script := writeTestScript(t, "yes\n")
+
+		start := time.Now()
+		_, err := commandFeed(context.Background(), "command:chatty", &CommandConfig{Path: script, Timeout: 10}, setupLogger(io.Discard))
+		if !errors.Is(err, ErrCommandOutputTooBig) {
+			t.Fatalf("expected ErrCommandOutputTooBig, got %v", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("expected the command to be killed on overflow, not on timeout")
+		}
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		_, err := commandFeed(context.Background(), "command:none", nil, setupLogger(io.Discard))
+		if !errors.Is(err, ErrCommandNotConfigured) {
+			t.Fatalf("expected ErrCommandNotConfigured, got %v", err)
+		}
+	})
+}
+
+func Test_commandFeedLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are applied on linux only")
+	}
+
+	// the limits are in place before the producer's first line
+	script := writeTestScript(t, `cat <<EOF
+<rss version="2.0"><channel><title>Limits</title>
+<item><guid>limits</guid><title>$(ulimit -t) $(ulimit -n) $(ulimit -v)</title></item>
+</channel></rss>
+EOF
+`)
+	feed, err := commandFeed(context.Background(), "command:limits", &CommandConfig{Path: script, MaxCPU: 7, MaxFiles: 32, MaxMemory: 256 << 20}, setupLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if title := feed.Items[0].Title; title != "7 32 262144" {
+		t.Errorf("expected cpu, open files and memory limits '7 32 262144', got %q", title)
+	}
+
+	t.Run("Failed", func(t *testing.T) {
+		// a shell that can't set the limits never runs the producer
+		shell := commandShell
+		commandShell = writeTestScript(t, "echo '"+commandLimitsMarker+"' >&2\nexit 125\n")
+		t.Cleanup(func() { commandShell = shell })
+
+		_, err := commandFeed(context.Background(), "command:limits", &CommandConfig{Path: script}, setupLogger(io.Discard))
+		if !errors.Is(err, ErrCommandLimits) {
+			t.Fatalf("expected ErrCommandLimits, got %v", err)
+		}
+		if err := runAlertCommand(context.Background(), &CommandConfig{Path: script}, []byte("{}\n")); !errors.Is(err, ErrCommandLimits) {
+			t.Errorf("expected ErrCommandLimits for an alert command, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,671 @@
+package rss_reader
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// command is a subcommand of rss_reader, selected by the first argument.
+// args holds everything after the command name.
+type command func(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int
+
+var commands = map[string]command{
+	"add":         addCommand,
+	"add-mail":    addMailCommand,
+	"backfill":    backfillCommand,
+	"deadletter":  deadletterCommand,
+	"digest":      digestCommand,
+	"download":    downloadCommand,
+	"listen":      listenCommand,
+	"pending":     pendingCommand,
+	"rules":       rulesCommand,
+	"test-scrape": testScrapeCommand,
+}
+
+func commandNames() []string {
+	return sortedKeys(commands)
+}
+
+func newFlagSet(name string, stdout io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	return fs
+}
+
+func loadUserFeeds(userID string, feedsIO FeedsIO, log *slog.Logger) (Feeds, string, int) {
+	userFeedsFile, err := feedsIO.GetFeedsFile(GetSHA256(userID))
+	if err != nil {
+		log.Error(err.Error())
+		return Feeds{}, "", E_GET_FEED_FILE
+	}
+
+	feeds, err := feedsIO.LoadFeeds(userFeedsFile)
+	if err != nil {
+		log.Error(err.Error())
+		return Feeds{}, "", E_READ_FEED_FILE
+	}
+	feeds.attachSettings()
+
+	return feeds, userFeedsFile, 0
+}
+
+// addCommand subscribes a user to a feed found behind a site or feed URL:
+//
+//	rss_reader add [-list] [-pick N] <user_email> <url>
+//
+// Candidates are printed best first; the first one is stored unless -pick
+// chooses another. -list only prints them.
+func addCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	fs := newFlagSet("add", stdout)
+	listOnly := fs.Bool("list", false, "only list discovered feeds")
+	pick := fs.Int("pick", 1, "number of the candidate to subscribe to")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		log.Info("Usage rss_reader add [-list] [-pick N] <user_email> <url>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	userID, siteURL := fs.Arg(0), fs.Arg(1)
+	if !isValidURL(siteURL) {
+		log.Error("invalid url", "url", siteURL)
+		return E_BAD_COMMAND_ARGS
+	}
+
+	candidates, err := discoverFeeds(context.Background(), siteURL)
+	if err != nil {
+		log.Error("feed discovery failed", "url", siteURL, "error", err)
+		return E_COMMAND_FAILURE
+	}
+
+	for i, c := range candidates {
+		status := "verified"
+		if !c.Verified {
+			status = "unverified"
+		}
+		fmt.Fprintf(stdout, "%d. [%s] %s %s (%s, %s)\n", i+1, c.Format, c.Title, c.URL, c.Source, status)
+	}
+
+	if *listOnly {
+		return 0
+	}
+
+	if *pick < 1 || *pick > len(candidates) {
+		log.Error("no such candidate", "pick", *pick, "candidates", len(candidates))
+		return E_BAD_COMMAND_ARGS
+	}
+	chosen := candidates[*pick-1]
+	chosen.URL = canonicalURL(chosen.URL)
+
+	feeds, userFeedsFile, code := loadUserFeeds(userID, feedsIO, log)
+	if code != 0 {
+		return code
+	}
+
+	if feeds.findFeed(chosen.URL) != nil {
+		log.Info("already subscribed", "url", chosen.URL)
+		return 0
+	}
+
+	feeds.Items = append(feeds.Items, newFeed(FEED_TYPE_RSS, chosen.URL))
+
+	if err := feedsIO.SaveUpdates(feeds, userFeedsFile); err != nil {
+		log.Error(err.Error())
+		return E_UPDATE_FEED_FILE
+	}
+
+	log.Info("subscribed", "url", chosen.URL, "format", chosen.Format, "title", chosen.Title)
+
+	return 0
+}
+
+// addMailCommand subscribes a user to a local Maildir or mbox:
+//
+//	rss_reader add-mail [-by-sender] <user_email> <path>
+//
+// With -by-sender every sender found in the mailbox gets its own virtual feed.
+func addMailCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	fs := newFlagSet("add-mail", stdout)
+	bySender := fs.Bool("by-sender", false, "add one virtual feed per sender")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		log.Info("Usage rss_reader add-mail [-by-sender] <user_email> <maildir_or_mbox>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	userID := fs.Arg(0)
+	path, err := filepath.Abs(fs.Arg(1))
+	if err != nil {
+		log.Error(err.Error())
+		return E_BAD_COMMAND_ARGS
+	}
+
+	senders, err := mailSenders(path)
+	if err != nil {
+		log.Error("can't read mailbox", "path", path, "error", err)
+		return E_COMMAND_FAILURE
+	}
+
+	var newFeeds []*Feed
+	if *bySender {
+		for _, sender := range sortedKeys(senders) {
+			feed := newFeed(FEED_TYPE_MAILDIR, path+"#"+sender)
+			feed.Mail = &MailConfig{Sender: sender}
+			newFeeds = append(newFeeds, feed)
+		}
+	} else {
+		newFeeds = append(newFeeds, newFeed(FEED_TYPE_MAILDIR, path))
+	}
+
+	feeds, userFeedsFile, code := loadUserFeeds(userID, feedsIO, log)
+	if code != 0 {
+		return code
+	}
+
+	existing := make(map[string]bool, len(feeds.Items))
+	for _, feed := range feeds.Items {
+		existing[feed.Hash] = true
+	}
+
+	added := 0
+	for _, feed := range newFeeds {
+		if existing[feed.Hash] {
+			continue
+		}
+		feeds.Items = append(feeds.Items, feed)
+		added++
+		if feed.Mail != nil {
+			fmt.Fprintf(stdout, "%s (%d messages)\n", feed.Mail.Sender, senders[feed.Mail.Sender])
+		}
+	}
+
+	if added == 0 {
+		log.Info("already subscribed", "path", path)
+		return 0
+	}
+
+	if err := feedsIO.SaveUpdates(feeds, userFeedsFile); err != nil {
+		log.Error(err.Error())
+		return E_UPDATE_FEED_FILE
+	}
+
+	log.Info("subscribed", "path", path, "feeds", added)
+
+	return 0
+}
+
+// backfillCommand imports older items of a subscribed feed:
+//
+//	rss_reader backfill [-limit N] [-since YYYY-MM-DD] [-no-queue] <user_email> <feed_url>
+//
+// With -no-queue the history is only marked as seen, nothing gets delivered.
+func backfillCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	opts := backfillOptions{}
+
+	fs := newFlagSet("backfill", stdout)
+	fs.IntVar(&opts.Limit, "limit", defaultBackfillLimit, "maximum number of items to import")
+	since := fs.String("since", "", "skip items published before this date")
+	noQueue := fs.Bool("no-queue", false, "record items as seen without queueing them")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		log.Info("Usage rss_reader backfill [-limit N] [-since YYYY-MM-DD] [-no-queue] <user_email> <feed_url>")
+		return E_BAD_COMMAND_ARGS
+	}
+	opts.Queue = !*noQueue
+
+	if *since != "" {
+		t, ok := parseDate(*since)
+		if !ok {
+			log.Error("invalid date", "since", *since)
+			return E_BAD_COMMAND_ARGS
+		}
+		opts.Since = t
+	}
+
+	userID, feedURL := fs.Arg(0), fs.Arg(1)
+
+	feeds, userFeedsFile, code := loadUserFeeds(userID, feedsIO, log)
+	if code != 0 {
+		return code
+	}
+
+	userFeed := feeds.findFeed(feedURL)
+	if userFeed == nil {
+		log.Error("not subscribed", "url", feedURL)
+		return E_BAD_COMMAND_ARGS
+	}
+
+	result, err := backfillFeed(context.Background(), userFeed, opts, log)
+	if err != nil {
+		// keep what was imported before the failure
+		log.Error("backfill failed", "url", feedURL, "error", err)
+	}
+
+	if result.Items > 0 {
+		if err := feedsIO.SaveUpdates(feeds, userFeedsFile); err != nil {
+			log.Error(err.Error())
+			return E_UPDATE_FEED_FILE
+		}
+	}
+
+	fmt.Fprintf(stdout, "backfilled %d items from %d pages\n", result.Items, result.Pages)
+
+	if err != nil {
+		return E_COMMAND_FAILURE
+	}
+	return 0
+}
+
+// downloadCommand fetches the media enclosures of a user's queued items
+// into the user's service directory, as set up in the media settings:
+//
+//	rss_reader download [-limit N] <user_email>
+func downloadCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	fs := newFlagSet("download", stdout)
+	limit := fs.Int("limit", 0, "maximum number of files to download, 0 for no limit")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		log.Info("Usage rss_reader download [-limit N] <user_email>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	feeds, userFeedsFile, code := loadUserFeeds(fs.Arg(0), feedsIO, log)
+	if code != 0 {
+		return code
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result, err := downloadMedia(ctx, &feeds, mediaDir(userFeedsFile), *limit, log)
+	if errors.Is(err, ErrMediaNotConfigured) {
+		log.Error(err.Error())
+		return E_BAD_COMMAND_ARGS
+	}
+
+	// keep the downloads that made it before a failure
+	if saveErr := feedsIO.SaveUpdates(feeds, userFeedsFile); saveErr != nil {
+		log.Error(saveErr.Error())
+		return E_UPDATE_FEED_FILE
+	}
+
+	fmt.Fprintf(stdout, "downloaded %d files (%d duplicates), %d bytes, removed %d expired\n",
+		result.Downloaded, result.Deduped, result.Bytes, result.Removed)
+
+	if err != nil {
+		return E_COMMAND_FAILURE
+	}
+	return 0
+}
+
+// listenCommand keeps a user's feeds updated until interrupted. Feeds with a
+// WebSub hub get pushes on <callback_base_url>/websub/<feed hash>, which must
+// reach -addr; the rest is polled every -poll. New items are delivered and
+// the digest is sent after every update, as in a run:
+//
+//	rss_reader listen [-addr :8080] [-poll 30m] <user_email> <callback_base_url>
+func listenCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	fs := newFlagSet("listen", stdout)
+	addr := fs.String("addr", ":8080", "address of the callback listener")
+	pollInterval := fs.Duration("poll", defaultPollInterval, "polling interval for feeds without a hub")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 || *pollInterval <= 0 {
+		log.Info("Usage rss_reader listen [-addr :8080] [-poll 30m] <user_email> <callback_base_url>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	userID, callbackBase := fs.Arg(0), fs.Arg(1)
+	if !isValidURL(callbackBase) {
+		log.Error("invalid url", "url", callbackBase)
+		return E_BAD_COMMAND_ARGS
+	}
+
+	feeds, userFeedsFile, code := loadUserFeeds(userID, feedsIO, log)
+	if code != 0 {
+		return code
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener := newWebSubListener(&feeds, userFeedsFile, feedsIO, feedFetcher, callbackBase, log)
+	listener.pollInterval = *pollInterval
+
+	server := &http.Server{Addr: *addr, Handler: listener, ReadHeaderTimeout: 10 * time.Second}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	log.Info("websub listener started", "addr", *addr, "callback", callbackBase)
+
+	runDone := make(chan struct{})
+	go func() {
+		listener.run(ctx)
+		close(runDone)
+	}()
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Info(LOG_INFO_GRACEFUL_SHUTDOWN)
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Error("websub listener failed", "error", err)
+			exitCode = E_COMMAND_FAILURE
+		}
+		stop()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	<-runDone
+
+	listener.save()
+
+	log.Info("session done")
+
+	return exitCode
+}
+
+// rulesCommand works with a user's rules. "rules test" replays the current
+// items of a feed against the rules for it and prints what they decide,
+// without changing anything:
+//
+//	rss_reader rules test <user_email> <feed_url>
+func rulesCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	if len(args) == 0 || args[0] != "test" {
+		log.Info("Usage rss_reader rules test <user_email> <feed_url>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	fs := newFlagSet("rules test", stdout)
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 2 {
+		log.Info("Usage rss_reader rules test <user_email> <feed_url>")
+		return E_BAD_COMMAND_ARGS
+	}
+	userID, feedURL := fs.Arg(0), fs.Arg(1)
+
+	feeds, _, code := loadUserFeeds(userID, feedsIO, log)
+	if code != 0 {
+		return code
+	}
+
+	userFeed := feeds.findFeed(feedURL)
+	if userFeed == nil {
+		log.Error("not subscribed", "url", feedURL)
+		return E_BAD_COMMAND_ARGS
+	}
+
+	rules := userFeed.feedRules()
+	invalid := 0
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			fmt.Fprintf(stdout, "invalid rule: %v\n", err)
+			invalid++
+		}
+	}
+
+	remoteFeed, err := fetchFeed(context.Background(), feedFetcher, userFeed, log)
+	if err != nil {
+		log.Error("can't fetch feed", "url", feedURL, "error", err)
+		return E_COMMAND_FAILURE
+	}
+
+	now := time.Now()
+	dropped, changed := 0, 0
+	for _, remoteItem := range remoteFeed.Items {
+		item := newUnprocessedItem(remoteItem)
+		outcome := applyRules(rules, userFeed, item, now)
+
+		verdict, details := "keep", ""
+		switch {
+		case outcome.Drop:
+			verdict = "drop"
+			dropped++
+		case len(outcome.Matched) > 0:
+			verdict = "change"
+			details = fmt.Sprintf(" tags=%s priority=%d delay=%s", strings.Join(outcome.Tags, ","), outcome.Priority, outcome.Delay)
+			changed++
+		}
+		if len(outcome.Matched) > 0 {
+			details += " [" + strings.Join(outcome.Matched, ", ") + "]"
+		}
+		for _, err := range outcome.Errors {
+			if !errors.Is(err, ErrRuleAction) && !errors.Is(err, ErrRuleSyntax) {
+				details += " error: " + err.Error()
+			}
+		}
+		fmt.Fprintf(stdout, "%-6s %s%s\n", verdict, firstNRunes(item.Title, 64), details)
+	}
+	fmt.Fprintf(stdout, "%d items: %d dropped, %d changed\n", len(remoteFeed.Items), dropped, changed)
+
+	if invalid > 0 {
+		return E_BAD_COMMAND_ARGS
+	}
+	return 0
+}
+
+// digestCommand sends a user's email digest if it is due, or right away
+// with -force:
+//
+//	rss_reader digest [-force] <user_email>
+func digestCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	fs := newFlagSet("digest", stdout)
+	force := fs.Bool("force", false, "send even if the digest is not due")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		log.Info("Usage rss_reader digest [-force] <user_email>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	feeds, userFeedsFile, code := loadUserFeeds(fs.Arg(0), feedsIO, log)
+	if code != 0 {
+		return code
+	}
+	if feeds.Settings == nil || feeds.Settings.Digest == nil {
+		log.Error(ErrDigestNotConfigured.Error())
+		return E_BAD_COMMAND_ARGS
+	}
+
+	now := time.Now()
+	due, err := feeds.digestDue(now)
+	if err != nil {
+		log.Error("invalid digest schedule", "error", err)
+		return E_BAD_COMMAND_ARGS
+	}
+	if !due && !*force {
+		next, _ := feeds.Settings.Digest.next(feeds.LastDigest, now)
+		fmt.Fprintf(stdout, "digest not due before %s\n", next.Format(time.RFC3339))
+		return 0
+	}
+
+	sent, err := feeds.sendDigest(context.Background(), now, log)
+	if err != nil {
+		log.Error("digest failed", "error", err)
+		return E_COMMAND_FAILURE
+	}
+	if err := feedsIO.SaveUpdates(feeds, userFeedsFile); err != nil {
+		log.Error(err.Error())
+		return E_UPDATE_FEED_FILE
+	}
+	fmt.Fprintf(stdout, "sent %d items\n", sent)
+	return 0
+}
+
+// pendingCommand lists a user's queued items by topic, across feeds:
+//
+//	rss_reader pending [-topic <topic>] <user_email>
+func pendingCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	fs := newFlagSet("pending", stdout)
+	only := fs.String("topic", "", "list only the items of this topic")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		log.Info("Usage rss_reader pending [-topic <topic>] <user_email>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	feeds, _, code := loadUserFeeds(fs.Arg(0), feedsIO, log)
+	if code != 0 {
+		return code
+	}
+
+	byTopic := feeds.pendingByTopic()
+	topics := sortedKeys(byTopic)
+	if *only != "" {
+		topics = []string{*only}
+	}
+	for _, topic := range topics {
+		pending := byTopic[topic]
+		if topic == NO_TOPIC && *only == "" {
+			// listed last
+			continue
+		}
+		printPending(stdout, topic, pending)
+	}
+	if pending, ok := byTopic[NO_TOPIC]; ok && *only == "" {
+		printPending(stdout, NO_TOPIC, pending)
+	}
+	return 0
+}
+
+func printPending(stdout io.Writer, topic string, pending []pendingItem) {
+	fmt.Fprintf(stdout, "%s (%d)\n", topic, len(pending))
+	for _, p := range pending {
+		title := p.item.Title
+		if title == "" {
+			title = p.item.URL
+		}
+		fmt.Fprintf(stdout, "  %s <%s> from %s\n", firstNRunes(title, 64), p.item.URL, p.feed.Url)
+	}
+}
+
+// deadletterCommand lists a user's dead letters, or requeues or purges
+// them, all or those whose key starts with -key:
+//
+//	rss_reader deadletter [-requeue | -purge] [-key <key>] <user_email>
+func deadletterCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	fs := newFlagSet("deadletter", stdout)
+	requeue := fs.Bool("requeue", false, "send the items of the dead letters again")
+	purge := fs.Bool("purge", false, "delete the dead letters")
+	key := fs.String("key", "", "only the dead letters whose key starts with this")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || (*requeue && *purge) {
+		log.Info("Usage rss_reader deadletter [-requeue | -purge] [-key <key>] <user_email>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	feeds, userFeedsFile, code := loadUserFeeds(fs.Arg(0), feedsIO, log)
+	if code != 0 {
+		return code
+	}
+	dead := newDeadLetters(deadLetterFile(userFeedsFile))
+	entries, err := dead.list()
+	if err != nil {
+		log.Error("can't read dead letters", "error", err)
+		return E_COMMAND_FAILURE
+	}
+
+	var matched, kept []*DeadLetter
+	for _, entry := range entries {
+		if entry.matches(*key) {
+			matched = append(matched, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+
+	if !*requeue && !*purge {
+		for _, entry := range matched {
+			feedURL := entry.Feed
+			for _, feed := range feeds.Items {
+				if feed.Hash == entry.Feed {
+					feedURL = feed.Url
+				}
+			}
+			fmt.Fprintf(stdout, "%s %s %s %d items, %d attempts, from %s\n",
+				firstNRunes(entry.Key, 12), entry.Time.Format(time.RFC3339), entry.Consumer, len(entry.GUIDs), entry.Attempts, feedURL)
+			fmt.Fprintf(stdout, "  %s\n", entry.Error)
+		}
+		fmt.Fprintf(stdout, "%d dead letters\n", len(matched))
+		return 0
+	}
+
+	if *requeue {
+		now := time.Now()
+		requeued := 0
+		for _, entry := range matched {
+			count := feeds.requeue(entry, now)
+			if count == 0 {
+				// nothing to send again, the entry stays for a purge
+				fmt.Fprintf(stdout, "%s: items no longer queued\n", firstNRunes(entry.Key, 12))
+				kept = append(kept, entry)
+			}
+			requeued += count
+		}
+		if err := feedsIO.SaveUpdates(feeds, userFeedsFile); err != nil {
+			log.Error(err.Error())
+			return E_UPDATE_FEED_FILE
+		}
+		fmt.Fprintf(stdout, "requeued %d items\n", requeued)
+	}
+
+	if err := dead.replace(kept); err != nil {
+		log.Error("can't update dead letters", "error", err)
+		return E_COMMAND_FAILURE
+	}
+	if *purge {
+		fmt.Fprintf(stdout, "purged %d dead letters\n", len(matched))
+	}
+	return 0
+}
+
+// testScrapeCommand prints what a scrape feed would extract from a page:
+//
+//	rss_reader test-scrape -item <sel> [-title <sel>] [-link <sel>] [-date <sel>] [-summary <sel>] <page_url>
+func testScrapeCommand(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writer, log *slog.Logger) int {
+	config := &ScrapeConfig{}
+
+	fs := newFlagSet("test-scrape", stdout)
+	fs.StringVar(&config.Item, "item", "", "CSS selector of the item container")
+	fs.StringVar(&config.Title, "title", "", "CSS selector of the title (default: link text)")
+	fs.StringVar(&config.Link, "link", "", "CSS selector of the link (default: first a[href])")
+	fs.StringVar(&config.Date, "date", "", "CSS selector of the date")
+	fs.StringVar(&config.Summary, "summary", "", "CSS selector of the summary")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		log.Info("Usage rss_reader test-scrape -item <selector> [-title|-link|-date|-summary <selector>] <page_url>")
+		return E_BAD_COMMAND_ARGS
+	}
+
+	pageURL := fs.Arg(0)
+	if !isValidURL(pageURL) {
+		log.Error("invalid url", "url", pageURL)
+		return E_BAD_COMMAND_ARGS
+	}
+
+	feed, err := scrapeFeed(context.Background(), pageURL, config)
+	if err != nil {
+		log.Error("scrape failed", "url", pageURL, "error", err)
+		return E_COMMAND_FAILURE
+	}
+
+	fmt.Fprintf(stdout, "%s: %d items\n", feed.Title, len(feed.Items))
+	for i, item := range feed.Items {
+		fmt.Fprintf(stdout, "%d. %s\n", i+1, item.Title)
+		fmt.Fprintf(stdout, "   link: %s\n", item.Link)
+		if item.Published != "" {
+			parsed := "unparsed"
+			if item.PublishedParsed != nil {
+				parsed = item.PublishedParsed.UTC().Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Fprintf(stdout, "   date: %s (%s)\n", item.Published, parsed)
+		}
+		if item.Description != "" {
+			fmt.Fprintf(stdout, "   summary: %s\n", firstNRunes(item.Description, 120))
+		}
+	}
+
+	return 0
+}
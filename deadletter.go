@@ -0,0 +1,133 @@
+package rss_reader
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a delivery a consumer gave up on, kept with its payload so
+// it can be looked at. GUIDs are the items of Feed (a feed hash) that died
+// with it; requeueing them sends them again.
+type DeadLetter struct {
+	Time     time.Time       `json:"time"`
+	Channel  string          `json:"channel"`
+	Consumer string          `json:"consumer"`
+	Target   string          `json:"target"`
+	Key      string          `json:"key"`
+	Feed     string          `json:"feed"`
+	GUIDs    []string        `json:"guids"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// deadLetters is a user's dead-letter store, one JSON line per entry.
+type deadLetters struct {
+	file string
+	mu   sync.Mutex
+}
+
+func newDeadLetters(file string) *deadLetters {
+	return &deadLetters{file: file}
+}
+
+// deadLetterFile is where the dead letters of the user owning
+// userFeedsFile are kept.
+func deadLetterFile(userFeedsFile string) string {
+	return filepath.Join(userDir(userFeedsFile), "deadletter.jsonl")
+}
+
+func (d *deadLetters) add(entry *DeadLetter) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(d.file), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(d.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// list returns the entries in the order they were added. A missing store
+// is empty.
+func (d *deadLetters) list() ([]*DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	file, err := os.Open(d.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*DeadLetter
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), maxResponseSize)
+	for scanner.Scan() {
+		var entry DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, scanner.Err()
+}
+
+// replace rewrites the store with entries, in one rename so a crash
+// leaves either the old or the new store.
+func (d *deadLetters) replace(entries []*DeadLetter) error {
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(d.file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.file), filepath.Base(d.file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.file)
+}
+
+// matches tells whether the entry has the key, or one starting with it;
+// an empty key matches all entries.
+func (entry *DeadLetter) matches(key string) bool {
+	return strings.HasPrefix(entry.Key, key)
+}
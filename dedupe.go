@@ -0,0 +1,241 @@
+package rss_reader
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"math/bits"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultDedupeDistance      = 7
+	defaultDedupeRetentionDays = 7
+	maxStories                 = 10_000
+	// texts with fewer shingles are too short for a meaningful SimHash,
+	// only their URL is compared
+	minSimHashShingles = 6
+	shingleSize        = 2
+)
+
+// queuedCounts remembers how many items each feed has queued, so the items
+// an update adds can be told apart afterwards.
+func queuedCounts(feeds []*Feed) map[*Feed]int {
+	counts := make(map[*Feed]int, len(feeds))
+	for _, feed := range feeds {
+		counts[feed] = len(feed.UnprocessedItems)
+	}
+	return counts
+}
+
+type newStory struct {
+	feed  *Feed
+	order int
+	item  *UnprocessedItem
+	story *Story
+}
+
+// dedupeStories clusters the items queued since queued was taken with the
+// stories the user got recently, across all feeds. Only the first item of
+// a story stays queued, later ones are removed from their feeds and noted
+// on it as "also seen in". It does nothing unless the user turned dedupe
+// on, and returns the number of removed items.
+func (f *Feeds) dedupeStories(queued map[*Feed]int, now time.Time, log *slog.Logger) int {
+	if f.Settings == nil || f.Settings.Dedupe == nil {
+		return 0
+	}
+	config := f.Settings.Dedupe
+	f.pruneStories(now.Add(-config.retention()))
+
+	var fresh []newStory
+	for order, feed := range f.Items {
+		before, ok := queued[feed]
+		if !ok || before > len(feed.UnprocessedItems) {
+			continue
+		}
+		for _, item := range feed.UnprocessedItems[before:] {
+			fresh = append(fresh, newStory{feed: feed, order: order, item: item, story: newStoryOf(feed, item, now)})
+		}
+	}
+
+	// the earliest published item of a story is the one kept, undated
+	// items come last
+	sort.SliceStable(fresh, func(i, j int) bool {
+		a, b := fresh[i].item.Published, fresh[j].item.Published
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return fresh[i].order < fresh[j].order
+	})
+
+	removed := map[*UnprocessedItem]bool{}
+	for _, s := range fresh {
+		original := f.findStory(s.story, config.distance())
+		if original == nil {
+			f.Stories = append(f.Stories, s.story)
+			continue
+		}
+
+		// the duplicate's link and text lead to the original from now on
+		s.story.Feed, s.story.GUID = original.Feed, original.GUID
+		f.Stories = append(f.Stories, s.story)
+
+		removed[s.item] = true
+		ref := &StoryRef{Feed: s.feed.Url, URL: s.item.URL, GUID: s.item.GUID}
+		if item := f.findQueuedItem(original.Feed, original.GUID); item != nil {
+			item.AlsoSeenIn = append(item.AlsoSeenIn, ref)
+		}
+		log.Info("duplicate story", "url", s.item.URL, "feed", s.feed.Url, "original", original.URL)
+	}
+
+	if len(removed) > 0 {
+		for _, feed := range f.Items {
+			feed.UnprocessedItems = deleteItems(feed.UnprocessedItems, removed)
+		}
+	}
+	if len(f.Stories) > maxStories {
+		f.Stories = f.Stories[len(f.Stories)-maxStories:]
+	}
+	return len(removed)
+}
+
+func newStoryOf(feed *Feed, item *UnprocessedItem, now time.Time) *Story {
+	hash, shingles := simHash(item.Title + "\n" + htmlText(item.Description))
+	story := &Story{
+		Feed: feed.Hash,
+		GUID: item.GUID,
+		URL:  storyURL(item.URL),
+		Seen: now.UTC(),
+	}
+	if shingles >= minSimHashShingles {
+		story.SimHash = hash
+	}
+	return story
+}
+
+func (f *Feeds) findStory(story *Story, distance int) *Story {
+	for _, known := range f.Stories {
+		if story.URL != "" && known.URL == story.URL {
+			return known
+		}
+		if story.SimHash != 0 && known.SimHash != 0 && hammingDistance(story.SimHash, known.SimHash) <= distance {
+			return known
+		}
+	}
+	return nil
+}
+
+func (f *Feeds) findQueuedItem(feedHash, guid string) *UnprocessedItem {
+	for _, feed := range f.Items {
+		if feed.Hash != feedHash {
+			continue
+		}
+		for _, item := range feed.UnprocessedItems {
+			if item.GUID == guid {
+				return item
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Feeds) pruneStories(cutoff time.Time) {
+	kept := f.Stories[:0]
+	for _, story := range f.Stories {
+		if story.Seen.After(cutoff) {
+			kept = append(kept, story)
+		}
+	}
+	f.Stories = kept
+}
+
+func deleteItems(items []*UnprocessedItem, removed map[*UnprocessedItem]bool) []*UnprocessedItem {
+	kept := items[:0]
+	for _, item := range items {
+		if !removed[item] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// storyURL is the form of a link stories are compared by: the canonical
+// URL, regardless of scheme and trailing slash.
+func storyURL(rawURL string) string {
+	u, err := url.Parse(canonicalURL(rawURL))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	u.Scheme = "https"
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
+// simHash is a 64 bit SimHash over the word shingles of text: similar
+// texts get hashes that differ in few bits. It also returns the number of
+// shingles it was computed from.
+func simHash(text string) (uint64, int) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var weights [64]int
+	shingles := 0
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := mix64(h.Sum64())
+		for bit := range 64 {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+		shingles++
+	}
+
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash, shingles
+}
+
+// mix64 is the MurmurHash3 finalizer. FNV alone leaves the bits of similar
+// shingles correlated, which skews the SimHash.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func (c *DedupeConfig) distance() int {
+	if c.Distance <= 0 {
+		return defaultDedupeDistance
+	}
+	return c.Distance
+}
+
+func (c *DedupeConfig) retention() time.Duration {
+	days := c.RetentionDays
+	if days <= 0 {
+		days = defaultDedupeRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
@@ -0,0 +1,91 @@
+package rss_reader
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+const dedupeTestSummary = `The central bank raised its key interest rate by half a percentage point on Thursday,
+the largest increase in two decades, as policymakers try to bring down inflation that has
+climbed to its highest level since the early nineties and is squeezing household budgets.`
+
+func Test_dedupeStories(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	log := setupLogger(io.Discard)
+
+	original := &UnprocessedItem{GUID: "a1", URL: "https://News.example.com/story-1", Title: "Central bank raises rates", Description: dedupeTestSummary, Published: now.Add(-2 * time.Hour)}
+	rewritten := &UnprocessedItem{GUID: "b1", URL: "https://aggregator.example.org/r/123", Title: "Central bank raises rates - Aggregator", Description: dedupeTestSummary, Published: now.Add(-time.Hour)}
+	sameLink := &UnprocessedItem{GUID: "c1", URL: "https://news.example.com/story-1/#comments", Title: "Comments: rates", Published: now.Add(-time.Hour)}
+	unrelated := &UnprocessedItem{GUID: "c2", URL: "https://news.example.com/story-2", Title: "Local team wins the cup", Description: "The local football team won the national cup final on penalties after a goalless draw."}
+
+	feedA := newFeed(FEED_TYPE_RSS, "https://news.example.com/feed")
+	feedB := newFeed(FEED_TYPE_RSS, "https://aggregator.example.org/feed")
+	feedC := newFeed(FEED_TYPE_RSS, "https://other.example.net/feed")
+	feeds := &Feeds{Settings: &Settings{Dedupe: &DedupeConfig{}}, Items: []*Feed{feedB, feedC, feedA}}
+
+	queued := queuedCounts(feeds.Items)
+	feedA.UnprocessedItems = []*UnprocessedItem{original}
+	feedB.UnprocessedItems = []*UnprocessedItem{rewritten}
+	feedC.UnprocessedItems = []*UnprocessedItem{sameLink, unrelated}
+
+	if removed := feeds.dedupeStories(queued, now, log); removed != 2 {
+		t.Fatalf("expected 2 duplicates removed, got %d", removed)
+	}
+	if len(feedA.UnprocessedItems) != 1 || len(feedB.UnprocessedItems) != 0 || len(feedC.UnprocessedItems) != 1 || feedC.UnprocessedItems[0] != unrelated {
+		t.Fatalf("expected only the earliest copy and the unrelated story to stay queued")
+	}
+	if len(original.AlsoSeenIn) != 2 {
+		t.Fatalf("expected 2 also seen in references, got %+v", original.AlsoSeenIn)
+	}
+	if ref := original.AlsoSeenIn[0]; ref.Feed != feedB.Url || ref.GUID != "b1" {
+		t.Errorf("unexpected reference %+v", ref)
+	}
+
+	t.Run("LaterDuplicateOfDeliveredStory", func(t *testing.T) {
+		feedA.UnprocessedItems = nil // delivered meanwhile
+
+		queued := queuedCounts(feeds.Items)
+		late := &UnprocessedItem{GUID: "b2", URL: "https://aggregator.example.org/r/456", Title: "Rates raised", Description: dedupeTestSummary}
+		feedB.UnprocessedItems = append(feedB.UnprocessedItems, late)
+
+		if removed := feeds.dedupeStories(queued, now.Add(time.Hour), log); removed != 1 || len(feedB.UnprocessedItems) != 0 {
+			t.Errorf("expected the late duplicate to be dropped")
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		queued := queuedCounts(feeds.Items)
+		again := &UnprocessedItem{GUID: "b3", URL: "https://news.example.com/story-1"}
+		feedB.UnprocessedItems = append(feedB.UnprocessedItems, again)
+
+		if removed := feeds.dedupeStories(queued, now.Add(8*24*time.Hour), log); removed != 0 {
+			t.Errorf("expected stories past retention to be forgotten")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		feeds := &Feeds{Items: []*Feed{feedA}}
+		queued := queuedCounts(feeds.Items)
+		feedA.UnprocessedItems = []*UnprocessedItem{original, {GUID: "dup", URL: original.URL}}
+		if removed := feeds.dedupeStories(queued, now, log); removed != 0 || len(feeds.Stories) != 0 {
+			t.Errorf("expected dedupe to be off without settings")
+		}
+	})
+}
+
+func Test_simHash(t *testing.T) {
+	a, n := simHash("Central bank raises rates\n" + dedupeTestSummary)
+	b, _ := simHash("Central bank raises rates - Aggregator\n" + dedupeTestSummary)
+	c, _ := simHash("Local team wins the cup. The local football team won the national cup final on penalties after a goalless draw.")
+
+	if n < minSimHashShingles {
+		t.Fatalf("expected enough shingles, got %d", n)
+	}
+	if d := hammingDistance(a, b); d > defaultDedupeDistance {
+		t.Errorf("expected near duplicates within %d bits, got %d", defaultDedupeDistance, d)
+	}
+	if d := hammingDistance(a, c); d <= defaultDedupeDistance {
+		t.Errorf("expected different stories to be far apart, got %d", d)
+	}
+}
@@ -0,0 +1,276 @@
+package rss_reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Delivery states. An item is pending with a consumer until it is handed
+// over, in-flight while it is, and then done, failed, to be retried, or
+// dead once the consumer gave up on it. Items left in-flight by a crash
+// are sent again.
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_IN_FLIGHT = "in-flight"
+	DELIVERY_DONE      = "done"
+	DELIVERY_FAILED    = "failed"
+	DELIVERY_DEAD      = "dead"
+)
+
+// DIGEST_CONSUMER is the consumer ID of the email digest.
+const DIGEST_CONSUMER = "digest"
+
+// setDelivery moves item to state with consumer.
+func (item *UnprocessedItem) setDelivery(consumer, state string, now time.Time) *Delivery {
+	if item.Deliveries == nil {
+		item.Deliveries = map[string]*Delivery{}
+	}
+	d := item.Deliveries[consumer]
+	if d == nil {
+		d = &Delivery{}
+		item.Deliveries[consumer] = d
+	}
+	d.State, d.Updated = state, now.UTC()
+	return d
+}
+
+// deliveryDue tells whether item should be handed to consumer at now.
+// Items the consumer never got are not, nor those rules held back.
+func (item *UnprocessedItem) deliveryDue(consumer string, now time.Time) bool {
+	d := item.Deliveries[consumer]
+	if d == nil || d.final() {
+		return false
+	}
+	return !d.NextRetry.After(now) && !item.DeliverAfter.After(now)
+}
+
+func (d *Delivery) final() bool {
+	return d.State == DELIVERY_DONE || d.State == DELIVERY_DEAD
+}
+
+// retryBackoff is the wait before the next attempt after attempts failed.
+func retryBackoff(attempts int) time.Duration {
+	return min(deliveryBackoff<<min(attempts-1, 16), maxDeliveryBackoff)
+}
+
+// finishUpdate takes an update of the feeds home, the new items being
+// those past queued: it drops duplicate stories, sends the alerts of the
+// rest, hands the due items to the notifiers and sends the digest if it is
+// due. Failures are logged,
+// whatever was not delivered stays queued for the next update.
+func (f *Feeds) finishUpdate(ctx context.Context, queued map[*Feed]int, userFeedsFile string, log *slog.Logger) {
+	if removed := f.dedupeStories(queued, time.Now(), log); removed > 0 {
+		log.Info("duplicate stories removed", "count", removed)
+	}
+	f.sendAlerts(ctx, log)
+
+	notifiers := newNotifiers(f.Settings, newDeadLetters(deadLetterFile(userFeedsFile)))
+	if err := f.deliverItems(ctx, notifiers, log); err != nil {
+		// the delivery state is saved, the next update goes on from there
+		log.Info("delivery stopped", "error", err)
+	}
+
+	if due, err := f.digestDue(time.Now()); err != nil {
+		log.Error("invalid digest schedule", "error", err)
+	} else if due {
+		if _, err := f.sendDigest(ctx, time.Now(), log); err != nil {
+			log.Error("digest failed", "error", err)
+		}
+	}
+}
+
+// deliverItems hands the due items of every feed to the notifiers, then
+// drops the items that are through with all consumers, see pruneDelivered.
+// Failed deliveries are logged; only ctx errors are returned.
+func (f *Feeds) deliverItems(ctx context.Context, notifiers []Notifier, log *slog.Logger) error {
+	now := time.Now()
+	for _, feed := range f.Items {
+		for _, notifier := range notifiers {
+			var due []*UnprocessedItem
+			for _, item := range feed.UnprocessedItems {
+				if item.deliveryDue(notifier.ID(), now) {
+					due = append(due, item)
+				}
+			}
+			if len(due) == 0 {
+				continue
+			}
+			if err := notifier.Notify(ctx, feed, due); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Warn("delivery failed", "notifier", notifier.Name(), "url", feed.Url, "error", err)
+			}
+		}
+	}
+	f.pruneDelivered(f.consumers(notifiers))
+	return nil
+}
+
+// pruneDelivered drops the items every consumer is through with, see
+// Delivery.final. Without consumers nothing is dropped, the items stay
+// queued for the other commands.
+func (f *Feeds) pruneDelivered(consumers []string) {
+	if len(consumers) == 0 {
+		return
+	}
+	for _, feed := range f.Items {
+		over := map[*UnprocessedItem]bool{}
+		for _, item := range feed.UnprocessedItems {
+			over[item] = true
+			for _, consumer := range consumers {
+				if d := item.Deliveries[consumer]; d == nil || !d.final() {
+					delete(over, item)
+					break
+				}
+			}
+		}
+		if len(over) > 0 {
+			feed.UnprocessedItems = deleteItems(feed.UnprocessedItems, over)
+		}
+	}
+}
+
+// consumers are the IDs of notifiers and, with a digest configured, of
+// the digest.
+func (f *Feeds) consumers(notifiers []Notifier) []string {
+	var ids []string
+	for _, notifier := range notifiers {
+		ids = append(ids, notifier.ID())
+	}
+	if f.Settings != nil && f.Settings.Digest != nil {
+		ids = append(ids, DIGEST_CONSUMER)
+	}
+	return ids
+}
+
+// notifyBatches delivers items to e in the batches split makes of them,
+// with the message build makes of each batch and its key, and moves the
+// items on.
+func notifyBatches(ctx context.Context, e *endpoint, userFeed *Feed, items []*UnprocessedItem, split splitFunc,
+	build func(key string, batch []*UnprocessedItem) (*message, error)) error {
+	var errs []error
+	for _, batch := range batchItems(e.consumer, items, split) {
+		key := sentBatch(batch[0], e.consumer)
+		if key == "" {
+			key = batchKey(userFeed, batch)
+		}
+		msg, err := build(key, batch)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", batch[0].GUID, err))
+			continue
+		}
+
+		for _, item := range batch {
+			item.setDelivery(e.consumer, DELIVERY_IN_FLIGHT, time.Now()).Batch = key
+		}
+		retryAfter, err := e.deliver(ctx, msg)
+		if ctx.Err() != nil {
+			// not the endpoint's fault, the next run sends them again
+			for _, item := range batch {
+				item.setDelivery(e.consumer, DELIVERY_PENDING, time.Now())
+			}
+			return ctx.Err()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", batch[0].GUID, err))
+		}
+		if err := e.settle(msg, userFeed, batch, retryAfter, err, time.Now()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// batchItems splits items into batches with split. Items that went out in
+// a batch before are batched as they were, so a retry has the key of the
+// first attempt whatever else is due by then.
+func batchItems(consumer string, items []*UnprocessedItem, split splitFunc) [][]*UnprocessedItem {
+	var batches [][]*UnprocessedItem
+	var fresh []*UnprocessedItem
+	sent := map[string]int{}
+	for _, item := range items {
+		key := sentBatch(item, consumer)
+		if key == "" {
+			fresh = append(fresh, item)
+			continue
+		}
+		i, ok := sent[key]
+		if !ok {
+			i = len(batches)
+			sent[key] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], item)
+	}
+	return append(batches, split(fresh)...)
+}
+
+// sentBatch is the key of the batch item went out in to consumer, if any.
+func sentBatch(item *UnprocessedItem, consumer string) string {
+	if d := item.Deliveries[consumer]; d != nil {
+		return d.Batch
+	}
+	return ""
+}
+
+// settle moves the items of msg on after a delivery that ended with err.
+// An endpoint found down costs no attempt.
+func (e *endpoint) settle(msg *message, userFeed *Feed, batch []*UnprocessedItem, retryAfter time.Duration, err error, now time.Time) error {
+	var dead []string
+	attempts := 0
+	for _, item := range batch {
+		d := item.Deliveries[e.consumer]
+		d.Updated = now.UTC()
+		if err == nil {
+			d.State = DELIVERY_DONE
+			d.Attempts++
+			d.LastError, d.NextRetry = "", time.Time{}
+			continue
+		}
+
+		d.LastError = err.Error()
+		if errors.Is(err, ErrEndpointDown) {
+			d.State = DELIVERY_FAILED
+			if d.Attempts == 0 {
+				d.State = DELIVERY_PENDING
+			}
+			d.NextRetry = now.Add(retryAfter).UTC()
+			continue
+		}
+		d.Attempts++
+		if errors.Is(err, ErrDeliveryRejected) || d.Attempts >= e.maxAttempts {
+			d.State, d.NextRetry = DELIVERY_DEAD, time.Time{}
+			dead = append(dead, item.GUID)
+			attempts = max(attempts, d.Attempts)
+			continue
+		}
+		d.State = DELIVERY_FAILED
+		d.NextRetry = now.Add(max(retryBackoff(d.Attempts), retryAfter)).UTC()
+	}
+
+	if len(dead) == 0 {
+		return nil
+	}
+	return e.bury(msg, userFeed, dead, attempts, err)
+}
+
+// requeue makes the dead items of entry pending again with its consumer,
+// with a fresh count of attempts. It returns how many were still queued.
+func (f *Feeds) requeue(entry *DeadLetter, now time.Time) int {
+	count := 0
+	for _, guid := range entry.GUIDs {
+		item := f.findQueuedItem(entry.Feed, guid)
+		if item == nil {
+			continue
+		}
+		if d := item.Deliveries[entry.Consumer]; d != nil && d.State == DELIVERY_DEAD {
+			*d = Delivery{State: DELIVERY_PENDING, Updated: now.UTC()}
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,183 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingNotifier takes every item it gets.
+type recordingNotifier struct {
+	id  string
+	got []string
+}
+
+func (r *recordingNotifier) Name() string {
+	return "recording"
+}
+
+func (r *recordingNotifier) ID() string {
+	return r.id
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	for _, item := range items {
+		r.got = append(r.got, item.GUID)
+		item.setDelivery(r.id, DELIVERY_DONE, time.Now())
+	}
+	return nil
+}
+
+func Test_notifyMiddleware(t *testing.T) {
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	done := &UnprocessedItem{GUID: "done"}
+	done.setDelivery("a", DELIVERY_DONE, time.Now())
+	items := []*UnprocessedItem{{GUID: "new"}, done}
+
+	m := newNotifyMiddleware([]Notifier{&recordingNotifier{id: "a"}, &recordingNotifier{id: "b"}})
+	if err := m.Process(context.Background(), feed, items); err != nil {
+		t.Fatal(err)
+	}
+	for _, consumer := range []string{"a", "b"} {
+		if d := items[0].Deliveries[consumer]; d == nil || d.State != DELIVERY_PENDING {
+			t.Errorf("expected the new item pending with %s, got %+v", consumer, d)
+		}
+	}
+	if done.Deliveries["a"].State != DELIVERY_DONE {
+		t.Errorf("expected a delivered item to stay done")
+	}
+}
+
+func Test_deliverItems(t *testing.T) {
+	now := time.Now()
+	item := func(guid, state string, nextRetry time.Time) *UnprocessedItem {
+		item := &UnprocessedItem{GUID: guid}
+		if state != "" {
+			item.setDelivery("n", state, now).NextRetry = nextRetry
+		}
+		return item
+	}
+	held := item("held", DELIVERY_PENDING, time.Time{})
+	held.DeliverAfter = now.Add(time.Hour)
+	digested := item("digested", DELIVERY_PENDING, time.Time{})
+	digested.setDelivery(DIGEST_CONSUMER, DELIVERY_DONE, now)
+	waiting := item("waiting", DELIVERY_FAILED, now.Add(time.Hour))
+	waiting.setDelivery(DIGEST_CONSUMER, DELIVERY_DONE, now)
+
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	feed.UnprocessedItems = []*UnprocessedItem{
+		item("pending", DELIVERY_PENDING, time.Time{}),
+		item("crashed", DELIVERY_IN_FLIGHT, time.Time{}),
+		item("failed", DELIVERY_FAILED, now.Add(-time.Minute)),
+		waiting,
+		item("dead", DELIVERY_DEAD, time.Time{}),
+		item("other", "", time.Time{}),
+		held,
+		digested,
+	}
+	feeds := Feeds{Items: []*Feed{feed}}
+
+	notifier := &recordingNotifier{id: "n"}
+	if err := feeds.deliverItems(context.Background(), []Notifier{notifier}, setupLogger(io.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(notifier.got, ","); got != "pending,crashed,failed,digested" {
+		t.Errorf("unexpected deliveries %s", got)
+	}
+
+	// no digest is configured, the items "n" is through with are dropped
+	var left []string
+	for _, item := range feed.UnprocessedItems {
+		left = append(left, item.GUID)
+	}
+	if got := strings.Join(left, ","); got != "waiting,other,held" {
+		t.Errorf("unexpected queue %s", got)
+	}
+
+	t.Run("Digest", func(t *testing.T) {
+		// with a digest configured an item waits for it as well
+		taken := item("taken", DELIVERY_PENDING, time.Time{})
+		taken.setDelivery(DIGEST_CONSUMER, DELIVERY_DONE, now)
+		feed.UnprocessedItems = []*UnprocessedItem{item("untaken", DELIVERY_PENDING, time.Time{}), taken}
+		feeds.Settings = &Settings{Digest: &DigestConfig{}}
+
+		if err := feeds.deliverItems(context.Background(), []Notifier{notifier}, setupLogger(io.Discard)); err != nil {
+			t.Fatal(err)
+		}
+		if len(feed.UnprocessedItems) != 1 || feed.UnprocessedItems[0].GUID != "untaken" {
+			t.Errorf("expected only the item the digest took dropped, got %+v", feed.UnprocessedItems)
+		}
+	})
+}
+
+func Test_deadletterCommand(t *testing.T) {
+	dir := t.TempDir()
+	userFeedsFile := filepath.Join(dir, "user.json")
+
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	dead := &UnprocessedItem{GUID: "g1"}
+	dead.setDelivery("webhook-1", DELIVERY_DEAD, time.Now()).Attempts = 8
+	feed.UnprocessedItems = []*UnprocessedItem{dead}
+
+	store := newDeadLetters(deadLetterFile(userFeedsFile))
+	for _, entry := range []*DeadLetter{
+		{Key: "aaaa1111", Consumer: "webhook-1", Feed: feed.Hash, GUIDs: []string{"g1"}, Attempts: 8, Error: "503 Service Unavailable"},
+		{Key: "bbbb2222", Consumer: "webhook-1", Feed: feed.Hash, GUIDs: []string{"gone"}, Attempts: 1, Error: "400 Bad Request"},
+	} {
+		if err := store.add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var saved *Feeds
+	mockFeedsIO := &MockFeedsIO{
+		GetFeedsFileFunc: func(userHash string) (string, error) { return userFeedsFile, nil },
+		LoadFeedsFunc: func(string) (Feeds, error) {
+			return Feeds{Items: []*Feed{feed}}, nil
+		},
+		SaveUpdatesFunc: func(feeds Feeds, _ string) error {
+			saved = &feeds
+			return nil
+		},
+	}
+	deadletter := func(args ...string) string {
+		t.Helper()
+		var stdout bytes.Buffer
+		args = append(append([]string{"rss_reader", "deadletter"}, args...), TestAppArgs[1])
+		if code := run(args, mockFeedsIO, &MockGofeedParser{}, &stdout); code != 0 {
+			t.Fatalf("expected exit code 0, got %d. Output: %s", code, stdout.String())
+		}
+		return stdout.String()
+	}
+
+	out := deadletter()
+	if !strings.Contains(out, "aaaa1111 ") || !strings.Contains(out, "1 items, 8 attempts, from https://example.com/feed") ||
+		!strings.Contains(out, "  400 Bad Request") || !strings.HasSuffix(out, "2 dead letters\n") {
+		t.Errorf("unexpected listing:\n%s", out)
+	}
+
+	out = deadletter("-requeue")
+	if !strings.Contains(out, "bbbb2222: items no longer queued") || !strings.HasSuffix(out, "requeued 1 items\n") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if saved == nil {
+		t.Fatal("expected the feeds saved")
+	}
+	if d := saved.Items[0].UnprocessedItems[0].Deliveries["webhook-1"]; d.State != DELIVERY_PENDING || d.Attempts != 0 {
+		t.Errorf("expected the item pending again, got %+v", d)
+	}
+	if entries, _ := store.list(); len(entries) != 1 || entries[0].Key != "bbbb2222" {
+		t.Errorf("expected only the entry that could not be requeued left, got %+v", entries)
+	}
+
+	if out := deadletter("-purge", "-key", "bbbb"); !strings.HasSuffix(out, "purged 1 dead letters\n") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if entries, _ := store.list(); len(entries) != 0 {
+		t.Errorf("expected no dead letters left, got %d", len(entries))
+	}
+}
@@ -0,0 +1,373 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	SMTP_SECURITY_STARTTLS = "starttls"
+	SMTP_SECURITY_TLS      = "tls"
+	SMTP_SECURITY_NONE     = "none"
+)
+
+const (
+	defaultDigestEvery    = 24 * time.Hour
+	defaultDigestSubject  = "Your feeds digest"
+	defaultDigestMaxItems = 200
+	digestSummaryRunes    = 400
+	smtpTimeout           = 60 * time.Second
+)
+
+var (
+	ErrDigestNotConfigured = errors.New("digest not configured")
+	ErrSMTPNoStartTLS      = errors.New("smtp server does not support STARTTLS")
+)
+
+// digestSection are the items of one feed in a digest.
+type digestSection struct {
+	Feed  *Feed
+	Items []*UnprocessedItem
+}
+
+// next is when the digest after one sent at last is due. With At set it
+// goes out at that time of day, in Timezone; the first digest on the day
+// the user turns it on.
+func (c *DigestConfig) next(last, now time.Time) (time.Time, error) {
+	every := defaultDigestEvery
+	if c.Every != "" {
+		d, err := parseDelay(c.Every)
+		if err != nil {
+			return time.Time{}, err
+		}
+		every = d
+	}
+	if c.At == "" {
+		return last.Add(every), nil
+	}
+
+	at, err := time.Parse("15:04", c.At)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid digest time %q", c.At)
+	}
+	loc := time.UTC
+	if c.Timezone != "" {
+		if loc, err = time.LoadLocation(c.Timezone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	day := now
+	if !last.IsZero() {
+		day = last.Add(every)
+	}
+	day = day.In(loc)
+	return time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, loc), nil
+}
+
+// digestDue tells whether the user's digest should go out at now.
+func (f *Feeds) digestDue(now time.Time) (bool, error) {
+	if f.Settings == nil || f.Settings.Digest == nil {
+		return false, nil
+	}
+	next, err := f.Settings.Digest.next(f.LastDigest, now)
+	if err != nil {
+		return false, err
+	}
+	return !now.Before(next), nil
+}
+
+// pendingDigest collects the items due for delivery, grouped by feed in
+// feed order, higher priority first within a feed.
+func (f *Feeds) pendingDigest(now time.Time, limit int) ([]digestSection, int) {
+	var sections []digestSection
+	count := 0
+	for _, feed := range f.Items {
+		var items []*UnprocessedItem
+		for _, item := range feed.UnprocessedItems {
+			if count == limit {
+				break
+			}
+			if item.DeliverAfter.After(now) || item.Deliveries[DIGEST_CONSUMER] != nil {
+				continue
+			}
+			items = append(items, item)
+			count++
+		}
+		if len(items) == 0 {
+			continue
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Priority > items[j].Priority
+		})
+		sections = append(sections, digestSection{Feed: feed, Items: items})
+	}
+	return sections, count
+}
+
+// sendDigest mails the pending items and takes them off the queue once the
+// server accepted the message, or later, when the notifiers are done with
+// them too. It returns the number of items sent; with none pending nothing
+// is sent, but the schedule moves on.
+func (f *Feeds) sendDigest(ctx context.Context, now time.Time, log *slog.Logger) (int, error) {
+	if f.Settings == nil || f.Settings.Digest == nil {
+		return 0, ErrDigestNotConfigured
+	}
+	config := f.Settings.Digest
+	limit := config.MaxItems
+	if limit <= 0 {
+		limit = defaultDigestMaxItems
+	}
+
+	sections, count := f.pendingDigest(now, limit)
+	if count == 0 {
+		f.LastDigest = now.UTC()
+		return 0, nil
+	}
+
+	msg, err := buildDigest(config, sections, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := sendMail(ctx, &config.SMTP, config.From, config.To, msg); err != nil {
+		return 0, err
+	}
+
+	for _, section := range sections {
+		for _, item := range section.Items {
+			item.setDelivery(DIGEST_CONSUMER, DELIVERY_DONE, now)
+		}
+	}
+	f.pruneDelivered(f.consumers(newNotifiers(f.Settings, nil)))
+	f.LastDigest = now.UTC()
+	log.Info("digest sent", "items", count, "to", strings.Join(config.To, ","))
+	return count, nil
+}
+
+type digestEntry struct {
+	Title   string
+	URL     string
+	Text    string
+	HTML    htmltemplate.HTML
+	Authors string
+}
+
+type digestFeed struct {
+	Title string
+	URL   string
+	Items []digestEntry
+}
+
+var digestText = texttemplate.Must(texttemplate.New("text").Parse(
+	`{{range .}}== {{.Title}} ==
+{{range .Items}}
+* {{.Title}}{{if .Authors}} ({{.Authors}}){{end}}
+  {{.URL}}
+{{if .Text}}  {{.Text}}
+{{end}}{{end}}
+{{end}}`))
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif; max-width: 40em">
+{{range .}}<h2>{{.Title}}</h2>
+{{range .Items}}<div style="margin-bottom: 1.5em">
+<h3 style="margin-bottom: 0.2em"><a href="{{.URL}}">{{.Title}}</a></h3>
+{{if .Authors}}<div style="color: #666">{{.Authors}}</div>{{end}}
+{{if .HTML}}<div style="white-space: pre-line">{{.HTML}}</div>{{end}}
+</div>
+{{end}}{{end}}</body></html>
+`))
+
+// buildDigest renders sections into a multipart message with a plain text
+// and an HTML part, headers included.
+func buildDigest(config *DigestConfig, sections []digestSection, now time.Time) ([]byte, error) {
+	feeds := make([]digestFeed, 0, len(sections))
+	for _, section := range sections {
+		df := digestFeed{Title: feedTitle(section.Feed), URL: section.Feed.Url}
+		for _, item := range section.Items {
+			df.Items = append(df.Items, newDigestEntry(item))
+		}
+		feeds = append(feeds, df)
+	}
+
+	var text, body bytes.Buffer
+	if err := digestText.Execute(&text, feeds); err != nil {
+		return nil, err
+	}
+	var html bytes.Buffer
+	if err := digestHTML.Execute(&html, feeds); err != nil {
+		return nil, err
+	}
+
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		data        []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.data); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	subject := config.Subject
+	if subject == "" {
+		subject = defaultDigestSubject
+	}
+	var msg bytes.Buffer
+	header := [][2]string{
+		{"From", config.From},
+		{"To", strings.Join(config.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", messageID(config.From)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range header {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func newDigestEntry(item *UnprocessedItem) digestEntry {
+	entry := digestEntry{Title: itemTitle(item), URL: item.URL, Authors: itemAuthors(item)}
+
+	switch {
+	case item.Summary != "":
+		entry.Text = truncateText(item.Summary, digestSummaryRunes)
+		entry.HTML = htmltemplate.HTML(htmltemplate.HTMLEscapeString(entry.Text))
+	case item.Description != "":
+		entry.Text = renderText(item.Description, item.URL, digestSummaryRunes)
+		// the Telegram subset is plain HTML, sanitized and with every tag
+		// closed
+		entry.HTML = htmltemplate.HTML(renderTelegram(item.Description, item.URL, digestSummaryRunes))
+	}
+	entry.Text = strings.ReplaceAll(entry.Text, "\n", "\n  ")
+	return entry
+}
+
+func messageID(from string) string {
+	host := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		host = strings.Trim(from[at+1:], "> ")
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + host + ">"
+}
+
+// sendMail delivers msg over SMTP. It returns nil only once the server
+// accepted the message.
+func sendMail(ctx context.Context, config *SMTPConfig, from string, to []string, msg []byte) error {
+	if config.Host == "" || from == "" || len(to) == 0 {
+		return ErrDigestNotConfigured
+	}
+	security := config.Security
+	if security == "" {
+		security = SMTP_SECURITY_STARTTLS
+	}
+	port := config.Port
+	if port == 0 {
+		port = map[string]int{SMTP_SECURITY_STARTTLS: 587, SMTP_SECURITY_TLS: 465, SMTP_SECURITY_NONE: 25}[security]
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: config.Host}
+
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	switch security {
+	case SMTP_SECURITY_TLS:
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	case SMTP_SECURITY_STARTTLS, SMTP_SECURITY_NONE:
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	default:
+		return fmt.Errorf("unknown smtp security %q", security)
+	}
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(smtpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if security == SMTP_SECURITY_STARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return ErrSMTPNoStartTLS
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// the message is accepted, a failing QUIT does not change that
+	c.Quit()
+	return nil
+}
@@ -0,0 +1,261 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a minimal SMTP server that keeps what it accepts.
+type smtpStandIn struct {
+	host, port string
+	startTLS   bool
+	rejectData bool
+
+	mu       sync.Mutex
+	auth     string
+	rcpts    []string
+	messages [][]byte
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpStandIn{}
+	s.host, s.port, _ = net.SplitHostPort(ln.Addr().String())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 stand-in ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if s.startTLS {
+				tp.PrintfLine("250-stand-in")
+				tp.PrintfLine("250-STARTTLS")
+			} else {
+				tp.PrintfLine("250-stand-in")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, initial, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(initial)
+			s.mu.Lock()
+			s.auth = string(decoded)
+			s.mu.Unlock()
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL", "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, arg)
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			if s.rejectData {
+				tp.PrintfLine("554 5.7.1 Message rejected")
+				continue
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data)
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK queued")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpStandIn) config(t *testing.T) SMTPConfig {
+	port, _ := strconv.Atoi(s.port)
+	return SMTPConfig{Host: s.host, Port: port, Security: SMTP_SECURITY_NONE, Username: "reader", Password: "secret"}
+}
+
+func digestTestFeeds(smtpConfig SMTPConfig, now time.Time) *Feeds {
+	news := newFeed(FEED_TYPE_RSS, "https://news.example.com/feed")
+	news.Title = "Example News"
+	news.UnprocessedItems = []*UnprocessedItem{
+		{GUID: "n1", URL: "https://news.example.com/1", Title: "Budget passed", Description: "<p>The council <b>approved</b> the budget.</p>"},
+		{GUID: "n2", URL: "https://news.example.com/2", Title: "Later", DeliverAfter: now.Add(time.Hour)},
+		{GUID: "n3", URL: "https://news.example.com/3", Title: "Breaking: bridge closed", Priority: 100, Summary: "The bridge is closed for repairs."},
+	}
+	blog := newFeed(FEED_TYPE_RSS, "https://blog.example.org/feed")
+	blog.UnprocessedItems = []*UnprocessedItem{
+		{GUID: "b1", URL: "https://blog.example.org/post", Title: "Über Straßen", Authors: []*Author{{Name: "Ada"}}},
+	}
+	return &Feeds{
+		Settings: &Settings{Digest: &DigestConfig{
+			To:   []string{"reader@example.com"},
+			From: "digest@example.com",
+			SMTP: smtpConfig,
+		}},
+		Items: []*Feed{news, blog},
+	}
+}
+
+func Test_sendDigest(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	log := setupLogger(io.Discard)
+	server := newSMTPStandIn(t)
+	feeds := digestTestFeeds(server.config(t), now)
+
+	sent, err := feeds.sendDigest(context.Background(), now, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 3 {
+		t.Errorf("expected 3 items sent, got %d", sent)
+	}
+	if len(feeds.Items[0].UnprocessedItems) != 1 || feeds.Items[0].UnprocessedItems[0].GUID != "n2" || len(feeds.Items[1].UnprocessedItems) != 0 {
+		t.Errorf("expected only the delayed item to stay queued")
+	}
+	if !feeds.LastDigest.Equal(now) {
+		t.Errorf("expected the last digest at %s, got %s", now, feeds.LastDigest)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.auth != "\x00reader\x00secret" || len(server.rcpts) != 1 {
+		t.Errorf("unexpected auth %q or recipients %v", server.auth, server.rcpts)
+	}
+	if len(server.messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(server.messages))
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(server.messages[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("Subject") != defaultDigestSubject || msg.Header.Get("To") != "reader@example.com" {
+		t.Errorf("unexpected headers %v", msg.Header)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative, got %q", mediaType)
+	}
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+
+	text := parts["text/plain"]
+	for _, want := range []string{"== Example News ==", "== https://blog.example.org/feed ==", "Über Straßen (Ada)", "The council approved the budget."} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in the text part:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "Breaking") > strings.Index(text, "Budget passed") {
+		t.Errorf("expected high priority items first:\n%s", text)
+	}
+	html := parts["text/html"]
+	for _, want := range []string{`<h2>Example News</h2>`, `<a href="https://news.example.com/1">Budget passed</a>`, `The council <b>approved</b> the budget.`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in the HTML part:\n%s", want, html)
+		}
+	}
+}
+
+func Test_sendDigestRejected(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	server := newSMTPStandIn(t)
+	server.rejectData = true
+	feeds := digestTestFeeds(server.config(t), now)
+
+	if _, err := feeds.sendDigest(context.Background(), now, setupLogger(io.Discard)); err == nil {
+		t.Fatal("expected the rejection to be an error")
+	}
+	if len(feeds.Items[0].UnprocessedItems) != 3 || len(feeds.Items[1].UnprocessedItems) != 1 || !feeds.LastDigest.IsZero() {
+		t.Errorf("expected the queue and the schedule to stay as they were")
+	}
+
+	server.rejectData = false
+	config := server.config(t)
+	config.Security = SMTP_SECURITY_STARTTLS
+	if err := sendMail(context.Background(), &config, "a@example.com", []string{"b@example.com"}, []byte("Subject: x\r\n\r\nx")); !errors.Is(err, ErrSMTPNoStartTLS) {
+		t.Errorf("expected ErrSMTPNoStartTLS from a server without STARTTLS, got %v", err)
+	}
+}
+
+func Test_DigestConfigNext(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	last := time.Date(2024, 5, 1, 8, 5, 0, 0, berlin)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		config DigestConfig
+		last   time.Time
+		want   time.Time
+	}{
+		{"Interval", DigestConfig{Every: "6h"}, last, last.Add(6 * time.Hour)},
+		{"Default", DigestConfig{}, last, last.Add(24 * time.Hour)},
+		{"DailyAt", DigestConfig{At: "08:00", Timezone: "Europe/Berlin"}, last, time.Date(2024, 5, 2, 8, 0, 0, 0, berlin)},
+		{"WeeklyAt", DigestConfig{Every: "1w", At: "07:30"}, last, time.Date(2024, 5, 8, 7, 30, 0, 0, time.UTC)},
+		{"FirstAt", DigestConfig{At: "09:00"}, time.Time{}, time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.next(tt.last, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := (&DigestConfig{At: "8 am"}).next(last, now); err == nil {
+		t.Errorf("expected an error for an invalid time")
+	}
+}
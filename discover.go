@@ -0,0 +1,195 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+var (
+	ErrNoFeedsFound = errors.New("no feeds found")
+)
+
+// feedLinkTypes maps <link rel="alternate"> types to feed formats.
+var feedLinkTypes = map[string]string{
+	"application/rss+xml":   "rss",
+	"application/atom+xml":  "atom",
+	"application/feed+json": "json",
+	"application/json":      "json",
+}
+
+var commonFeedPaths = []string{"/feed", "/rss.xml", "/atom.xml", "/feed.xml", "/index.xml"}
+
+const (
+	CANDIDATE_SELF = "self"
+	CANDIDATE_LINK = "link"
+	CANDIDATE_PATH = "path"
+)
+
+type FeedCandidate struct {
+	URL      string
+	Title    string
+	Format   string
+	Source   string
+	Verified bool
+}
+
+func (c *FeedCandidate) rank() int {
+	score := 0
+	if c.Verified {
+		score += 100
+	}
+	switch c.Source {
+	case CANDIDATE_SELF:
+		score += 50
+	case CANDIDATE_LINK:
+		score += 20
+	}
+	// full-content formats first when a site offers several
+	switch c.Format {
+	case "atom":
+		score += 2
+	case "rss":
+		score += 1
+	}
+	return score
+}
+
+// discoverFeeds finds feeds behind a URL the user pasted. A feed URL is
+// returned as is; for a web page it looks at <link rel="alternate"> tags and
+// then probes common feed paths. Candidates come back best first.
+func discoverFeeds(ctx context.Context, pageURL string) ([]*FeedCandidate, error) {
+	page, err := httpGet(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if feed, err := gofeed.NewParser().Parse(bytes.NewReader(page.Body)); err == nil {
+		return []*FeedCandidate{{
+			URL:      page.URL.String(),
+			Title:    feed.Title,
+			Format:   feedFormat(feed),
+			Source:   CANDIDATE_SELF,
+			Verified: true,
+		}}, nil
+	}
+
+	candidates := linkCandidates(page)
+
+	seen := make(map[string]bool, len(candidates))
+	for _, c := range candidates {
+		seen[c.URL] = true
+	}
+	for _, path := range commonFeedPaths {
+		ref, _ := page.URL.Parse(path)
+		if ref == nil || seen[ref.String()] {
+			continue
+		}
+		seen[ref.String()] = true
+		candidates = append(candidates, &FeedCandidate{URL: ref.String(), Source: CANDIDATE_PATH})
+	}
+
+	var found []*FeedCandidate
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		verifyCandidate(ctx, c)
+		// a guessed path is only worth offering if it really is a feed
+		if c.Source == CANDIDATE_PATH && !c.Verified {
+			continue
+		}
+		found = append(found, c)
+	}
+
+	if len(found) == 0 {
+		return nil, ErrNoFeedsFound
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].rank() > found[j].rank()
+	})
+
+	return found, nil
+}
+
+func linkCandidates(page *fetchedPage) []*FeedCandidate {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil
+	}
+
+	var candidates []*FeedCandidate
+	doc.Find("link[rel][href]").Each(func(_ int, s *goquery.Selection) {
+		rel, _ := s.Attr("rel")
+		if !hasToken(rel, "alternate") {
+			return
+		}
+		linkType, _ := s.Attr("type")
+		mediaType, _, _ := mime.ParseMediaType(linkType)
+		format, ok := feedLinkTypes[mediaType]
+		if !ok {
+			return
+		}
+		href, _ := s.Attr("href")
+		ref, err := page.URL.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return
+		}
+		title, _ := s.Attr("title")
+		candidates = append(candidates, &FeedCandidate{
+			URL:    ref.String(),
+			Title:  collapseSpaces(title),
+			Format: format,
+			Source: CANDIDATE_LINK,
+		})
+	})
+
+	return candidates
+}
+
+func verifyCandidate(ctx context.Context, c *FeedCandidate) {
+	page, err := httpGet(ctx, c.URL)
+	if err != nil {
+		return
+	}
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(page.Body))
+	if err != nil {
+		return
+	}
+
+	c.Verified = true
+	c.URL = page.URL.String()
+	c.Format = feedFormat(feed)
+	if c.Title == "" {
+		c.Title = feed.Title
+	}
+}
+
+func feedFormat(feed *gofeed.Feed) string {
+	if feed.FeedType == "" {
+		return FEED_TYPE_RSS
+	}
+	return feed.FeedType
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidURL(rawURL string) bool {
+	u, err := url.ParseRequestURI(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
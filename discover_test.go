@@ -0,0 +1,132 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const discoverTestRSS = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Site RSS</title><link>http://example.com</link>
+<item><guid>1</guid><title>One</title></item></channel></rss>`
+
+const discoverTestAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Site Atom</title><id>urn:x</id>
+<updated>2024-01-01T00:00:00Z</updated></feed>`
+
+func newDiscoverTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `<html><head>
+<link rel="alternate" type="application/rss+xml" title="Main RSS" href="/feed.xml">
+<link rel="alternate" type="application/rss+xml" title="Broken" href="/missing.xml">
+<link rel="stylesheet" href="/style.css">
+</head><body>home</body></html>`)
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, discoverTestRSS)
+	})
+	mux.HandleFunc("/atom.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, discoverTestAtom)
+	})
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>not a feed</html>")
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_discoverFeeds(t *testing.T) {
+	srv := newDiscoverTestServer(t)
+
+	t.Run("HomePage", func(t *testing.T) {
+		candidates, err := discoverFeeds(context.Background(), srv.URL+"/")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		var got []string
+		for _, c := range candidates {
+			got = append(got, strings.TrimPrefix(c.URL, srv.URL))
+		}
+		want := []string{"/feed.xml", "/atom.xml", "/missing.xml"}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Fatalf("expected candidates %v, got %v", want, got)
+		}
+
+		if c := candidates[0]; c.Title != "Main RSS" || c.Format != "rss" || !c.Verified || c.Source != CANDIDATE_LINK {
+			t.Errorf("unexpected first candidate %+v", c)
+		}
+		if c := candidates[1]; c.Title != "Site Atom" || c.Format != "atom" || c.Source != CANDIDATE_PATH {
+			t.Errorf("unexpected probed candidate %+v", c)
+		}
+		if candidates[2].Verified {
+			t.Errorf("expected broken link candidate to be unverified")
+		}
+	})
+
+	t.Run("FeedURL", func(t *testing.T) {
+		candidates, err := discoverFeeds(context.Background(), srv.URL+"/atom.xml")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(candidates) != 1 || candidates[0].Source != CANDIDATE_SELF || candidates[0].Format != "atom" {
+			t.Errorf("expected the feed itself, got %+v", candidates)
+		}
+	})
+}
+
+func Test_addCommand(t *testing.T) {
+	srv := newDiscoverTestServer(t)
+
+	var saved Feeds
+	mockFeedsIO := &MockFeedsIO{
+		LoadFeedsFunc: func(userFeedsFile string) (Feeds, error) {
+			return Feeds{Items: []*Feed{newFeed(FEED_TYPE_RSS, srv.URL+"/feed.xml")}}, nil
+		},
+		SaveUpdatesFunc: func(feeds Feeds, userFeedsFile string) error {
+			saved = feeds
+			return nil
+		},
+	}
+
+	var stdoutBuf bytes.Buffer
+	exitCode := run([]string{"rss_reader", "add", "-pick", "2", TestAppArgs[1], srv.URL}, mockFeedsIO, &MockGofeedParser{}, &stdoutBuf)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d. Output: %s", exitCode, stdoutBuf.String())
+	}
+
+	if len(saved.Items) != 2 {
+		t.Fatalf("expected 2 feeds after add, got %d", len(saved.Items))
+	}
+	added := saved.Items[1]
+	if added.Url != srv.URL+"/atom.xml" || added.Type != FEED_TYPE_RSS || added.Hash != GetSHA256(added.Url) || added.UnprocessedGUID == nil {
+		t.Errorf("unexpected added feed %+v", added)
+	}
+
+	t.Run("AlreadySubscribed", func(t *testing.T) {
+		saved = Feeds{}
+		stdoutBuf.Reset()
+		exitCode := run([]string{"rss_reader", "add", TestAppArgs[1], srv.URL}, mockFeedsIO, &MockGofeedParser{}, &stdoutBuf)
+		if exitCode != 0 || saved.Items != nil {
+			t.Errorf("expected no save for an existing feed, got exit code %d and %+v", exitCode, saved)
+		}
+	})
+
+	t.Run("PickOutOfRange", func(t *testing.T) {
+		exitCode := run([]string{"rss_reader", "add", "-pick", "9", TestAppArgs[1], srv.URL}, mockFeedsIO, &MockGofeedParser{}, io.Discard)
+		if exitCode != E_BAD_COMMAND_ARGS {
+			t.Errorf("expected exit code %d, got %d", E_BAD_COMMAND_ARGS, exitCode)
+		}
+	})
+}
@@ -0,0 +1,327 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+const (
+	minParagraphRunes = 25
+	// blocks inside the article that are mostly links and this short are
+	// navigation or "related" lists
+	maxLinkListRunes = 200
+)
+
+var (
+	ErrNoArticle = errors.New("no article content found")
+)
+
+var (
+	boilerplateSelector = "script, style, noscript, template, iframe, form, nav, aside, footer, header, button, input, select, svg, object, embed, link, meta"
+
+	unlikelyRe = regexp.MustCompile(`(?i)\b(ad|ads)\b|advert|agegate|banner|breadcrumb|combx|comment|community|cookie|disqus|extra|footer|header|legends|menu|modal|newsletter|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|supplemental`)
+	maybeRe    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveRe = regexp.MustCompile(`(?i)article|blog|body|content|entry|main|page|post|story|text`)
+	negativeRe = regexp.MustCompile(`(?i)\b(ad|ads)\b|advert|comment|footer|footnote|hidden|masthead|meta|nav|promo|related|share|sidebar|social|sponsor|widget`)
+
+	keptAttrs = map[string]bool{"href": true, "src": true, "alt": true, "title": true}
+)
+
+// expander is the "expand" middleware: it replaces the teaser of a feed
+// with the article behind the item's link. Articles are cached by URL in
+// dir, so an item showing up in several feeds is fetched once.
+type expander struct {
+	dir string
+}
+
+type expandedArticle struct {
+	URL string `json:"url"`
+	// Config is the fingerprint of the ExpandConfig it was extracted with
+	Config string `json:"config"`
+	HTML   string `json:"html"`
+	Text   string `json:"text"`
+}
+
+func newExpander(dir string) *expander {
+	return &expander{dir: dir}
+}
+
+func (e *expander) Name() string {
+	return "expand"
+}
+
+func (e *expander) Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	config := userFeed.Expand
+	if config == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, item := range items {
+		if item.URL == "" || item.FullHTML != "" {
+			continue
+		}
+		article, err := e.article(ctx, item.URL, config)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", item.URL, err))
+			continue
+		}
+		item.FullHTML = sanitizeHTML(article.HTML, item.URL)
+		item.FullText = article.Text
+	}
+	return errors.Join(errs...)
+}
+
+func (e *expander) article(ctx context.Context, articleURL string, config *ExpandConfig) (*expandedArticle, error) {
+	cacheFile := filepath.Join(e.dir, GetSHA256(articleURL)+".json")
+
+	if data, err := os.ReadFile(cacheFile); err == nil {
+		var cached expandedArticle
+		// a changed selector or remove list invalidates the cached article
+		if json.Unmarshal(data, &cached) == nil && cached.URL == articleURL && cached.Config == config.fingerprint() {
+			return &cached, nil
+		}
+	}
+
+	page, err := httpGet(ctx, articleURL)
+	if err != nil {
+		return nil, err
+	}
+	contentHTML, text, err := extractArticle(page, config)
+	if err != nil {
+		return nil, err
+	}
+
+	article := &expandedArticle{URL: articleURL, Config: config.fingerprint(), HTML: contentHTML, Text: text}
+	if err := writeJSONFile(cacheFile, article); err != nil {
+		return nil, err
+	}
+	return article, nil
+}
+
+// fingerprint changes with every setting that changes what is extracted.
+func (c *ExpandConfig) fingerprint() string {
+	data, _ := json.Marshal(c)
+	return GetSHA256(string(data))
+}
+
+// writeJSONFile replaces name atomically, so concurrent feed updates never
+// read half a file.
+func writeJSONFile(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// extractArticle finds the main content of a page, readability style:
+// paragraphs score their parent and grandparent by length and commas,
+// class and id names push containers up or down, and the best container
+// wins after a penalty for link density. config.Selector skips the
+// heuristics. The result is the cleaned HTML of the article and its text.
+func extractArticle(page *fetchedPage, config *ExpandConfig) (string, string, error) {
+	for _, sel := range append([]string{config.Selector}, config.Remove...) {
+		if sel == "" {
+			continue
+		}
+		if _, err := cascadia.Compile(sel); err != nil {
+			return "", "", fmt.Errorf("invalid selector %q: %w", sel, err)
+		}
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return "", "", err
+	}
+	doc.Find(boilerplateSelector).Remove()
+
+	var content *goquery.Selection
+	if config.Selector != "" {
+		content = doc.Find(config.Selector).First()
+	} else {
+		removeUnlikely(doc.Find("body"))
+		content = bestCandidate(doc)
+	}
+	if content == nil || content.Length() == 0 {
+		return "", "", ErrNoArticle
+	}
+
+	for _, sel := range config.Remove {
+		content.Find(sel).Remove()
+	}
+	cleanArticle(content, page.URL)
+
+	text := normalizedText(content)
+	if text == "" {
+		return "", "", ErrNoArticle
+	}
+	contentHTML, err := goquery.OuterHtml(content)
+	if err != nil {
+		return "", "", err
+	}
+	return contentHTML, text, nil
+}
+
+func removeUnlikely(sel *goquery.Selection) {
+	sel.Find("*").Each(func(_ int, s *goquery.Selection) {
+		switch goquery.NodeName(s) {
+		case "html", "body", "article", "main":
+			return
+		}
+		names := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if unlikelyRe.MatchString(names) && !maybeRe.MatchString(names) {
+			s.Remove()
+		}
+	})
+}
+
+func bestCandidate(doc *goquery.Document) *goquery.Selection {
+	scores := map[*html.Node]float64{}
+	var order []*html.Node
+	add := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		n := s.Get(0)
+		if n.Type != html.ElementNode || n.Data == "html" {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(s)
+			order = append(order, n)
+		}
+		scores[n] += score
+	}
+
+	doc.Find("p, pre, td, blockquote").Each(func(_ int, s *goquery.Selection) {
+		text := collapseSpaces(s.Text())
+		length := utf8.RuneCountInString(text)
+		if length < minParagraphRunes {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(length)/100, 3)
+		parent := s.Parent()
+		add(parent, score)
+		add(parent.Parent(), score/2)
+	})
+
+	var best *html.Node
+	bestScore := 0.0
+	for _, n := range order {
+		score := scores[n] * (1 - linkDensity(goquery.NewDocumentFromNode(n).Selection))
+		if best == nil || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return doc.FindNodes(best)
+}
+
+func initialScore(s *goquery.Selection) float64 {
+	score := classWeight(s)
+	switch goquery.NodeName(s) {
+	case "article":
+		score += 10
+	case "div", "main", "section":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	return score
+}
+
+func classWeight(s *goquery.Selection) float64 {
+	weight := 0.0
+	for _, name := range []string{s.AttrOr("class", ""), s.AttrOr("id", "")} {
+		if name == "" {
+			continue
+		}
+		if negativeRe.MatchString(name) {
+			weight -= 25
+		}
+		if positiveRe.MatchString(name) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// linkDensity is the share of the text of s that sits inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	length := utf8.RuneCountInString(collapseSpaces(s.Text()))
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linkLength += utf8.RuneCountInString(collapseSpaces(a.Text()))
+	})
+	return float64(linkLength) / float64(length)
+}
+
+// cleanArticle drops link lists and presentational attributes and makes
+// links and images absolute, so the article renders outside its site.
+func cleanArticle(content *goquery.Selection, base *url.URL) {
+	content.Find("div, section, ul, ol, table, p").Each(func(_ int, s *goquery.Selection) {
+		if utf8.RuneCountInString(collapseSpaces(s.Text())) < maxLinkListRunes && s.Find("a").Length() > 0 && linkDensity(s) > 0.5 {
+			s.Remove()
+		}
+	})
+
+	content.AddSelection(content.Find("*")).Each(func(_ int, s *goquery.Selection) {
+		n := s.Get(0)
+		attrs := n.Attr[:0]
+		for _, attr := range n.Attr {
+			if !keptAttrs[attr.Key] {
+				continue
+			}
+			if (attr.Key == "href" || attr.Key == "src") && base != nil {
+				if ref, err := base.Parse(strings.TrimSpace(attr.Val)); err == nil {
+					attr.Val = ref.String()
+				}
+			}
+			attrs = append(attrs, attr)
+		}
+		n.Attr = attrs
+	})
+}
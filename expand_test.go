@@ -0,0 +1,128 @@
+package rss_reader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const expandTestArticle = `<html><head><title>Post</title><script>track()</script></head><body>
+<nav><a href="/">Home</a> <a href="/about">About</a></nav>
+<div class="ad-banner">Buy now, limited offer, only today, hurry up!</div>
+<div id="main">
+  <div class="post-body">
+    <h1 class="title">Quarterly results</h1>
+    <p>The company reported revenue growth of twelve percent, beating the forecast, driven by new markets.</p>
+    <p>Operating costs fell, margins improved, and the board approved a dividend for the first time in years.</p>
+    <p><img src="/chart.png" alt="Chart" style="width:100%"> Analysts expect the trend to continue, cautiously, into next year.</p>
+    <ul class="links"><li><a href="/a">Related one</a></li><li><a href="/b">Related two</a></li></ul>
+    <div class="inline-promo">Subscribe to our newsletter for more stories like this one!</div>
+  </div>
+</div>
+<div class="sidebar"><p>Trending: <a href="/x">some other story that everybody clicks on today</a></p></div>
+<footer>Copyright, all rights reserved, forever and ever, amen.</footer>
+</body></html>`
+
+func newExpandTestServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		io.WriteString(w, expandTestArticle)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func Test_extractArticle(t *testing.T) {
+	srv, _ := newExpandTestServer(t)
+	page, err := httpGet(context.Background(), srv.URL+"/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contentHTML, text, err := extractArticle(page, &ExpandConfig{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	for _, want := range []string{"revenue growth", "dividend", "Analysts expect"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in the article text:\n%s", want, text)
+		}
+	}
+	for _, boilerplate := range []string{"Home", "Buy now", "Related one", "Subscribe", "Trending", "Copyright", "track()"} {
+		if strings.Contains(contentHTML, boilerplate) {
+			t.Errorf("expected %q to be stripped:\n%s", boilerplate, contentHTML)
+		}
+	}
+	if !strings.Contains(contentHTML, `src="`+srv.URL+`/chart.png"`) || strings.Contains(contentHTML, "style=") {
+		t.Errorf("expected absolute image without presentational attributes:\n%s", contentHTML)
+	}
+
+	t.Run("SelectorOverride", func(t *testing.T) {
+		_, text, err := extractArticle(page, &ExpandConfig{Selector: ".sidebar", Remove: []string{"a"}})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if text != "Trending:" {
+			t.Errorf("expected the selected element without links, got %q", text)
+		}
+
+		if _, _, err := extractArticle(page, &ExpandConfig{Selector: "#missing"}); err != ErrNoArticle {
+			t.Errorf("expected ErrNoArticle, got %v", err)
+		}
+	})
+}
+
+func Test_expanderMiddleware(t *testing.T) {
+	srv, hits := newExpandTestServer(t)
+	dir := t.TempDir()
+	pipe := pipeline{newExpander(dir)}
+
+	expanded := newFeed(FEED_TYPE_RSS, "https://example.com/feed.xml")
+	expanded.Expand = &ExpandConfig{}
+	plain := newFeed(FEED_TYPE_RSS, "https://example.com/other.xml")
+
+	mockFeedFetcher := &MockGofeedParser{
+		ParseURLWithContextFunc: func(feedURL string, ctx context.Context) (*gofeed.Feed, error) {
+			return &gofeed.Feed{Updated: "1", Items: []*gofeed.Item{{GUID: "post", Link: srv.URL + "/post"}}}, nil
+		},
+	}
+
+	if err := updateFeeds(context.Background(), mockFeedFetcher, []*Feed{expanded, plain}, pipe, setupLogger(io.Discard)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if !strings.Contains(expanded.UnprocessedItems[0].FullText, "revenue growth") {
+		t.Errorf("expected the item to be expanded, got %+v", expanded.UnprocessedItems[0])
+	}
+	if plain.UnprocessedItems[0].FullHTML != "" {
+		t.Errorf("expected feeds without expand config to be left alone")
+	}
+
+	// same URL in another feed comes from the cache
+	again := newFeed(FEED_TYPE_RSS, "https://example.com/third.xml")
+	again.Expand = &ExpandConfig{}
+	if err := updateFeeds(context.Background(), mockFeedFetcher, []*Feed{again}, pipe, setupLogger(io.Discard)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if hits.Load() != 1 || again.UnprocessedItems[0].FullHTML != expanded.UnprocessedItems[0].FullHTML {
+		t.Errorf("expected a cached article, server hit %d times", hits.Load())
+	}
+
+	// other elements to remove make the cached article stale
+	removed := newFeed(FEED_TYPE_RSS, "https://example.com/fourth.xml")
+	removed.Expand = &ExpandConfig{Remove: []string{"a"}}
+	if err := updateFeeds(context.Background(), mockFeedFetcher, []*Feed{removed}, pipe, setupLogger(io.Discard)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("expected the article extracted again after Remove changed, server hit %d times", hits.Load())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type FeedsIO interface {
@@ -32,6 +33,12 @@ func (r *RealFeedsIO) GetFeedsFile(hash string) (string, error) {
 	return file, nil
 }
 
+// userDir is the directory for everything else kept for the user owning
+// userFeedsFile, next to the file.
+func userDir(userFeedsFile string) string {
+	return strings.TrimSuffix(userFeedsFile, filepath.Ext(userFeedsFile))
+}
+
 func (r *RealFeedsIO) LoadFeeds(userFeedsFile string) (Feeds, error) {
 	file, err := os.Open(userFeedsFile)
 	if err != nil {
@@ -0,0 +1,225 @@
+package rss_reader
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	// shorter texts are left undetected rather than guessed
+	minDetectLetters = 12
+	maxDetectRunes   = 2000
+)
+
+// languageSamples train the trigram profiles of languages written in
+// scripts shared with others. Languages with a script of their own are
+// told apart by the script alone, see scriptLanguage.
+var languageSamples = map[string]string{
+	"en": `All human beings are born free and equal in dignity and rights. They are endowed with reason and conscience and should act towards one another in a spirit of brotherhood.
+The government announced on Monday that it would raise spending on schools and hospitals, while the opposition said the plan was not enough. Prices have been rising for months and many families are struggling to pay their bills.
+What is the best way to learn a new language? Most teachers agree that you should practice every day, read books that you enjoy and talk with people who speak it well. This week we also look at the weather, the markets and the results of the football matches.`,
+	"de": `Alle Menschen sind frei und gleich an Würde und Rechten geboren. Sie sind mit Vernunft und Gewissen begabt und sollen einander im Geist der Brüderlichkeit begegnen.
+Die Bundesregierung hat am Montag angekündigt, dass sie die Ausgaben für Schulen und Krankenhäuser erhöhen will, während die Opposition den Plan für nicht ausreichend hält. Die Preise steigen seit Monaten und viele Familien können ihre Rechnungen kaum noch bezahlen.
+Wie lernt man am besten eine neue Sprache? Die meisten Lehrer sind sich einig, dass man jeden Tag üben, Bücher lesen und mit Menschen sprechen sollte, die sie gut beherrschen. Außerdem geht es diese Woche um das Wetter, die Märkte und die Ergebnisse der Fußballspiele.`,
+	"fr": `Tous les êtres humains naissent libres et égaux en dignité et en droits. Ils sont doués de raison et de conscience et doivent agir les uns envers les autres dans un esprit de fraternité.
+Le gouvernement a annoncé lundi qu'il allait augmenter les dépenses pour les écoles et les hôpitaux, tandis que l'opposition estime que le plan ne suffit pas. Les prix augmentent depuis des mois et de nombreuses familles ont du mal à payer leurs factures.
+Quelle est la meilleure façon d'apprendre une nouvelle langue ? La plupart des professeurs sont d'accord pour dire qu'il faut pratiquer chaque jour, lire des livres que l'on aime et parler avec des gens qui la parlent bien. Cette semaine, nous parlons aussi de la météo, des marchés et des résultats des matchs de football.`,
+	"es": `Todos los seres humanos nacen libres e iguales en dignidad y derechos y, dotados como están de razón y conciencia, deben comportarse fraternalmente los unos con los otros.
+El gobierno anunció el lunes que aumentará el gasto en escuelas y hospitales, mientras que la oposición dijo que el plan no es suficiente. Los precios llevan meses subiendo y muchas familias tienen problemas para pagar sus facturas.
+¿Cuál es la mejor manera de aprender un idioma nuevo? La mayoría de los profesores están de acuerdo en que hay que practicar todos los días, leer libros que te gusten y hablar con personas que lo hablen bien. Esta semana también hablamos del tiempo, de los mercados y de los resultados de los partidos de fútbol.`,
+	"it": `Tutti gli esseri umani nascono liberi ed eguali in dignità e diritti. Essi sono dotati di ragione e di coscienza e devono agire gli uni verso gli altri in spirito di fratellanza.
+Il governo ha annunciato lunedì che aumenterà la spesa per le scuole e gli ospedali, mentre l'opposizione ha detto che il piano non è sufficiente. I prezzi salgono da mesi e molte famiglie fanno fatica a pagare le bollette.
+Qual è il modo migliore per imparare una nuova lingua? La maggior parte degli insegnanti è d'accordo che bisogna esercitarsi ogni giorno, leggere libri che piacciono e parlare con persone che la parlano bene. Questa settimana parliamo anche del tempo, dei mercati e dei risultati delle partite di calcio.`,
+	"pt": `Todos os seres humanos nascem livres e iguais em dignidade e em direitos. Dotados de razão e de consciência, devem agir uns para com os outros em espírito de fraternidade.
+O governo anunciou na segunda-feira que vai aumentar os gastos com escolas e hospitais, enquanto a oposição disse que o plano não é suficiente. Os preços estão subindo há meses e muitas famílias têm dificuldade para pagar as suas contas.
+Qual é a melhor maneira de aprender uma nova língua? A maioria dos professores concorda que é preciso praticar todos os dias, ler livros de que se gosta e conversar com pessoas que a falam bem. Esta semana também falamos do tempo, dos mercados e dos resultados dos jogos de futebol.`,
+	"nl": `Alle mensen worden vrij en gelijk in waardigheid en rechten geboren. Zij zijn begiftigd met verstand en geweten, en behoren zich jegens elkander in een geest van broederschap te gedragen.
+De regering heeft maandag aangekondigd dat zij de uitgaven voor scholen en ziekenhuizen gaat verhogen, terwijl de oppositie zegt dat het plan niet genoeg is. De prijzen stijgen al maanden en veel gezinnen hebben moeite om hun rekeningen te betalen.
+Wat is de beste manier om een nieuwe taal te leren? De meeste leraren zijn het erover eens dat je elke dag moet oefenen, boeken moet lezen die je leuk vindt en moet praten met mensen die de taal goed spreken. Deze week kijken we ook naar het weer, de markten en de uitslagen van de voetbalwedstrijden.`,
+	"sv": `Alla människor är födda fria och lika i värde och rättigheter. De har utrustats med förnuft och samvete och bör handla gentemot varandra i en anda av broderskap.
+Regeringen meddelade på måndagen att den kommer att öka utgifterna för skolor och sjukhus, medan oppositionen sade att planen inte räcker. Priserna har stigit i flera månader och många familjer har svårt att betala sina räkningar.
+Vilket är det bästa sättet att lära sig ett nytt språk? De flesta lärare är överens om att man ska öva varje dag, läsa böcker som man tycker om och prata med människor som talar det bra. Den här veckan tittar vi också på vädret, marknaderna och resultaten från fotbollsmatcherna.`,
+	"pl": `Wszyscy ludzie rodzą się wolni i równi pod względem swej godności i swych praw. Są oni obdarzeni rozumem i sumieniem i powinni postępować wobec innych w duchu braterstwa.
+Rząd ogłosił w poniedziałek, że zwiększy wydatki na szkoły i szpitale, natomiast opozycja stwierdziła, że plan jest niewystarczający. Ceny rosną od wielu miesięcy i wiele rodzin ma problemy z opłaceniem rachunków.
+Jaki jest najlepszy sposób na naukę nowego języka? Większość nauczycieli zgadza się, że trzeba ćwiczyć codziennie, czytać książki, które się lubi, i rozmawiać z ludźmi, którzy dobrze nim mówią. W tym tygodniu piszemy także o pogodzie, rynkach i wynikach meczów piłkarskich.`,
+	"ru": `Все люди рождаются свободными и равными в своем достоинстве и правах. Они наделены разумом и совестью и должны поступать в отношении друг друга в духе братства.
+Правительство объявило в понедельник, что увеличит расходы на школы и больницы, а оппозиция заявила, что этого плана недостаточно. Цены растут уже несколько месяцев, и многим семьям трудно оплачивать счета.
+Как лучше всего выучить новый язык? Большинство учителей согласны, что нужно заниматься каждый день, читать книги, которые нравятся, и разговаривать с людьми, которые хорошо на нём говорят. На этой неделе мы также расскажем о погоде, рынках и результатах футбольных матчей.`,
+	"uk": `Усі люди народжуються вільними і рівними у своїй гідності та правах. Вони наділені розумом і совістю і повинні діяти у відношенні один до одного в дусі братерства.
+Уряд оголосив у понеділок, що збільшить видатки на школи та лікарні, а опозиція заявила, що цього плану недостатньо. Ціни зростають уже кілька місяців, і багатьом родинам важко сплачувати рахунки.
+Як найкраще вивчити нову мову? Більшість учителів погоджуються, що треба займатися щодня, читати книжки, які подобаються, і розмовляти з людьми, які добре нею говорять. Цього тижня ми також розповімо про погоду, ринки та результати футбольних матчів.`,
+}
+
+type languageProfile struct {
+	language string
+	logProb  map[string]float64
+	unseen   float64
+}
+
+var languageProfiles = buildLanguageProfiles(languageSamples)
+
+func buildLanguageProfiles(samples map[string]string) []*languageProfile {
+	counts := map[string]map[string]int{}
+	vocabulary := map[string]bool{}
+	for language, sample := range samples {
+		counts[language] = trigrams(sample)
+		for tri := range counts[language] {
+			vocabulary[tri] = true
+		}
+	}
+
+	var profiles []*languageProfile
+	for _, language := range sortedKeys(counts) {
+		total := 0
+		for _, n := range counts[language] {
+			total += n
+		}
+		// add-one smoothing over the trigrams of all languages
+		denominator := float64(total + len(vocabulary))
+		profile := &languageProfile{
+			language: language,
+			logProb:  map[string]float64{},
+			unseen:   math.Log(1 / denominator),
+		}
+		for tri, n := range counts[language] {
+			profile.logProb[tri] = math.Log(float64(n+1) / denominator)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+// trigrams counts the letter trigrams of text, words padded with a space
+// on both sides so word starts and ends count too.
+func trigrams(text string) map[string]int {
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+	return counts
+}
+
+// detectLanguage returns the ISO 639-1 code of the language text is most
+// likely written in, or "" when the text is too short to tell.
+func detectLanguage(text string) string {
+	runes := []rune(text)
+	if len(runes) > maxDetectRunes {
+		text = string(runes[:maxDetectRunes])
+	}
+
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < minDetectLetters {
+		return ""
+	}
+
+	language, candidates := scriptLanguage(text)
+	if language != "" {
+		return language
+	}
+
+	counts := trigrams(text)
+	best, bestScore := "", math.Inf(-1)
+	for _, profile := range languageProfiles {
+		if !slices.Contains(candidates, profile.language) {
+			continue
+		}
+		score := 0.0
+		for tri, n := range counts {
+			logProb, ok := profile.logProb[tri]
+			if !ok {
+				logProb = profile.unseen
+			}
+			score += float64(n) * logProb
+		}
+		if score > bestScore {
+			best, bestScore = profile.language, score
+		}
+	}
+	return best
+}
+
+var latinLanguages = []string{"de", "en", "es", "fr", "it", "nl", "pl", "pt", "sv"}
+
+// scriptLanguage settles the language by the dominant script where that
+// is enough, or narrows down the candidates for the trigram comparison.
+func scriptLanguage(text string) (string, []string) {
+	scripts := map[string]int{}
+	kana := 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["cyrillic"]++
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana++
+			scripts["cjk"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["cjk"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		}
+	}
+
+	dominant := ""
+	for _, script := range sortedKeys(scripts) {
+		if dominant == "" || scripts[script] > scripts[dominant] {
+			dominant = script
+		}
+	}
+
+	switch dominant {
+	case "latin":
+		return "", latinLanguages
+	case "cyrillic":
+		return "", []string{"ru", "uk"}
+	case "cjk":
+		if kana > 0 {
+			return "ja", nil
+		}
+		return "zh", nil
+	default:
+		return dominant, nil
+	}
+}
+
+// itemLanguageText is the text of an item used for detection.
+func itemLanguageText(item *UnprocessedItem) string {
+	text := item.Title + "\n" + htmlText(item.Description)
+	if len(text) < maxDetectRunes/4 && item.Content != "" {
+		text += "\n" + htmlText(item.Content)
+	}
+	return text
+}
+
+// allows reports whether items in language pass the filter. Items of an
+// undetected language always pass, a filter should not lose short posts.
+func (f *LanguageFilter) allows(language string) bool {
+	if f == nil || language == "" {
+		return true
+	}
+	if slices.Contains(f.Deny, language) {
+		return false
+	}
+	return len(f.Allow) == 0 || slices.Contains(f.Allow, language)
+}
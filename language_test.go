@@ -0,0 +1,81 @@
+package rss_reader
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func Test_detectLanguage(t *testing.T) {
+	tests := map[string]string{
+		"Scientists have discovered a new species of frog in the rainforest, which they say could help with research.": "en",
+		"Der Zug nach Berlin hatte heute Morgen wegen eines technischen Problems mehr als eine Stunde Verspätung.":     "de",
+		"Le musée sera fermé pendant trois semaines pour des travaux de rénovation de la grande salle.":                "fr",
+		"El equipo ganó el campeonato después de una temporada muy difícil y llena de lesiones.":                       "es",
+		"La città ha deciso di chiudere il centro storico alle auto durante il fine settimana.":                        "it",
+		"O novo aeroporto deve ficar pronto no próximo ano, segundo o ministro dos transportes.":                       "pt",
+		"De gemeente wil meer fietspaden aanleggen zodat kinderen veilig naar school kunnen fietsen.":                  "nl",
+		"Forskarna har hittat en ny metod för att rena vatten som är både billig och enkel att använda.":               "sv",
+		"Naukowcy odkryli nowy gatunek żaby w lesie deszczowym, który może pomóc w badaniach nad lekami.":              "pl",
+		"Учёные обнаружили новый вид лягушки в тропическом лесу, что может помочь в исследованиях.":                    "ru",
+		"Науковці виявили новий вид жаби в тропічному лісі, що може допомогти в дослідженнях ліків.":                   "uk",
+		"東京で新しい美術館がオープンしました。":                                                                                          "ja",
+		"北京今天的天气非常好，很多人去公园散步。":                                                                                         "zh",
+		"서울에서 새로운 박물관이 문을 열었습니다":                                                                                       "ko",
+		"Η κυβέρνηση ανακοίνωσε νέα μέτρα για την οικονομία":                                                           "el",
+		"Too short": "",
+	}
+	for text, want := range tests {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func Test_getUpdatesLanguageFilter(t *testing.T) {
+	mockFeedFetcher := &MockGofeedParser{
+		ParseURLWithContextFunc: func(feedURL string, ctx context.Context) (*gofeed.Feed, error) {
+			return &gofeed.Feed{Updated: "1", Items: []*gofeed.Item{
+				{GUID: "en", Title: "The weather will be sunny and warm for the rest of the week"},
+				{GUID: "de", Title: "Das Wetter bleibt für den Rest der Woche sonnig und warm"},
+				{GUID: "fr", Title: "Le temps restera ensoleillé et chaud pour le reste de la semaine"},
+				{GUID: "short", Title: "OK"},
+			}}, nil
+		},
+	}
+
+	tests := []struct {
+		name   string
+		filter *LanguageFilter
+		want   []string
+	}{
+		{"NoFilter", nil, []string{"en", "de", "fr", "short"}},
+		{"Allow", &LanguageFilter{Allow: []string{"en", "de"}}, []string{"en", "de", "short"}},
+		{"Deny", &LanguageFilter{Deny: []string{"fr"}}, []string{"en", "de", "short"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userFeed := newFeed(FEED_TYPE_RSS, "https://example.com/feed.xml")
+			userFeed.Languages = tt.filter
+			if err := getUpdates(context.Background(), mockFeedFetcher, userFeed, setupLogger(io.Discard)); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+
+			var got []string
+			for _, item := range userFeed.UnprocessedItems {
+				if item.GUID != "short" && item.Language != item.GUID {
+					t.Errorf("expected %s to be tagged %s, got %q", item.GUID, item.GUID, item.Language)
+				}
+				got = append(got, item.GUID)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if !userFeed.seen("fr") {
+				t.Errorf("expected filtered items to be marked as seen")
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package rss_reader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var (
+	ErrMailboxEmpty = errors.New("mailbox has no messages")
+)
+
+const maxMailParts = 64
+
+var mailWordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+type mailMessage struct {
+	ID        string
+	FromName  string
+	FromEmail string
+	Subject   string
+	Date      time.Time
+	HTML      string
+	Text      string
+}
+
+// mailFeed reads a Maildir directory or an mbox file and returns every
+// message as an item. Re-reading the same messages is harmless: Message-ID
+// is the GUID, so getUpdates skips what it has already seen. Sender feeds
+// carry the sender after a "#" so each one has its own URL; it is dropped
+// before the mailbox is read.
+func mailFeed(path string, config *MailConfig) (*gofeed.Feed, error) {
+	if config != nil && config.Sender != "" {
+		path = strings.TrimSuffix(path, "#"+config.Sender)
+	}
+	messages, err := readMailbox(path)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &gofeed.Feed{
+		Title:    filepath.Base(path),
+		Link:     path,
+		FeedType: FEED_TYPE_MAILDIR,
+	}
+
+	var guids []string
+	for _, msg := range messages {
+		if config != nil && config.Sender != "" && !strings.EqualFold(msg.FromEmail, config.Sender) {
+			continue
+		}
+		feed.Items = append(feed.Items, msg.item())
+		guids = append(guids, msg.ID)
+	}
+
+	if config != nil && config.Sender != "" {
+		feed.Title = config.Sender
+	}
+	feed.Updated = GetSHA256(strings.Join(guids, "\n"))
+
+	return feed, nil
+}
+
+func (m *mailMessage) item() *gofeed.Item {
+	item := &gofeed.Item{
+		GUID:        m.ID,
+		Title:       m.Subject,
+		Link:        "mid:" + url.PathEscape(m.ID),
+		Content:     m.HTML,
+		Description: firstNRunes(collapseSpaces(m.Text), 500),
+		Author:      &gofeed.Person{Name: m.FromName, Email: m.FromEmail},
+	}
+	if item.Content == "" && m.Text != "" {
+		item.Content = "<pre>" + html.EscapeString(m.Text) + "</pre>"
+	}
+	if !m.Date.IsZero() {
+		date := m.Date.UTC()
+		item.Published = date.Format(time.RFC3339)
+		item.PublishedParsed = &date
+	}
+	return item
+}
+
+// readMailbox returns the messages of a Maildir (a directory with cur/ and
+// new/) or an mbox file, oldest first.
+func readMailbox(path string) ([]*mailMessage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raws [][]byte
+	if info.IsDir() {
+		raws, err = readMaildir(path)
+	} else {
+		raws, err = readMbox(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*mailMessage
+	for _, raw := range raws {
+		msg, err := parseMailMessage(raw)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	if len(messages) == 0 {
+		return nil, ErrMailboxEmpty
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Date.Before(messages[j].Date)
+	})
+
+	return messages, nil
+}
+
+func readMaildir(dir string) ([][]byte, error) {
+	var raws [][]byte
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			raw, err := os.ReadFile(filepath.Join(dir, sub, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			raws = append(raws, raw)
+		}
+	}
+	return raws, nil
+}
+
+// readMbox splits an mbox file on "From " separator lines and undoes the
+// ">From " quoting of mboxrd.
+func readMbox(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var raws [][]byte
+	var current *bytes.Buffer
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxResponseSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") {
+			if current != nil {
+				raws = append(raws, current.Bytes())
+			}
+			current = &bytes.Buffer{}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if unquoted := strings.TrimLeft(line, ">"); len(unquoted) < len(line) && strings.HasPrefix(unquoted, "From ") {
+			line = line[1:]
+		}
+		current.WriteString(line)
+		current.WriteString("\r\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		raws = append(raws, current.Bytes())
+	}
+
+	return raws, nil
+}
+
+func parseMailMessage(raw []byte) (*mailMessage, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &mailMessage{
+		ID: strings.Trim(strings.TrimSpace(m.Header.Get("Message-Id")), "<>"),
+	}
+	if msg.ID == "" {
+		msg.ID = GetSHA256(string(raw))
+	}
+
+	if subject, err := mailWordDecoder.DecodeHeader(m.Header.Get("Subject")); err == nil {
+		msg.Subject = collapseSpaces(subject)
+	}
+
+	parser := mail.AddressParser{WordDecoder: mailWordDecoder}
+	if from, err := parser.Parse(m.Header.Get("From")); err == nil {
+		msg.FromName = from.Name
+		msg.FromEmail = strings.ToLower(from.Address)
+	}
+
+	if date, err := m.Header.Date(); err == nil {
+		msg.Date = date
+	}
+
+	parts := 0
+	err = readMailPart(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body, msg, &parts)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// readMailPart walks a (possibly multipart) body and keeps the first HTML
+// and the first plain text alternative it finds.
+func readMailPart(contentType, transferEncoding string, body io.Reader, msg *mailMessage, parts *int) error {
+	*parts++
+	if *parts > maxMailParts {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = readMailPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, msg, parts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if mediaType != "text/html" && mediaType != "text/plain" {
+		return nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	if charset := params["charset"]; charset != "" {
+		decoded, err := charsetReader(charset, body)
+		if err == nil {
+			body = decoded
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("read %s part: %w", mediaType, err)
+	}
+
+	if mediaType == "text/html" && msg.HTML == "" {
+		msg.HTML = string(data)
+	} else if mediaType == "text/plain" && msg.Text == "" {
+		msg.Text = strings.TrimSpace(string(data))
+	}
+
+	return nil
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// mailSenders counts messages per sender address in a mailbox.
+func mailSenders(path string) (map[string]int, error) {
+	messages, err := readMailbox(path)
+	if err != nil {
+		return nil, err
+	}
+	senders := make(map[string]int)
+	for _, msg := range messages {
+		if msg.FromEmail != "" {
+			senders[msg.FromEmail]++
+		}
+	}
+	return senders, nil
+}
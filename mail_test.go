@@ -0,0 +1,186 @@
+package rss_reader
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mailTestMultipart = "From: =?UTF-8?B?0J3QvtCy0L7RgdGC0Lg=?= <news@example.com>\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: =?UTF-8?Q?Weekly_digest_=E2=84=961?=\r\n" +
+	"Date: Tue, 02 Jan 2024 10:00:00 +0300\r\n" +
+	"Message-ID: <weekly-1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"UGxhaW4gdGV4dCBib2R5\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p>HTML =\r\nbody</p>\r\n" +
+	"--b1--\r\n"
+
+const mailTestPlain = "From: Other <other@example.org>\r\n" +
+	"Subject: Plain one\r\n" +
+	"Date: Mon, 01 Jan 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <plain-1@example.org>\r\n" +
+	"Content-Type: text/plain; charset=windows-1251\r\n" +
+	"\r\n" +
+	"\xcf\xf0\xe8\xe2\xe5\xf2\r\n" +
+	">From the archive\r\n"
+
+func writeTestMaildir(t *testing.T) string {
+	dir := t.TempDir()
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"cur/1.host:2,S": mailTestMultipart,
+		"new/2.host":     mailTestPlain,
+		"tmp/3.host":     "From: broken",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_readMailbox(t *testing.T) {
+	t.Run("Maildir", func(t *testing.T) {
+		messages, err := readMailbox(writeTestMaildir(t))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(messages) != 2 {
+			t.Fatalf("expected 2 messages, got %d", len(messages))
+		}
+
+		// oldest first
+		plain, weekly := messages[0], messages[1]
+		if plain.Text != "Привет\r\n>From the archive" {
+			t.Errorf("unexpected decoded plain text %q", plain.Text)
+		}
+
+		if weekly.ID != "weekly-1@example.com" {
+			t.Errorf("unexpected message id %q", weekly.ID)
+		}
+		if weekly.Subject != "Weekly digest №1" {
+			t.Errorf("unexpected subject %q", weekly.Subject)
+		}
+		if weekly.FromName != "Новости" || weekly.FromEmail != "news@example.com" {
+			t.Errorf("unexpected sender %q <%s>", weekly.FromName, weekly.FromEmail)
+		}
+		if weekly.Text != "Plain text body" {
+			t.Errorf("unexpected text part %q", weekly.Text)
+		}
+		if strings.TrimSpace(weekly.HTML) != "<p>HTML body</p>" {
+			t.Errorf("unexpected html part %q", weekly.HTML)
+		}
+	})
+
+	t.Run("Mbox", func(t *testing.T) {
+		mbox := "From news@example.com Tue Jan  2 10:00:00 2024\n" + strings.ReplaceAll(mailTestMultipart, "\r\n", "\n") +
+			"\nFrom other@example.org Mon Jan  1 10:00:00 2024\n" + strings.ReplaceAll(mailTestPlain, "\r\n", "\n")
+		mbox = strings.Replace(mbox, "\n>From the archive", "\n>>From the archive", 1)
+
+		path := filepath.Join(t.TempDir(), "inbox.mbox")
+		if err := os.WriteFile(path, []byte(mbox), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		messages, err := readMailbox(path)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(messages) != 2 {
+			t.Fatalf("expected 2 messages, got %d", len(messages))
+		}
+		if !strings.HasSuffix(messages[0].Text, "\n>From the archive") {
+			t.Errorf("expected one level of >From quoting removed, got %q", messages[0].Text)
+		}
+	})
+}
+
+func Test_getUpdatesMaildir(t *testing.T) {
+	dir := writeTestMaildir(t)
+	log := setupLogger(io.Discard)
+
+	userFeed := newFeed(FEED_TYPE_MAILDIR, dir)
+	if err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, log); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(userFeed.UnprocessedItems) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(userFeed.UnprocessedItems))
+	}
+	if _, ok := userFeed.UnprocessedGUID["weekly-1@example.com"]; !ok {
+		t.Errorf("expected Message-ID as guid, got %v", userFeed.UnprocessedGUID)
+	}
+
+	// a new message arrives; old ones must not be queued again
+	next := strings.ReplaceAll(mailTestPlain, "plain-1@", "plain-2@")
+	if err := os.WriteFile(filepath.Join(dir, "new", "4.host"), []byte(next), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, log); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(userFeed.UnprocessedItems) != 3 {
+		t.Errorf("expected only the new message to be queued, got %d items", len(userFeed.UnprocessedItems))
+	}
+
+	t.Run("SenderFeed", func(t *testing.T) {
+		senderFeed := newFeed(FEED_TYPE_MAILDIR, dir+"#news@example.com")
+		senderFeed.Mail = &MailConfig{Sender: "news@example.com"}
+		if err := getUpdates(context.Background(), &MockGofeedParser{}, senderFeed, log); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(senderFeed.UnprocessedItems) != 1 || senderFeed.UnprocessedItems[0].GUID != "weekly-1@example.com" {
+			t.Errorf("expected only the sender's message, got %+v", senderFeed.UnprocessedItems)
+		}
+	})
+}
+
+func Test_addMailCommand(t *testing.T) {
+	dir := writeTestMaildir(t)
+
+	var saved Feeds
+	mockFeedsIO := &MockFeedsIO{
+		LoadFeedsFunc: func(userFeedsFile string) (Feeds, error) {
+			return Feeds{Items: []*Feed{}}, nil
+		},
+		SaveUpdatesFunc: func(feeds Feeds, userFeedsFile string) error {
+			saved = feeds
+			return nil
+		},
+	}
+
+	exitCode := run([]string{"rss_reader", "add-mail", "-by-sender", TestAppArgs[1], dir}, mockFeedsIO, &MockGofeedParser{}, io.Discard)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitCode)
+	}
+	if len(saved.Items) != 2 {
+		t.Fatalf("expected a virtual feed per sender, got %d feeds", len(saved.Items))
+	}
+	if saved.Items[0].Mail.Sender != "news@example.com" || saved.Items[1].Mail.Sender != "other@example.org" {
+		t.Errorf("unexpected senders %q, %q", saved.Items[0].Mail.Sender, saved.Items[1].Mail.Sender)
+	}
+	if saved.Items[0].Url == saved.Items[1].Url || saved.Items[0].Hash == saved.Items[1].Hash {
+		t.Errorf("expected distinct urls and hashes for virtual feeds")
+	}
+	if feed := saved.findFeed(saved.Items[1].Url); feed != saved.Items[1] {
+		t.Errorf("expected findFeed to return the second sender feed, got %+v", feed)
+	}
+}
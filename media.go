@@ -0,0 +1,336 @@
+package rss_reader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMediaQuota         = 1 << 30
+	defaultMediaRetentionDays = 30
+	mediaPartSuffix           = ".part"
+)
+
+var defaultMediaTypes = []string{"audio/", "video/"}
+
+var (
+	ErrMediaNotConfigured = errors.New("media downloads are not configured")
+	ErrMediaQuotaExceeded = errors.New("media quota exceeded")
+)
+
+// mediaClient has no overall timeout, an episode can take a while to
+// download; requests are bounded by their context instead.
+var mediaClient = &http.Client{}
+
+type mediaResult struct {
+	Downloaded int
+	Deduped    int
+	Removed    int
+	Bytes      int64
+}
+
+// mediaDir is where the media of the user owning userFeedsFile is stored.
+func mediaDir(userFeedsFile string) string {
+	return filepath.Join(userDir(userFeedsFile), "media")
+}
+
+func (c *MediaConfig) quota() int64 {
+	if c.QuotaBytes <= 0 {
+		return defaultMediaQuota
+	}
+	return c.QuotaBytes
+}
+
+func (c *MediaConfig) retention() time.Duration {
+	days := c.RetentionDays
+	if days <= 0 {
+		days = defaultMediaRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func (c *MediaConfig) wants(enc *Enclosure) bool {
+	encType := enc.Type
+	if encType == "" {
+		encType = mime.TypeByExtension(mediaExt(enc))
+	}
+	types := c.Types
+	if len(types) == 0 {
+		types = defaultMediaTypes
+	}
+	for _, t := range types {
+		if strings.HasPrefix(encType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadMedia fetches up to limit enclosures of queued items into dir. It
+// first drops files past the retention period, then downloads while the
+// user's quota allows. Every enclosure is downloaded once: identical files
+// are stored once under their checksum and interrupted downloads resume
+// from their .part file on the next run.
+func downloadMedia(ctx context.Context, feeds *Feeds, dir string, limit int, log *slog.Logger) (mediaResult, error) {
+	var result mediaResult
+	if feeds.Settings == nil || feeds.Settings.Media == nil {
+		return result, ErrMediaNotConfigured
+	}
+	config := feeds.Settings.Media
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return result, err
+	}
+
+	removed, err := cleanupMedia(dir, config.retention(), feeds, time.Now())
+	if err != nil {
+		return result, err
+	}
+	result.Removed = removed
+
+	used, err := dirSize(dir)
+	if err != nil {
+		return result, err
+	}
+
+	var errs []error
+	for _, userFeed := range feeds.Items {
+		for _, item := range userFeed.UnprocessedItems {
+			for _, enc := range item.Enclosures {
+				if limit > 0 && result.Downloaded+result.Deduped >= limit {
+					return result, errors.Join(errs...)
+				}
+				if err := ctx.Err(); err != nil {
+					return result, err
+				}
+				if enc.SHA256 != "" || !config.wants(enc) {
+					continue
+				}
+				if enc.Length > 0 && used+enc.Length > config.quota() {
+					log.Warn("skipping enclosure over quota", "url", enc.URL, "length", enc.Length)
+					continue
+				}
+
+				written, deduped, err := downloadEnclosure(ctx, dir, enc, config.quota()-used)
+				used += written
+				if err != nil {
+					log.Error("media download failed", "url", enc.URL, "error", err)
+					errs = append(errs, err)
+					continue
+				}
+				log.Info("downloaded media", "url", enc.URL, "file", enc.File, "deduped", deduped)
+				result.Bytes += written
+				if deduped {
+					result.Deduped++
+				} else {
+					result.Downloaded++
+				}
+			}
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// downloadEnclosure downloads enc into dir without writing more than
+// remaining bytes, and returns how much the directory grew.
+func downloadEnclosure(ctx context.Context, dir string, enc *Enclosure, remaining int64) (int64, bool, error) {
+	part := filepath.Join(dir, GetSHA256(enc.URL)+mediaPartSuffix)
+
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+
+	written, err := fetchMedia(ctx, enc.URL, part, offset, remaining)
+	if err != nil {
+		return written, false, err
+	}
+
+	sum, size, err := fileSHA256(part)
+	if err != nil {
+		return written, false, err
+	}
+
+	name := sum + mediaExt(enc)
+	final := filepath.Join(dir, name)
+	deduped := false
+	if _, err := os.Stat(final); err == nil {
+		// already have it from another item, keep it around as long as
+		// this one too
+		deduped = true
+		now := time.Now()
+		if err := os.Chtimes(final, now, now); err != nil {
+			return written, false, err
+		}
+		if err := os.Remove(part); err != nil {
+			return written, false, err
+		}
+		written -= size
+	} else if err := os.Rename(part, final); err != nil {
+		return written, false, err
+	}
+
+	enc.File = name
+	enc.SHA256 = sum
+	if enc.Length == 0 {
+		enc.Length = size
+	}
+	return written, deduped, nil
+}
+
+// fetchMedia appends rawURL from offset to file part, asking the server for
+// the missing range only.
+func fetchMedia(ctx context.Context, rawURL, part string, offset, remaining int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := mediaClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	var written int64
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the .part file is already complete
+		return 0, nil
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		// the server ignored the range, start over
+		flags |= os.O_TRUNC
+		written = -offset
+	default:
+		return 0, fmt.Errorf("%w: %s %s", ErrUnexpectedStatus, rawURL, resp.Status)
+	}
+
+	file, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(resp.Body, remaining-written+1))
+	written += n
+	if err != nil {
+		return written, err
+	}
+	if written > remaining {
+		file.Close()
+		os.Remove(part)
+		return -offset, fmt.Errorf("%w: %s", ErrMediaQuotaExceeded, rawURL)
+	}
+	return written, nil
+}
+
+// cleanupMedia removes files not touched within retention and forgets the
+// enclosures that pointed to them. Their SHA256 stays set, so they are not
+// downloaded again.
+func cleanupMedia(dir string, retention time.Duration, feeds *Feeds, now time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return removed, err
+		}
+		if now.Sub(info.ModTime()) < retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	for _, userFeed := range feeds.Items {
+		for _, item := range userFeed.UnprocessedItems {
+			for _, enc := range item.Enclosures {
+				if enc.File == "" {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(dir, enc.File)); errors.Is(err, fs.ErrNotExist) {
+					enc.File = ""
+				}
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func fileSHA256(name string) (string, int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// mediaExt picks a file extension for enc from its URL, or its MIME type
+// when the URL has none.
+func mediaExt(enc *Enclosure) string {
+	if u, err := url.Parse(enc.URL); err == nil {
+		ext := strings.ToLower(path.Ext(u.Path))
+		if len(ext) > 1 && len(ext) <= 6 {
+			return ext
+		}
+	}
+	if exts, _ := mime.ExtensionsByType(enc.Type); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+var mediaTestEpisode = bytes.Repeat([]byte("episode "), 128)
+
+func newMediaTestServer(t *testing.T) (*httptest.Server, *[]string) {
+	var ranges []string
+	mux := http.NewServeMux()
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "episode.mp3", time.Time{}, bytes.NewReader(mediaTestEpisode))
+	}
+	mux.HandleFunc("/ep1.mp3", serve)
+	mux.HandleFunc("/mirror/ep1.mp3", serve)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &ranges
+}
+
+func newMediaTestFeeds(config *MediaConfig, urls ...string) *Feeds {
+	userFeed := newFeed(FEED_TYPE_RSS, "https://example.com/podcast.xml")
+	for _, u := range urls {
+		userFeed.UnprocessedItems = append(userFeed.UnprocessedItems, &UnprocessedItem{
+			GUID:       u,
+			Enclosures: []*Enclosure{{URL: u, Type: "audio/mpeg"}},
+		})
+	}
+	return &Feeds{Settings: &Settings{Media: config}, Items: []*Feed{userFeed}}
+}
+
+func Test_downloadMedia(t *testing.T) {
+	srv, ranges := newMediaTestServer(t)
+	log := setupLogger(io.Discard)
+
+	t.Run("ResumeAndDedupe", func(t *testing.T) {
+		dir := t.TempDir()
+		feeds := newMediaTestFeeds(&MediaConfig{}, srv.URL+"/ep1.mp3", srv.URL+"/mirror/ep1.mp3")
+
+		// an interrupted earlier run
+		part := filepath.Join(dir, GetSHA256(srv.URL+"/ep1.mp3")+mediaPartSuffix)
+		os.WriteFile(part, mediaTestEpisode[:100], 0o644)
+		*ranges = nil
+
+		result, err := downloadMedia(context.Background(), feeds, dir, 0, log)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result.Downloaded != 1 || result.Deduped != 1 {
+			t.Errorf("expected one download and one duplicate, got %+v", result)
+		}
+		if (*ranges)[0] != "bytes=100-" {
+			t.Errorf("expected the download to resume, got range %q", (*ranges)[0])
+		}
+
+		first := feeds.Items[0].UnprocessedItems[0].Enclosures[0]
+		second := feeds.Items[0].UnprocessedItems[1].Enclosures[0]
+		if first.File == "" || first.File != second.File || !strings.HasSuffix(first.File, ".mp3") {
+			t.Errorf("expected both enclosures to share one file, got %q and %q", first.File, second.File)
+		}
+		data, _ := os.ReadFile(filepath.Join(dir, first.File))
+		if !bytes.Equal(data, mediaTestEpisode) {
+			t.Errorf("downloaded file does not match")
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("expected a single file in the media dir, got %d", len(entries))
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		dir := t.TempDir()
+		feeds := newMediaTestFeeds(&MediaConfig{QuotaBytes: 512}, srv.URL+"/ep1.mp3")
+
+		_, err := downloadMedia(context.Background(), feeds, dir, 0, log)
+		if !errors.Is(err, ErrMediaQuotaExceeded) {
+			t.Errorf("expected quota error, got %v", err)
+		}
+		if size, _ := dirSize(dir); size != 0 {
+			t.Errorf("expected nothing left behind, got %d bytes", size)
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		dir := t.TempDir()
+		feeds := newMediaTestFeeds(&MediaConfig{RetentionDays: 7}, srv.URL+"/ep1.mp3")
+		if _, err := downloadMedia(context.Background(), feeds, dir, 0, log); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		enc := feeds.Items[0].UnprocessedItems[0].Enclosures[0]
+		old := time.Now().Add(-8 * 24 * time.Hour)
+		os.Chtimes(filepath.Join(dir, enc.File), old, old)
+
+		result, err := downloadMedia(context.Background(), feeds, dir, 0, log)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result.Removed != 1 || result.Downloaded != 0 || enc.File != "" || enc.SHA256 == "" {
+			t.Errorf("expected the expired file removed and not fetched again, got %+v, %+v", result, enc)
+		}
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		_, err := downloadMedia(context.Background(), &Feeds{}, t.TempDir(), 0, log)
+		if !errors.Is(err, ErrMediaNotConfigured) {
+			t.Errorf("expected ErrMediaNotConfigured, got %v", err)
+		}
+	})
+}
+
+func Test_newUnprocessedItemEnclosures(t *testing.T) {
+	item := newUnprocessedItem(&gofeed.Item{
+		GUID:       "ep-1",
+		Enclosures: []*gofeed.Enclosure{{URL: "https://example.com/ep1.mp3", Type: "audio/mpeg", Length: "1024"}},
+		ITunesExt:  &ext.ITunesItemExtension{Duration: "1:02:03", Episode: "1", Explicit: "false"},
+	})
+
+	if len(item.Enclosures) != 1 {
+		t.Fatalf("expected 1 enclosure, got %d", len(item.Enclosures))
+	}
+	enc := item.Enclosures[0]
+	if enc.Length != 1024 || enc.Duration != 3723 || enc.Type != "audio/mpeg" {
+		t.Errorf("unexpected enclosure %+v", enc)
+	}
+	if item.ITunes == nil || item.ITunes.Episode != "1" {
+		t.Errorf("expected iTunes metadata, got %+v", item.ITunes)
+	}
+}
+
+func Test_parseDuration(t *testing.T) {
+	tests := map[string]int{"": 0, "3723": 3723, "62:03": 3723, "1:02:03": 3723, "1:xx": 0}
+	for in, want := range tests {
+		if got := parseDuration(in); got != want {
+			t.Errorf("parseDuration(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package rss_reader
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+)
+
+// Middleware post-processes the items a feed update just queued, before
+// they are saved. Middlewares are set up per user and configured per feed;
+// one that is not configured for a feed leaves its items alone.
+type Middleware interface {
+	Name() string
+	Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error
+}
+
+// pipeline runs middlewares in order. A failing middleware is logged and
+// skipped, the items are queued anyway.
+type pipeline []Middleware
+
+// newPipeline sets up the middlewares of the user owning userFeedsFile.
+func newPipeline(feeds *Feeds, userFeedsFile string) pipeline {
+	settings := feeds.Settings
+	if settings == nil {
+		settings = &Settings{}
+	}
+
+	cacheDir := filepath.Join(userDir(userFeedsFile), "cache")
+	return pipeline{
+		newCanonicalizer(settings.Canonical),
+		newExpander(filepath.Join(cacheDir, "expand")),
+		newSummarizer(settings.Summarize),
+		newTopicTagger(feeds, settings.Topics),
+		newPicturizer(filepath.Join(SERVICE_DIR, "images"), settings.Picturize),
+		newTranslateMiddleware(settings.Translate),
+		newNotifyMiddleware(newNotifiers(settings, newDeadLetters(deadLetterFile(userFeedsFile)))),
+	}
+}
+
+func (p pipeline) process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem, log *slog.Logger) error {
+	if len(items) == 0 {
+		return nil
+	}
+	for _, m := range p {
+		if err := m.Process(ctx, userFeed, items); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn("middleware failed", "middleware", m.Name(), "url", userFeed.Url, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeliveryAttempts = 8
+	maxDeliveryBackoff      = 6 * time.Hour
+	// a rate limit with a wait up to this long is waited out, a few times
+	maxRateLimitWait  = time.Minute
+	maxRateLimitWaits = 3
+	// runes of item text in chat messages
+	notifyTextRunes = 500
+)
+
+// deliveryBackoff is the wait before the first retry of a delivery, it
+// doubles with every further attempt.
+var deliveryBackoff = 5 * time.Minute
+
+var (
+	ErrDeliveryRejected      = errors.New("delivery rejected")
+	ErrEndpointDown          = errors.New("endpoint down")
+	ErrNotifierNotConfigured = errors.New("notifier not configured")
+	ErrRateLimited           = errors.New("rate limited")
+)
+
+// Notifier is a consumer that pushes queued items to a service outside.
+// ID names the consumer in the delivery state of items, it must stay the
+// same across runs. Notify makes one attempt and moves the items on to
+// the next DELIVERY_* state.
+type Notifier interface {
+	Name() string
+	ID() string
+	Notify(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error
+}
+
+// newNotifiers sets up the notifiers the user configured.
+func newNotifiers(settings *Settings, dead *deadLetters) []Notifier {
+	if settings == nil {
+		return nil
+	}
+	var notifiers []Notifier
+	for _, config := range settings.Webhooks {
+		notifiers = append(notifiers, newWebhookNotifier(config, dead))
+	}
+	for _, config := range settings.Slack {
+		notifiers = append(notifiers, newSlackNotifier(config, dead))
+	}
+	for _, config := range settings.Discord {
+		notifiers = append(notifiers, newDiscordNotifier(config, dead))
+	}
+	for _, config := range settings.Matrix {
+		notifiers = append(notifiers, newMatrixNotifier(config, dead))
+	}
+	return notifiers
+}
+
+// notifyMiddleware is the "notify" middleware: it marks new items pending
+// for every notifier of the user. They are sent once the feeds are
+// updated, see deliverItems.
+type notifyMiddleware struct {
+	notifiers []Notifier
+}
+
+func newNotifyMiddleware(notifiers []Notifier) *notifyMiddleware {
+	return &notifyMiddleware{notifiers: notifiers}
+}
+
+func (n *notifyMiddleware) Name() string {
+	return "notify"
+}
+
+func (n *notifyMiddleware) Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	now := time.Now()
+	for _, item := range items {
+		for _, notifier := range n.notifiers {
+			if item.Deliveries[notifier.ID()] == nil {
+				item.setDelivery(notifier.ID(), DELIVERY_PENDING, now)
+			}
+		}
+	}
+	return nil
+}
+
+// message is one request of a notifier. key identifies the payload, in
+// the dead-letter store too.
+type message struct {
+	key    string
+	url    string
+	header http.Header
+	body   []byte
+}
+
+// endpoint is where a notifier delivers to. A delivery is one attempt,
+// but short rate limit waits are waited out. Network errors, 408, 429
+// and server errors are worth a retry; other statuses reject the payload
+// for good. Once an attempt failed the endpoint is taken for down for the
+// rest of the run.
+//
+// Messages go out one at a time, at least interval apart, and wait longer
+// where the service says its rate limit is used up.
+type endpoint struct {
+	channel string
+	// consumer is the ID of the notifier, made from the channel and target
+	consumer    string
+	method      string
+	interval    time.Duration
+	maxAttempts int
+	dead        *deadLetters
+
+	mu      sync.Mutex
+	next    time.Time
+	down    error
+	retryAt time.Time
+}
+
+func newEndpoint(channel, target string, maxAttempts int, dead *deadLetters) *endpoint {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultDeliveryAttempts
+	}
+	return &endpoint{
+		channel:     channel,
+		consumer:    channel + "-" + GetSHA256(target)[:12],
+		method:      http.MethodPost,
+		maxAttempts: maxAttempts,
+		dead:        dead,
+	}
+}
+
+// deliver sends msg. With a failure worth a retry it returns how long the
+// service asked to wait, if it did.
+func (e *endpoint) deliver(ctx context.Context, msg *message) (time.Duration, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.down != nil {
+		return time.Until(e.retryAt), fmt.Errorf("%w: %w", ErrEndpointDown, e.down)
+	}
+
+	for waits := 0; ; waits++ {
+		if err := sleepUntil(ctx, e.next); err != nil {
+			return 0, err
+		}
+		retryAfter, err := e.send(ctx, msg)
+		if err == nil || ctx.Err() != nil || errors.Is(err, ErrDeliveryRejected) {
+			return 0, err
+		}
+		// a short rate limit is no failure
+		if errors.Is(err, ErrRateLimited) && retryAfter <= maxRateLimitWait && waits < maxRateLimitWaits {
+			e.next = time.Now().Add(max(retryAfter, e.interval))
+			continue
+		}
+		e.down = err
+		e.retryAt = time.Now().Add(max(retryAfter, deliveryBackoff))
+		return retryAfter, err
+	}
+}
+
+// send makes one attempt. With a retryable failure it returns how long
+// the service asked to wait, if it did.
+func (e *endpoint) send(ctx context.Context, msg *message) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, e.method, msg.url, bytes.NewReader(msg.body))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrDeliveryRejected, err)
+	}
+	for name, values := range msg.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	e.next = time.Now().Add(e.interval)
+	if err != nil {
+		return 0, err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset := parseRetryAfter(resp.Header.Get("X-RateLimit-Reset-After")); reset > e.interval {
+			e.next = time.Now().Add(min(reset, maxRateLimitWait))
+		}
+	}
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code <= 299:
+		return 0, nil
+	case code == http.StatusTooManyRequests:
+		return retryHint(resp.Header, body), fmt.Errorf("%w: %s", ErrRateLimited, resp.Status)
+	case code == http.StatusRequestTimeout || code >= 500:
+		return retryHint(resp.Header, body), fmt.Errorf("%w: %s", ErrUnexpectedStatus, resp.Status)
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrDeliveryRejected, resp.Status)
+	}
+}
+
+// bury puts a message the endpoint gave up on in the dead-letter store,
+// with the items of userFeed that died with it.
+func (e *endpoint) bury(msg *message, userFeed *Feed, guids []string, attempts int, cause error) error {
+	return e.dead.add(&DeadLetter{
+		Time:     time.Now().UTC(),
+		Channel:  e.channel,
+		Consumer: e.consumer,
+		Target:   msg.url,
+		Key:      msg.key,
+		Feed:     userFeed.Hash,
+		GUIDs:    guids,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Payload:  msg.body,
+	})
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryHint is how long a service asked to wait before a retry: the
+// Retry-After header, or the retry_after (Discord, in seconds) or
+// retry_after_ms (Matrix) of a JSON error.
+func retryHint(header http.Header, body []byte) time.Duration {
+	if d := parseRetryAfter(header.Get("Retry-After")); d > 0 {
+		return d
+	}
+	var hint struct {
+		RetryAfter   float64 `json:"retry_after"`
+		RetryAfterMS int64   `json:"retry_after_ms"`
+	}
+	if json.Unmarshal(body, &hint) != nil {
+		return 0
+	}
+	if hint.RetryAfterMS > 0 {
+		return time.Duration(hint.RetryAfterMS) * time.Millisecond
+	}
+	return time.Duration(hint.RetryAfter * float64(time.Second))
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or as a date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(max(seconds, 0) * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// splitFunc splits items into the batches a notifier sends them in.
+type splitFunc func(items []*UnprocessedItem) [][]*UnprocessedItem
+
+// chunksOf splits items into batches of at most size.
+func chunksOf(size int) splitFunc {
+	return func(items []*UnprocessedItem) [][]*UnprocessedItem {
+		return chunkItems(items, size)
+	}
+}
+
+// chunkItems splits items into batches of at most size.
+func chunkItems(items []*UnprocessedItem, size int) [][]*UnprocessedItem {
+	var batches [][]*UnprocessedItem
+	for len(items) > size {
+		batches = append(batches, items[:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		batches = append(batches, items)
+	}
+	return batches
+}
+
+// itemKey identifies an item across deliveries and retries.
+func itemKey(userFeed *Feed, item *UnprocessedItem) string {
+	return GetSHA256(userFeed.Hash + "\n" + item.GUID)
+}
+
+// batchKey identifies a batch of items; a batch of one by its item's key.
+func batchKey(userFeed *Feed, batch []*UnprocessedItem) string {
+	if len(batch) == 1 {
+		return itemKey(userFeed, batch[0])
+	}
+	keys := make([]string, len(batch))
+	for i, item := range batch {
+		keys[i] = itemKey(userFeed, item)
+	}
+	return GetSHA256(strings.Join(keys, "\n"))
+}
+
+// itemTitle is the title to show for an item, translated if it was.
+func itemTitle(item *UnprocessedItem) string {
+	if item.Translation != nil && item.Translation.Title != "" {
+		return item.Translation.Title
+	}
+	if item.Title != "" {
+		return item.Title
+	}
+	return item.URL
+}
+
+// itemImage is the picture to show with an item, if there is one.
+func itemImage(item *UnprocessedItem) string {
+	if item.LeadImage != nil {
+		return item.LeadImage.URL
+	}
+	return item.Image
+}
+
+// itemAuthors joins the names of the authors of an item.
+func itemAuthors(item *UnprocessedItem) string {
+	var names []string
+	for _, author := range item.Authors {
+		if author.Name != "" {
+			names = append(names, author.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// notifyText is the text of an item in a chat message, in one of the
+// RENDER_* formats: its summary where there is one, else its description.
+func notifyText(item *UnprocessedItem, format string) string {
+	if item.Summary != "" {
+		summary := truncateText(item.Summary, notifyTextRunes)
+		switch format {
+		case RENDER_MARKDOWN:
+			return escapeMarkdown(summary)
+		case RENDER_TELEGRAM:
+			return html.EscapeString(summary)
+		}
+		return summary
+	}
+	return renderHTML(item.Description, item.URL, format, notifyTextRunes)
+}
+
+func feedTitle(userFeed *Feed) string {
+	if userFeed.Title != "" {
+		return userFeed.Title
+	}
+	return userFeed.Url
+}
@@ -0,0 +1,355 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+const (
+	// content images smaller than this are icons, buttons or trackers
+	minLeadImageWidth  = 200
+	minLeadImageHeight = 100
+	maxImagePixels     = 40_000_000
+	maxImageCandidates = 5
+	thumbnailQuality   = 85
+)
+
+const (
+	IMAGE_SOURCE_MEDIA     = "media"
+	IMAGE_SOURCE_ENCLOSURE = "enclosure"
+	IMAGE_SOURCE_OPENGRAPH = "og"
+	IMAGE_SOURCE_CONTENT   = "content"
+)
+
+var defaultThumbnailWidths = []int{320, 640}
+
+var (
+	ErrImageTooLarge = errors.New("image too large")
+	ErrImageTooSmall = errors.New("image too small")
+)
+
+// picturizer is the "picturize" middleware: it picks a lead image for each
+// item, downloads it and stores JPEG thumbnails under dir, named after the
+// checksum of the original so the same picture is stored once for all
+// users.
+type picturizer struct {
+	dir    string
+	config *PicturizeConfig
+}
+
+type imageCandidate struct {
+	URL    string
+	Source string
+}
+
+func newPicturizer(dir string, config *PicturizeConfig) *picturizer {
+	return &picturizer{dir: dir, config: config}
+}
+
+func (p *picturizer) Name() string {
+	return "picturize"
+}
+
+func (p *picturizer) Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	if p.config == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, item := range items {
+		if item.LeadImage != nil {
+			continue
+		}
+		if err := p.picturize(ctx, item); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", item.GUID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// picturize tries the candidates of item in order and keeps the first one
+// that downloads and decodes. Finding no image at all is not an error.
+func (p *picturizer) picturize(ctx context.Context, item *UnprocessedItem) error {
+	var lastErr error
+	tried := 0
+	for candidate := range imageCandidates(ctx, item) {
+		if tried == maxImageCandidates {
+			break
+		}
+		tried++
+
+		lead, err := p.store(ctx, candidate)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		item.LeadImage = lead
+		return nil
+	}
+	if errors.Is(lastErr, ErrImageTooSmall) {
+		return nil
+	}
+	return lastErr
+}
+
+// imageCandidates yields the possible lead images of item, best source
+// first: media:content and media:thumbnail, image enclosures, the podcast
+// cover, the page's og:image and finally images in the content. The page
+// is only fetched when the feed itself has nothing.
+func imageCandidates(ctx context.Context, item *UnprocessedItem) func(yield func(imageCandidate) bool) {
+	return func(yield func(imageCandidate) bool) {
+		seen := map[string]bool{}
+		emit := func(rawURL, source string) bool {
+			rawURL = strings.TrimSpace(rawURL)
+			if rawURL == "" || seen[rawURL] {
+				return true
+			}
+			seen[rawURL] = true
+			return yield(imageCandidate{URL: resolveURL(item.URL, rawURL), Source: source})
+		}
+
+		for _, media := range mediaImages(item) {
+			if !emit(media, IMAGE_SOURCE_MEDIA) {
+				return
+			}
+		}
+		for _, enc := range item.Enclosures {
+			if strings.HasPrefix(enc.Type, "image/") && !emit(enc.URL, IMAGE_SOURCE_ENCLOSURE) {
+				return
+			}
+		}
+		if item.ITunes != nil && !emit(item.ITunes.Image, IMAGE_SOURCE_ENCLOSURE) {
+			return
+		}
+
+		if item.URL != "" {
+			if page, err := httpGet(ctx, item.URL); err == nil {
+				if doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body)); err == nil {
+					og := doc.Find(`meta[property="og:image"], meta[property="og:image:url"], meta[name="twitter:image"]`).First()
+					if !emit(og.AttrOr("content", ""), IMAGE_SOURCE_OPENGRAPH) {
+						return
+					}
+				}
+			}
+		}
+
+		for _, content := range []string{item.FullHTML, item.Content, item.Description} {
+			if content == "" {
+				continue
+			}
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+			if err != nil {
+				continue
+			}
+			stop := false
+			doc.Find("img[src]").EachWithBreak(func(_ int, img *goquery.Selection) bool {
+				if tooSmall(img.AttrOr("width", ""), minLeadImageWidth) || tooSmall(img.AttrOr("height", ""), minLeadImageHeight) {
+					return true
+				}
+				stop = !emit(img.AttrOr("src", ""), IMAGE_SOURCE_CONTENT)
+				return !stop
+			})
+			if stop {
+				return
+			}
+		}
+	}
+}
+
+// mediaImages returns the image URLs of Media RSS elements, also inside
+// media:group, thumbnails first.
+func mediaImages(item *UnprocessedItem) []string {
+	media := item.Extensions["media"]
+	if media == nil {
+		return nil
+	}
+
+	var thumbnails, contents []string
+	for _, group := range append([]map[string][]ext.Extension{media}, mediaGroups(media)...) {
+		for _, thumb := range group["thumbnail"] {
+			thumbnails = append(thumbnails, thumb.Attrs["url"])
+		}
+		for _, content := range group["content"] {
+			if strings.HasPrefix(content.Attrs["type"], "image/") || content.Attrs["medium"] == "image" {
+				contents = append(contents, content.Attrs["url"])
+			}
+		}
+	}
+	return append(thumbnails, contents...)
+}
+
+func mediaGroups(media map[string][]ext.Extension) []map[string][]ext.Extension {
+	var groups []map[string][]ext.Extension
+	for _, group := range media["group"] {
+		groups = append(groups, group.Children)
+	}
+	return groups
+}
+
+func tooSmall(attr string, limit int) bool {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(attr), "px"))
+	return err == nil && n < limit
+}
+
+func resolveURL(base, ref string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := baseURL.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return refURL.String()
+}
+
+// store downloads candidate and writes its thumbnails. Thumbnails that are
+// already there are reused.
+func (p *picturizer) store(ctx context.Context, candidate imageCandidate) (*LeadImage, error) {
+	page, err := httpGet(ctx, candidate.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+	if candidate.Source == IMAGE_SOURCE_CONTENT && (config.Width < minLeadImageWidth || config.Height < minLeadImageHeight) {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooSmall, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := GetSHA256(string(page.Body))
+	lead := &LeadImage{
+		URL:    candidate.URL,
+		Source: candidate.Source,
+		Width:  config.Width,
+		Height: config.Height,
+		SHA256: sum,
+	}
+
+	widths := p.config.Widths
+	if len(widths) == 0 {
+		widths = defaultThumbnailWidths
+	}
+	done := map[int]bool{}
+	for _, width := range widths {
+		// never upscale
+		width = min(width, config.Width)
+		if width <= 0 || done[width] {
+			continue
+		}
+		done[width] = true
+
+		thumb, err := p.thumbnail(src, sum, width)
+		if err != nil {
+			return nil, err
+		}
+		lead.Thumbnails = append(lead.Thumbnails, thumb)
+	}
+	return lead, nil
+}
+
+func (p *picturizer) thumbnail(src image.Image, sum string, width int) (*Thumbnail, error) {
+	bounds := src.Bounds()
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	name := filepath.Join(sum[:2], fmt.Sprintf("%s-%d.jpg", sum, width))
+	thumb := &Thumbnail{Width: width, Height: height, File: name}
+
+	file := filepath.Join(p.dir, name)
+	if _, err := os.Stat(file); err == nil {
+		return thumb, nil
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resizeImage(src, width, height), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return nil, err
+	}
+	// concurrent updates may render the same thumbnail, each into its own
+	// temporary file
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return thumb, os.Rename(tmp.Name(), file)
+}
+
+// resizeImage scales src to width x height by averaging the source pixels
+// under every target pixel, flattened onto white since JPEG has no alpha.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := bounds.Min.Y + y*sh/height
+		y1 := max(bounds.Min.Y+(y+1)*sh/height, y0+1)
+		for x := range width {
+			x0 := bounds.Min.X + x*sw/width
+			x1 := max(bounds.Min.X+(x+1)*sw/width, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			white := 0xffff*n - a
+			dst.Set(x, y, color.RGBA64{
+				R: uint16((r + white) / n),
+				G: uint16((g + white) / n),
+				B: uint16((b + white) / n),
+				A: 0xffff,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,145 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+func testPNG(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func newPicturizeTestServer(t *testing.T) *httptest.Server {
+	large, pixel := testPNG(800, 400), testPNG(1, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/large.png", func(w http.ResponseWriter, r *http.Request) { w.Write(large) })
+	mux.HandleFunc("/pixel.png", func(w http.ResponseWriter, r *http.Request) { w.Write(pixel) })
+	mux.HandleFunc("/og", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><head><meta property="og:image" content="/large.png"></head><body></body></html>`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><body>No pictures here</body></html>`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_picturizer(t *testing.T) {
+	srv := newPicturizeTestServer(t)
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed.xml")
+
+	tests := []struct {
+		name   string
+		item   *UnprocessedItem
+		source string
+	}{
+		{
+			name: "MediaThumbnail",
+			item: &UnprocessedItem{URL: srv.URL + "/og", Extensions: ext.Extensions{"media": {
+				"thumbnail": {{Attrs: map[string]string{"url": srv.URL + "/large.png"}}},
+			}}},
+			source: IMAGE_SOURCE_MEDIA,
+		},
+		{
+			name:   "Enclosure",
+			item:   &UnprocessedItem{Enclosures: []*Enclosure{{URL: srv.URL + "/large.png", Type: "image/png"}}},
+			source: IMAGE_SOURCE_ENCLOSURE,
+		},
+		{
+			name:   "OpenGraph",
+			item:   &UnprocessedItem{URL: srv.URL + "/og"},
+			source: IMAGE_SOURCE_OPENGRAPH,
+		},
+		{
+			name:   "ContentSkipsSmallImages",
+			item:   &UnprocessedItem{URL: srv.URL + "/plain", Content: `<img src="/pixel.png"><img src="/icon.png" width="16"><img src="/large.png">`},
+			source: IMAGE_SOURCE_CONTENT,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p := newPicturizer(dir, &PicturizeConfig{Widths: []int{320, 640, 1024}})
+			if err := p.Process(context.Background(), feed, []*UnprocessedItem{tt.item}); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+
+			lead := tt.item.LeadImage
+			if lead == nil || lead.Source != tt.source || lead.URL != srv.URL+"/large.png" {
+				t.Fatalf("expected lead image from %s, got %+v", tt.source, lead)
+			}
+			if len(lead.Thumbnails) != 3 {
+				t.Fatalf("expected 3 thumbnails, got %d", len(lead.Thumbnails))
+			}
+			// 1024 is capped at the original width
+			for i, want := range [][2]int{{320, 160}, {640, 320}, {800, 400}} {
+				thumb := lead.Thumbnails[i]
+				f, err := os.Open(filepath.Join(dir, thumb.File))
+				if err != nil {
+					t.Fatalf("thumbnail not stored: %v", err)
+				}
+				config, err := jpeg.DecodeConfig(f)
+				f.Close()
+				if err != nil || config.Width != want[0] || config.Height != want[1] || thumb.Width != want[0] {
+					t.Errorf("expected a %dx%d thumbnail, got %+v (%v)", want[0], want[1], config, err)
+				}
+			}
+		})
+	}
+
+	t.Run("NoImage", func(t *testing.T) {
+		item := &UnprocessedItem{URL: srv.URL + "/plain", Content: `<img src="/pixel.png">`}
+		p := newPicturizer(t.TempDir(), &PicturizeConfig{})
+		if err := p.Process(context.Background(), feed, []*UnprocessedItem{item}); err != nil {
+			t.Errorf("expected no error, got: %v", err)
+		}
+		if item.LeadImage != nil {
+			t.Errorf("expected no lead image, got %+v", item.LeadImage)
+		}
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		item := &UnprocessedItem{URL: srv.URL + "/og"}
+		newPicturizer(t.TempDir(), nil).Process(context.Background(), feed, []*UnprocessedItem{item})
+		if item.LeadImage != nil {
+			t.Errorf("expected picturize to be off without settings")
+		}
+	})
+}
+
+func Test_resizeImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for x := range 4 {
+		src.Set(x, 0, color.NRGBA{A: 255})
+	}
+
+	dst := resizeImage(src, 2, 2)
+	// top row: half black, half transparent, flattened onto white
+	if c := dst.RGBAAt(0, 0); c.R < 120 || c.R > 135 || c.A != 255 {
+		t.Errorf("expected mid grey, got %+v", c)
+	}
+	if c := dst.RGBAAt(1, 1); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("expected transparent to become white, got %+v", c)
+	}
+}
@@ -0,0 +1,372 @@
+package rss_reader
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	RENDER_TELEGRAM = "telegram"
+	RENDER_MARKDOWN = "markdown"
+	RENDER_TEXT     = "text"
+)
+
+const ellipsis = "…"
+
+// renderTelegram renders item content as the HTML subset Telegram accepts.
+// It and the other renderers sanitize first, so they are safe on raw feed
+// content too. A limit above zero caps the text the reader sees, in runes;
+// cut text ends in an ellipsis, and markup is always closed again.
+func renderTelegram(fragment, base string, limit int) string {
+	return renderHTML(fragment, base, RENDER_TELEGRAM, limit)
+}
+
+// renderMarkdown renders item content as CommonMark.
+func renderMarkdown(fragment, base string, limit int) string {
+	return renderHTML(fragment, base, RENDER_MARKDOWN, limit)
+}
+
+// renderText renders item content as plain text.
+func renderText(fragment, base string, limit int) string {
+	return renderHTML(fragment, base, RENDER_TEXT, limit)
+}
+
+func renderHTML(fragment, base, format string, limit int) string {
+	if strings.TrimSpace(fragment) == "" {
+		return ""
+	}
+	root, err := defaultSanitizePolicy.sanitizeTree(fragment, base)
+	if err != nil {
+		return truncateText(collapseSpaces(fragment), limit)
+	}
+	r := &renderer{format: format, limit: limit}
+	r.children(root)
+	return strings.TrimSpace(r.out.String())
+}
+
+// truncateText cuts s to at most limit runes, at a word boundary when
+// there is one in reach, and marks the cut with an ellipsis.
+func truncateText(s string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	if limit <= 1 {
+		return ellipsis
+	}
+	runes := []rune(s)
+	cut := string(runes[:limit-1])
+	if !unicode.IsSpace(runes[limit-1]) {
+		if i := strings.LastIndexAny(cut, " \t\n"); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRight(cut, " \t\n.,;:") + ellipsis
+}
+
+// renderer writes a sanitized tree in one of the output formats. Text is
+// escaped as it is written, so a cut never ends inside a tag or an entity.
+type renderer struct {
+	format string
+	limit  int
+	used   int
+	done   bool
+	out    strings.Builder
+
+	// whitespace owed before the next text
+	space    bool
+	newlines int
+	// written at the start of every line, for Markdown quotes and lists
+	prefix    string
+	lineStart bool
+	// right after markup that opens an element, breaks are not owed
+	opened bool
+	pre    bool
+	code   bool
+}
+
+func (r *renderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil && !r.done; c = c.NextSibling {
+		r.node(c)
+	}
+}
+
+func (r *renderer) node(n *html.Node) {
+	if n.Type == html.TextNode {
+		r.text(n.Data)
+		return
+	}
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	switch n.Data {
+	case "br":
+		r.newlines = max(r.newlines, 1)
+	case "hr":
+		r.block(2)
+		if r.format == RENDER_MARKDOWN {
+			r.markup("---")
+		}
+		r.block(2)
+	case "img":
+		if r.format == RENDER_MARKDOWN {
+			r.markup("![" + escapeMarkdown(getAttr(n, "alt")) + "](" + markdownURL(getAttr(n, "src")) + ")")
+		}
+	case "p", "div", "figure", "table", "dl":
+		r.block(2)
+		r.children(n)
+		r.block(2)
+	case "tr", "dt", "dd", "figcaption":
+		r.block(1)
+		r.children(n)
+		r.block(1)
+	case "td", "th":
+		r.space = true
+		r.children(n)
+		r.space = true
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		r.block(2)
+		level, _ := strconv.Atoi(n.Data[1:])
+		switch r.format {
+		case RENDER_MARKDOWN:
+			r.markup(strings.Repeat("#", level) + " ")
+			r.children(n)
+		case RENDER_TELEGRAM:
+			r.wrap(n, "<b>", "</b>")
+		default:
+			r.children(n)
+		}
+		r.block(2)
+	case "ul", "ol":
+		r.block(2)
+		r.list(n)
+		r.block(2)
+	case "blockquote":
+		r.block(2)
+		switch r.format {
+		case RENDER_MARKDOWN:
+			r.breakLines()
+			prefix := r.prefix
+			r.prefix += "> "
+			r.children(n)
+			r.prefix = prefix
+		case RENDER_TELEGRAM:
+			r.wrap(n, "<blockquote>", "</blockquote>")
+		default:
+			r.children(n)
+		}
+		r.block(2)
+	case "pre":
+		r.block(2)
+		r.pre = true
+		switch r.format {
+		case RENDER_MARKDOWN:
+			r.markup("```\n")
+			r.children(n)
+			r.out.WriteString("\n```")
+		case RENDER_TELEGRAM:
+			r.wrap(n, "<pre>", "</pre>")
+		default:
+			r.children(n)
+		}
+		r.pre = false
+		r.block(2)
+	case "a":
+		href := getAttr(n, "href")
+		switch {
+		case href == "":
+			r.children(n)
+		case r.format == RENDER_MARKDOWN:
+			r.wrap(n, "[", "]("+markdownURL(href)+")")
+		case r.format == RENDER_TELEGRAM:
+			r.wrap(n, `<a href="`+html.EscapeString(href)+`">`, "</a>")
+		default:
+			r.children(n)
+		}
+	case "b", "strong":
+		r.inline(n, "**", "<b>", "</b>")
+	case "i", "em":
+		r.inline(n, "_", "<i>", "</i>")
+	case "u", "ins":
+		r.inline(n, "", "<u>", "</u>")
+	case "s", "del", "strike":
+		r.inline(n, "~~", "<s>", "</s>")
+	case "code":
+		if r.pre {
+			r.children(n)
+			break
+		}
+		r.code = true
+		r.inline(n, "`", "<code>", "</code>")
+		r.code = false
+	default:
+		r.children(n)
+	}
+}
+
+func (r *renderer) inline(n *html.Node, markdown, open, close string) {
+	switch {
+	case r.format == RENDER_MARKDOWN && markdown != "":
+		r.wrap(n, markdown, markdown)
+	case r.format == RENDER_TELEGRAM:
+		r.wrap(n, open, close)
+	default:
+		r.children(n)
+	}
+}
+
+// wrap renders the children of n between open and close. Close is written
+// even when the limit is hit inside.
+func (r *renderer) wrap(n *html.Node, open, close string) {
+	r.markup(open)
+	r.children(n)
+	r.out.WriteString(close)
+}
+
+func (r *renderer) list(n *html.Node) {
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+	for c := n.FirstChild; c != nil && !r.done; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			r.node(c)
+			continue
+		}
+		r.block(1)
+		marker := "• "
+		if r.format == RENDER_MARKDOWN {
+			marker = "- "
+		}
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		r.markup(marker)
+
+		prefix := r.prefix
+		if r.format == RENDER_MARKDOWN {
+			r.prefix += strings.Repeat(" ", len(marker))
+		}
+		r.children(c)
+		r.prefix = prefix
+		r.block(1)
+	}
+}
+
+// block asks for at least n line breaks before whatever comes next.
+func (r *renderer) block(n int) {
+	r.newlines = max(r.newlines, n)
+	r.space = false
+}
+
+// breakLines writes the line breaks owed right away, so they still carry
+// the current prefix.
+func (r *renderer) breakLines() {
+	if r.out.Len() > 0 && r.newlines > 0 {
+		r.out.WriteString(strings.Repeat("\n"+strings.TrimRight(r.prefix, " "), r.newlines-1) + "\n")
+		r.lineStart = true
+	}
+	r.newlines, r.space = 0, false
+}
+
+// flush writes the whitespace owed, and the line prefix at the start of a
+// line.
+func (r *renderer) flush() {
+	if r.out.Len() == 0 || r.opened || r.lineStart {
+		r.newlines = 0
+	}
+	if r.newlines > 0 {
+		r.used += r.newlines
+		r.breakLines()
+	} else if r.space && !r.lineStart {
+		r.used++
+		r.out.WriteString(" ")
+	}
+	if r.out.Len() == 0 || r.lineStart {
+		r.out.WriteString(r.prefix)
+	}
+	r.newlines, r.space, r.lineStart, r.opened = 0, false, false, false
+}
+
+// markup writes formatting that does not count against the limit.
+func (r *renderer) markup(s string) {
+	if r.done {
+		return
+	}
+	r.flush()
+	r.out.WriteString(s)
+	r.opened = true
+}
+
+func (r *renderer) text(s string) {
+	if r.done {
+		return
+	}
+	if r.pre {
+		r.words(s)
+		return
+	}
+	if s != "" && strings.TrimLeft(s, " \t\r\n\f") != s {
+		r.space = true
+	}
+	if fields := strings.Fields(s); len(fields) > 0 {
+		r.words(strings.Join(fields, " "))
+	}
+	if s != "" && strings.TrimRight(s, " \t\r\n\f") != s {
+		r.space = true
+	}
+}
+
+// words writes s as text, cut to what is left of the limit.
+func (r *renderer) words(s string) {
+	r.flush()
+	n := utf8.RuneCountInString(s)
+	if r.limit > 0 && r.used >= r.limit {
+		r.done = true
+		r.out.WriteString(ellipsis)
+		return
+	}
+	if r.limit > 0 && r.used+n > r.limit {
+		cut := truncateText(s, r.limit-r.used)
+		// rather no word than half of one, unless it is all there is
+		if fields := strings.Fields(s); r.used > 0 && len(fields) > 0 && len(cut)-len(ellipsis) < len(fields[0]) {
+			cut = ellipsis
+		}
+		s = cut
+		r.done = true
+	}
+	r.used += n
+	r.out.WriteString(r.escape(s))
+}
+
+func (r *renderer) escape(s string) string {
+	switch r.format {
+	case RENDER_TELEGRAM:
+		return html.EscapeString(s)
+	case RENDER_MARKDOWN:
+		if r.pre || r.code {
+			return s
+		}
+		return escapeMarkdown(s)
+	default:
+		return s
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "~", `\~`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// markdownURL keeps a link destination from ending the link early.
+func markdownURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E").Replace(u)
+}
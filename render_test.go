@@ -0,0 +1,91 @@
+package rss_reader
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const renderTestContent = `<h2>News &amp; notes</h2><p>Hello <b>bold <i>world</i></b>, see <a href="/more?a=1&b=2" onclick="x()">more</a>.<script>alert(1)</script></p>` +
+	`<ul><li>one</li><li>two <code>a*b</code></li></ul><blockquote><p>quoted</p></blockquote><pre>x  &lt; y</pre>`
+
+func Test_renderTelegram(t *testing.T) {
+	want := "<b>News &amp; notes</b>\n\n" +
+		`Hello <b>bold <i>world</i></b>, see <a href="https://example.com/more?a=1&amp;b=2">more</a>.` + "\n\n" +
+		"• one\n• two <code>a*b</code>\n\n" +
+		"<blockquote>quoted</blockquote>\n\n" +
+		"<pre>x  &lt; y</pre>"
+	if got := renderTelegram(renderTestContent, "https://example.com/", 0); got != want {
+		t.Errorf("renderTelegram() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_renderMarkdown(t *testing.T) {
+	want := "## News & notes\n\n" +
+		"Hello **bold _world_**, see [more](https://example.com/more?a=1&b=2).\n\n" +
+		"- one\n- two `a*b`\n\n" +
+		"> quoted\n\n" +
+		"```\nx  < y\n```"
+	if got := renderMarkdown(renderTestContent, "https://example.com/", 0); got != want {
+		t.Errorf("renderMarkdown() =\n%s\nwant\n%s", got, want)
+	}
+	if got := renderMarkdown(`<p>2*3 = [six]</p>`, "", 0); got != `2\*3 = \[six\]` {
+		t.Errorf("expected Markdown in text to be escaped, got %q", got)
+	}
+}
+
+func Test_renderText(t *testing.T) {
+	want := "News & notes\n\nHello bold world, see more.\n\n• one\n• two a*b\n\nquoted\n\nx  < y"
+	if got := renderText(renderTestContent, "", 0); got != want {
+		t.Errorf("renderText() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_renderTruncation(t *testing.T) {
+	content := `<p>The <b>quick brown fox</b> jumps over the <a href="https://example.com/">lazy dog</a> &amp; friends</p>`
+
+	tests := []struct {
+		render func(string, string, int) string
+		limit  int
+		want   string
+	}{
+		{renderTelegram, 14, "The <b>quick…</b>"},
+		{renderTelegram, 31, "The <b>quick brown fox</b> jumps over…"},
+		{renderTelegram, 40, `The <b>quick brown fox</b> jumps over the <a href="https://example.com/">lazy…</a>`},
+		{renderMarkdown, 14, "The **quick…**"},
+		{renderText, 14, "The quick…"},
+		{renderText, 100, "The quick brown fox jumps over the lazy dog & friends"},
+	}
+	for _, tt := range tests {
+		got := tt.render(content, "", tt.limit)
+		if got != tt.want {
+			t.Errorf("limit %d: got %q, want %q", tt.limit, got, tt.want)
+		}
+		if strings.Contains(got, "&am…") || strings.Count(got, "<b>") != strings.Count(got, "</b>") {
+			t.Errorf("limit %d: cut inside markup: %q", tt.limit, got)
+		}
+	}
+}
+
+func Test_truncateText(t *testing.T) {
+	tests := []struct {
+		in    string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"a sentence that is too long", 12, "a sentence…"},
+		{"Überlänge", 5, "Über…"},
+		{"anything", 1, "…"},
+		{"unlimited", 0, "unlimited"},
+	}
+	for _, tt := range tests {
+		got := truncateText(tt.in, tt.limit)
+		if got != tt.want {
+			t.Errorf("truncateText(%q, %d) = %q, want %q", tt.in, tt.limit, got, tt.want)
+		}
+		if tt.limit > 0 && utf8.RuneCountInString(got) > tt.limit {
+			t.Errorf("truncateText(%q, %d) is too long: %q", tt.in, tt.limit, got)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/mmcdole/gofeed"
 	"golang.org/x/sync/errgroup"
@@ -25,6 +27,8 @@ const (
 	E_ENCDOING_UNPROCESSED
 	E_CONCURRENT_FAILURE
 	E_NOT_ENOUGH_RUN_PARAMS
+	E_BAD_COMMAND_ARGS
+	E_COMMAND_FAILURE
 )
 
 var (
@@ -37,7 +41,7 @@ var (
 // [ ] high-load testing
 // [ ] env config
 // [ ] send tg message
-// [ ] post middlewares (translate, expand, picturize, etc.)
+// [x] post middlewares (translate, expand, picturize, etc.)
 
 func main() {
 	realFeedsIO := &RealFeedsIO{}
@@ -52,9 +56,14 @@ func run(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writ
 	if len(args) < 2 {
 		log.Error("not enough params")
 		log.Info("Usage rss_reader <user_email>")
+		log.Info("Usage rss_reader <command> [args...]", "commands", commandNames())
 		return E_NOT_ENOUGH_RUN_PARAMS 
 	}
 
+	if cmd, ok := commands[args[1]]; ok {
+		return cmd(args[2:], feedsIO, feedFetcher, stdout, log)
+	}
+
 	user_id := args[1]
 	user_hash := GetSHA256(user_id)
 	log.Info("user is ready", "id", user_id, "hash", user_hash)
@@ -71,6 +80,7 @@ func run(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writ
 		log.Error(err.Error())
 		return E_READ_FEED_FILE
 	}
+	feeds.attachSettings()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -87,11 +97,45 @@ func run(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writ
 		}
 	}()
 
+	queued := queuedCounts(feeds.Items)
+	if err := updateFeeds(ctx, feedFetcher, feeds.Items, newPipeline(&feeds, userFeedsFile), log); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Info(LOG_INFO_UPDATE_CANCELLED)
+			return 0
+		} else {
+			log.Error("one or more feed updates failed", "error", err)
+			return E_CONCURRENT_FAILURE
+		}
+	} else {
+		log.Info("all feed updates completed successfully")
+	}
+
+	feeds.finishUpdate(ctx, queued, userFeedsFile, log)
+
+	log.Info(LOG_INFO_SAVING_UPDATES, "path", userFeedsFile)
+
+	err = feedsIO.SaveUpdates(feeds, userFeedsFile)
+	if err != nil {
+		log.Error(err.Error())
+		return E_UPDATE_FEED_FILE
+	}
+
+	log.Info("updates saved to", "path", userFeedsFile)
+
+	log.Info("session done")
+
+	return 0
+}
+
+// updateFeeds runs getUpdates for every feed, at most maxConcurrentFeeds at
+// a time, and passes the new items through pipe. The first failure cancels
+// the rest.
+func updateFeeds(ctx context.Context, feedFetcher FeedFetcher, items []*Feed, pipe pipeline, log *slog.Logger) error {
 	g, childCtx := errgroup.WithContext(ctx)
 
 	sem := make(chan struct{}, maxConcurrentFeeds)
 
-	for _, userFeed := range feeds.Items {
+	for _, userFeed := range items {
 
 		feed := userFeed
 
@@ -108,7 +152,11 @@ func run(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writ
 				<-sem // release "slot" after goroutine ends
 			}()
 
-			err = getUpdates(childCtx, feedFetcher, feed, log) 
+			queued := len(feed.UnprocessedItems)
+			err := getUpdates(childCtx, feedFetcher, feed, log)
+			if err == nil {
+				err = pipe.process(childCtx, feed, feed.UnprocessedItems[queued:], log)
+			}
 
 			if err != nil {
 				if errors.Is(err, context.Canceled) {
@@ -125,38 +173,14 @@ func run(args []string, feedsIO FeedsIO, feedFetcher FeedFetcher, stdout io.Writ
 
 	log.Info("waiting for all feed updates to complete...")
 
-	if err := g.Wait(); err != nil {
-		if errors.Is(err, context.Canceled) {
-			log.Info(LOG_INFO_UPDATE_CANCELLED)
-			return 0
-		} else {
-			log.Error("one or more feed updates failed", "error", err)
-			return E_CONCURRENT_FAILURE
-		}
-	} else {
-		log.Info("all feed updates completed successfully")
-	}
-
-	log.Info(LOG_INFO_SAVING_UPDATES, "path", userFeedsFile)
-
-	err = feedsIO.SaveUpdates(feeds, userFeedsFile)
-	if err != nil {
-		log.Error(err.Error())
-		return E_UPDATE_FEED_FILE
-	}
-
-	log.Info("updates saved to", "path", userFeedsFile)
-
-	log.Info("session done")
-
-	return 0
+	return g.Wait()
 }
 
 func getUpdates(ctx context.Context, feedParser FeedFetcher, userFeed *Feed, log *slog.Logger) error {
 
 	log.Info("processing feed", "url", userFeed.Url, "updated", userFeed.Updated)
 
-	remoteFeed, err := feedParser.ParseURLWithContext(userFeed.Url, ctx)
+	remoteFeed, err := fetchFeed(ctx, feedParser, userFeed, log)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Info("feed processing cancelled", "url", userFeed.Url)
@@ -164,10 +188,19 @@ func getUpdates(ctx context.Context, feedParser FeedFetcher, userFeed *Feed, log
 		return err
 	}
 
+	return applyUpdates(ctx, userFeed, remoteFeed, log)
+}
+
+// applyUpdates queues the items of remoteFeed that userFeed has not seen yet.
+// It is shared by polling (getUpdates) and pushed content (WebSub).
+func applyUpdates(ctx context.Context, userFeed *Feed, remoteFeed *gofeed.Feed, log *slog.Logger) error {
 	if remoteFeed.Updated != userFeed.Updated {
 
 		log.Info("got updates", "count", len(remoteFeed.Items))
 		userFeed.Updated = remoteFeed.Updated
+		if title := strings.TrimSpace(remoteFeed.Title); title != "" {
+			userFeed.Title = title
+		}
 		newFeeds := 0
 
 		for _, remoteItem := range remoteFeed.Items {
@@ -176,13 +209,29 @@ func getUpdates(ctx context.Context, feedParser FeedFetcher, userFeed *Feed, log
 				log.Info("context cancelled while processing items, stopping early", "url", userFeed.Url)
 				return ctx.Err()
 			default:
-				if _, exists := userFeed.UnprocessedGUID[remoteItem.GUID]; !exists {
-					log.Info("new post", "guid", remoteItem.GUID, "title", firstNRunes(remoteItem.Title, 64), "updated", remoteItem.Updated)
-					userFeed.UnprocessedGUID[remoteItem.GUID] = struct{}{}
-					userFeed.UnprocessedItems = append(userFeed.UnprocessedItems, &UnprocessedItem{
-						GUID: remoteItem.GUID,
-						URL:  remoteItem.Link,
-					})
+				id := itemID(remoteItem)
+				if !userFeed.seenItem(remoteItem, id) {
+					log.Info("new post", "guid", id, "title", firstNRunes(remoteItem.Title, 64), "updated", remoteItem.Updated)
+					userFeed.markSeen(id)
+					item := newUnprocessedItem(remoteItem)
+					if !userFeed.Languages.allows(item.Language) {
+						log.Info("post filtered by language", "guid", id, "language", item.Language)
+						continue
+					}
+					if rules := userFeed.feedRules(); len(rules) > 0 {
+						now := time.Now()
+						outcome := applyRules(rules, userFeed, item, now)
+						for _, err := range outcome.Errors {
+							log.Warn("rule failed", "url", userFeed.Url, "error", err)
+						}
+						if outcome.Drop {
+							log.Info("post dropped by rule", "guid", id, "rule", outcome.Matched[len(outcome.Matched)-1])
+							continue
+						}
+						outcome.apply(item, now)
+					}
+					alertItem(userFeed, item, log)
+					userFeed.UnprocessedItems = append(userFeed.UnprocessedItems, item)
 					newFeeds++
 				}
 			}
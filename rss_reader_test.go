@@ -238,8 +238,8 @@ func Test_getUpdates(t *testing.T) {
 		expectedUnprocessedItems := []*UnprocessedItem{
 			{GUID: "guid1", URL: "url1"},
 			{GUID: "guid2", URL: "url2"},
-			{GUID: "guid3", URL: "url3"},
-			{GUID: "guid4", URL: "url4"},
+			{GUID: "guid3", URL: "url3", Title: "New Post 1"},
+			{GUID: "guid4", URL: "url4", Title: "New Post 2"},
 		}
 		if !reflect.DeepEqual(userFeed.UnprocessedItems, expectedUnprocessedItems) {
 			t.Errorf("expected UnprocessedItems to be %+v, got %+v", expectedUnprocessedItems, userFeed.UnprocessedItems)
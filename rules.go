@@ -0,0 +1,693 @@
+package rss_reader
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	RULE_ACTION_DROP     = "drop"
+	RULE_ACTION_TAG      = "tag"
+	RULE_ACTION_PRIORITY = "priority"
+	RULE_ACTION_DELAY    = "delay"
+)
+
+var (
+	ErrRuleSyntax = errors.New("rule syntax error")
+	ErrRuleType   = errors.New("rule type error")
+	ErrRuleAction = errors.New("invalid rule action")
+)
+
+// ruleFields are the item properties a rule can look at.
+var ruleFields = []string{"age", "author", "category", "content", "feed", "guid", "language", "length", "summary", "text", "title", "url"}
+
+// compile parses the condition and checks the action once. Rules are
+// shared by concurrently updated feeds, hence the sync.Once.
+func (r *Rule) compile() error {
+	r.once.Do(func() {
+		r.err = r.validate()
+		if r.err == nil {
+			r.cond, r.err = parseRule(r.When)
+		}
+		if r.err != nil && r.Name != "" {
+			r.err = fmt.Errorf("rule %q: %w", r.Name, r.err)
+		}
+	})
+	return r.err
+}
+
+func (r *Rule) validate() error {
+	switch r.Action {
+	case RULE_ACTION_DROP:
+	case RULE_ACTION_TAG:
+		if r.Tag == "" {
+			return fmt.Errorf("%w: tag needs a tag", ErrRuleAction)
+		}
+	case RULE_ACTION_PRIORITY:
+	case RULE_ACTION_DELAY:
+		if _, err := parseDelay(r.Delay); err != nil {
+			return fmt.Errorf("%w: %v", ErrRuleAction, err)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrRuleAction, r.Action)
+	}
+	return nil
+}
+
+// ruleOutcome is what a set of rules decided for an item.
+type ruleOutcome struct {
+	Drop     bool
+	Tags     []string
+	Priority int
+	Delay    time.Duration
+	Matched  []string
+	Errors   []error
+}
+
+// applyRules evaluates rules in order against item. A drop ends the
+// evaluation; other actions add up, the highest priority and the longest
+// delay win. Rules that don't compile or fail on the item are skipped and
+// reported in Errors.
+func applyRules(rules []*Rule, userFeed *Feed, item *UnprocessedItem, now time.Time) ruleOutcome {
+	var outcome ruleOutcome
+	env := &ruleEnv{item: item, feed: userFeed, now: now, cache: map[string]any{}}
+
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			outcome.Errors = append(outcome.Errors, err)
+			continue
+		}
+		value, err := rule.cond.eval(env)
+		if err != nil {
+			outcome.Errors = append(outcome.Errors, fmt.Errorf("rule %s: %w", rule.label(i), err))
+			continue
+		}
+		if !truthy(value) {
+			continue
+		}
+
+		outcome.Matched = append(outcome.Matched, rule.label(i))
+		switch rule.Action {
+		case RULE_ACTION_DROP:
+			outcome.Drop = true
+			return outcome
+		case RULE_ACTION_TAG:
+			if !slices.Contains(outcome.Tags, rule.Tag) {
+				outcome.Tags = append(outcome.Tags, rule.Tag)
+			}
+		case RULE_ACTION_PRIORITY:
+			outcome.Priority = max(outcome.Priority, rule.Priority)
+		case RULE_ACTION_DELAY:
+			delay, _ := parseDelay(rule.Delay)
+			outcome.Delay = max(outcome.Delay, delay)
+		}
+	}
+	return outcome
+}
+
+// apply stores the outcome on the item.
+func (o ruleOutcome) apply(item *UnprocessedItem, now time.Time) {
+	for _, tag := range o.Tags {
+		if !slices.Contains(item.Tags, tag) {
+			item.Tags = append(item.Tags, tag)
+		}
+	}
+	item.Priority = max(item.Priority, o.Priority)
+	if o.Delay > 0 {
+		item.DeliverAfter = now.Add(o.Delay).UTC()
+	}
+}
+
+func (r *Rule) label(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return "#" + strconv.Itoa(i+1)
+}
+
+// feedRules are the rules for a feed: the user's first, then the feed's.
+func (f *Feed) feedRules() []*Rule {
+	var rules []*Rule
+	if f.settings != nil {
+		rules = append(rules, f.settings.Rules...)
+	}
+	return append(rules, f.Rules...)
+}
+
+// The rule language is a small expression language over item fields:
+//
+//	title contains "sponsored" or author == "Bot"
+//	category in ["sport", "weather"] and not (length > 2000)
+//	url matches "/live/" and age < 2h
+//
+// Strings compare ignoring case, "matches" takes a regular expression,
+// durations are written 90s, 15m, 2h, 3d or 1w. Operators may also be
+// written &&, ||, ! and ~.
+
+type ruleNode interface {
+	eval(env *ruleEnv) (any, error)
+}
+
+type ruleEnv struct {
+	item  *UnprocessedItem
+	feed  *Feed
+	now   time.Time
+	cache map[string]any
+}
+
+func (env *ruleEnv) field(name string) any {
+	if v, ok := env.cache[name]; ok {
+		return v
+	}
+
+	item := env.item
+	var v any
+	switch name {
+	case "title":
+		v = item.Title
+	case "summary":
+		v = htmlText(item.Description)
+	case "content":
+		v = htmlText(item.Content)
+	case "text":
+		v = strings.Join([]string{item.Title, env.field("summary").(string), env.field("content").(string)}, "\n")
+	case "url":
+		v = item.URL
+	case "guid":
+		v = item.GUID
+	case "feed":
+		v = env.feed.Url
+	case "language":
+		v = item.Language
+	case "author":
+		var names []string
+		for _, author := range item.Authors {
+			names = append(names, strings.TrimSpace(author.Name+" "+author.Email))
+		}
+		v = strings.Join(names, ", ")
+	case "category":
+		v = item.Categories
+	case "length":
+		text := env.field("content").(string)
+		if text == "" {
+			text = env.field("summary").(string)
+		}
+		v = float64(utf8.RuneCountInString(text))
+	case "age":
+		date := item.Published
+		if date.IsZero() {
+			date = item.Updated
+		}
+		if date.IsZero() {
+			v = time.Duration(0)
+		} else {
+			v = env.now.Sub(date)
+		}
+	}
+	env.cache[name] = v
+	return v
+}
+
+type literalNode struct{ value any }
+
+func (n literalNode) eval(*ruleEnv) (any, error) { return n.value, nil }
+
+type fieldNode struct{ name string }
+
+func (n fieldNode) eval(env *ruleEnv) (any, error) { return env.field(n.name), nil }
+
+type listNode struct{ items []ruleNode }
+
+func (n listNode) eval(env *ruleEnv) (any, error) {
+	list := make([]string, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: lists hold strings only", ErrRuleType)
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+type notNode struct{ x ruleNode }
+
+func (n notNode) eval(env *ruleEnv) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type logicalNode struct {
+	and  bool
+	l, r ruleNode
+}
+
+func (n logicalNode) eval(env *ruleEnv) (any, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(l) != n.and {
+		// short circuit: false and ..., true or ...
+		return !n.and, nil
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(r), nil
+}
+
+type compareNode struct {
+	op   string
+	l, r ruleNode
+	re   *regexp.Regexp
+}
+
+func (n compareNode) eval(env *ruleEnv) (any, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "contains":
+		needle, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: contains needs a string on the right", ErrRuleType)
+		}
+		switch l := l.(type) {
+		case string:
+			return strings.Contains(strings.ToLower(l), strings.ToLower(needle)), nil
+		case []string:
+			return containsFold(l, needle), nil
+		}
+	case "in":
+		list, ok := r.([]string)
+		s, isString := l.(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("%w: in needs a string and a list", ErrRuleType)
+		}
+		return containsFold(list, s), nil
+	case "matches":
+		re := n.re
+		if re == nil {
+			pattern, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: matches needs a pattern", ErrRuleType)
+			}
+			if re, err = regexp.Compile(pattern); err != nil {
+				return nil, err
+			}
+		}
+		switch l := l.(type) {
+		case string:
+			return re.MatchString(l), nil
+		case []string:
+			return slices.ContainsFunc(l, re.MatchString), nil
+		}
+	case "==", "!=":
+		equal, err := ruleEqual(l, r)
+		if err != nil {
+			return nil, err
+		}
+		return equal == (n.op == "=="), nil
+	default:
+		cmp, err := ruleCompare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		case ">=":
+			return cmp >= 0, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s on %T", ErrRuleType, n.op, l)
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(e string) bool { return strings.EqualFold(e, s) })
+}
+
+func ruleEqual(l, r any) (bool, error) {
+	switch l := l.(type) {
+	case string:
+		if r, ok := r.(string); ok {
+			return strings.EqualFold(l, r), nil
+		}
+	case float64, time.Duration:
+		cmp, err := ruleCompare(l, r)
+		return cmp == 0, err
+	case bool:
+		if r, ok := r.(bool); ok {
+			return l == r, nil
+		}
+	}
+	return false, fmt.Errorf("%w: can't compare %T and %T", ErrRuleType, l, r)
+}
+
+func ruleCompare(l, r any) (int, error) {
+	switch l := l.(type) {
+	case float64:
+		if r, ok := r.(float64); ok {
+			return compareOrdered(l, r), nil
+		}
+	case time.Duration:
+		if r, ok := r.(time.Duration); ok {
+			return compareOrdered(l, r), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: can't order %T and %T", ErrRuleType, l, r)
+}
+
+func compareOrdered[T float64 | time.Duration](l, r T) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []string:
+		return len(v) > 0
+	case float64:
+		return v != 0
+	case time.Duration:
+		return v != 0
+	}
+	return false
+}
+
+// parseRule parses a rule condition.
+func parseRule(src string) (ruleNode, error) {
+	tokens, err := lexRule(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return node, nil
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokOp
+)
+
+type ruleToken struct {
+	kind  int
+	text  string
+	value any
+	pos   int
+}
+
+var ruleOpAliases = map[string]string{"&&": "and", "||": "or", "!": "not", "~": "matches"}
+
+func lexRule(src string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			continue
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(src) && src[end] != byte(r) {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrRuleSyntax, start)
+			}
+			value := src[i+1 : end]
+			if r == '"' {
+				// single quoted strings are taken literally, handy for regexps
+				if unquoted, err := strconv.Unquote(src[start : end+1]); err == nil {
+					value = unquoted
+				}
+			}
+			tokens = append(tokens, ruleToken{kind: tokString, text: src[start : end+1], value: value, pos: start})
+			i = end + 1
+		case unicode.IsDigit(r):
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			number, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad number %q", ErrRuleSyntax, src[start:i])
+			}
+			unitStart := i
+			for i < len(src) && unicode.IsLetter(rune(src[i])) {
+				i++
+			}
+			if unit := src[unitStart:i]; unit != "" {
+				d, err := ruleDuration(number, unit)
+				if err != nil {
+					return nil, err
+				}
+				tokens = append(tokens, ruleToken{kind: tokDuration, text: src[start:i], value: d, pos: start})
+			} else {
+				tokens = append(tokens, ruleToken{kind: tokNumber, text: src[start:i], value: number, pos: start})
+			}
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(src) {
+				r, size := utf8.DecodeRuneInString(src[i:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, ruleToken{kind: tokIdent, text: strings.ToLower(src[start:i]), pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "~", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrRuleSyntax, r, start)
+			}
+			i += len(op)
+			if alias, ok := ruleOpAliases[op]; ok {
+				tokens = append(tokens, ruleToken{kind: tokIdent, text: alias, pos: start})
+			} else {
+				tokens = append(tokens, ruleToken{kind: tokOp, text: op, pos: start})
+			}
+		}
+	}
+	return append(tokens, ruleToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+func ruleDuration(n float64, unit string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"s": time.Second, "m": time.Minute, "h": time.Hour,
+		"d": 24 * time.Hour, "w": 7 * 24 * time.Hour,
+	}
+	u, ok := units[unit]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown duration unit %q", ErrRuleSyntax, unit)
+	}
+	return time.Duration(n * float64(u)), nil
+}
+
+// parseDelay reads Go durations and the day and week units of rules.
+func parseDelay(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	tokens, err := lexRule(s)
+	if err != nil || len(tokens) != 2 || tokens[0].kind != tokDuration {
+		return 0, fmt.Errorf("invalid delay %q", s)
+	}
+	return tokens[0].value.(time.Duration), nil
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) peek() ruleToken { return p.tokens[p.pos] }
+
+func (p *ruleParser) next() ruleToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *ruleParser) accept(kind int, text string) bool {
+	if tok := p.peek(); tok.kind == kind && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) errorf(tok ruleToken, format string, args ...any) error {
+	return fmt.Errorf("%w at %d: %s", ErrRuleSyntax, tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *ruleParser) or() (ruleNode, error) {
+	l, err := p.and()
+	for err == nil && p.accept(tokIdent, "or") {
+		var r ruleNode
+		if r, err = p.and(); err == nil {
+			l = logicalNode{and: false, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *ruleParser) and() (ruleNode, error) {
+	l, err := p.not()
+	for err == nil && p.accept(tokIdent, "and") {
+		var r ruleNode
+		if r, err = p.not(); err == nil {
+			l = logicalNode{and: true, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *ruleParser) not() (ruleNode, error) {
+	if p.accept(tokIdent, "not") {
+		x, err := p.not()
+		return notNode{x: x}, err
+	}
+	return p.comparison()
+}
+
+func (p *ruleParser) comparison() (ruleNode, error) {
+	l, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	op := ""
+	switch {
+	case tok.kind == tokOp && slices.Contains([]string{"==", "!=", "<", "<=", ">", ">="}, tok.text):
+		op = tok.text
+	case tok.kind == tokIdent && slices.Contains([]string{"contains", "matches", "in"}, tok.text):
+		op = tok.text
+	default:
+		return l, nil
+	}
+	p.next()
+
+	r, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	node := compareNode{op: op, l: l, r: r}
+	if lit, ok := r.(literalNode); ok && op == "matches" {
+		pattern, _ := lit.value.(string)
+		if node.re, err = regexp.Compile(pattern); err != nil {
+			return nil, p.errorf(tok, "bad pattern: %v", err)
+		}
+	}
+	return node, nil
+}
+
+func (p *ruleParser) primary() (ruleNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString, tokNumber, tokDuration:
+		return literalNode{value: tok.value}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return literalNode{value: tok.text == "true"}, nil
+		}
+		if !slices.Contains(ruleFields, tok.text) {
+			return nil, p.errorf(tok, "unknown field %q, known fields are %s", tok.text, strings.Join(ruleFields, ", "))
+		}
+		return fieldNode{name: tok.text}, nil
+	case tokOp:
+		switch tok.text {
+		case "(":
+			node, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(tokOp, ")") {
+				return nil, p.errorf(p.peek(), "expected )")
+			}
+			return node, nil
+		case "[":
+			list := listNode{}
+			for !p.accept(tokOp, "]") {
+				if len(list.items) > 0 && !p.accept(tokOp, ",") {
+					return nil, p.errorf(p.peek(), "expected , or ]")
+				}
+				item, err := p.primary()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+			}
+			return list, nil
+		}
+	case tokEOF:
+		return nil, p.errorf(tok, "unexpected end of rule")
+	}
+	return nil, p.errorf(tok, "unexpected %q", tok.text)
+}
+
+// ruleCache holds the compiled state of a Rule.
+type ruleCache struct {
+	once sync.Once
+	cond ruleNode
+	err  error
+}
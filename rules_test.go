@@ -0,0 +1,160 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func Test_parseRule(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed.xml")
+	item := &UnprocessedItem{
+		URL:         "https://example.com/live/match",
+		GUID:        "1",
+		Title:       "Sponsored: Best VPN deals",
+		Description: "<p>Short, sweet teaser</p>",
+		Authors:     []*Author{{Name: "Ad Bot"}},
+		Categories:  []string{"Sport", "Ads"},
+		Published:   now.Add(-3 * time.Hour),
+		Language:    "en",
+	}
+
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{`title contains "sponsored"`, true},
+		{`title contains "SPONSORED" and author == "ad bot"`, true},
+		{`not title contains "vpn"`, false},
+		{`category contains "sport"`, true},
+		{`"ads" in category`, true},
+		{`language in ["de", "fr"]`, false},
+		{`url matches '/live/\w+$'`, true},
+		{`url ~ "^http:"`, false},
+		{`age > 2h && age < 1d`, true},
+		{`age >= 1w`, false},
+		{`length < 100`, true},
+		{`summary == "Short, sweet teaser"`, true},
+		{`(title contains "x" || feed contains "example") and !(guid != "1")`, true},
+		{`content`, false},
+		{`text contains "teaser"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			node, err := parseRule(tt.rule)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			got, err := node.eval(&ruleEnv{item: item, feed: feed, now: now, cache: map[string]any{}})
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if truthy(got) != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{`title contains`, `titel contains "x"`, `title == "x`, `(title`, `age > 3y`, `url matches "("`, `title @ "x"`} {
+		if _, err := parseRule(bad); !errors.Is(err, ErrRuleSyntax) {
+			t.Errorf("parseRule(%q): expected a syntax error, got %v", bad, err)
+		}
+	}
+
+	node, _ := parseRule(`length > "long"`)
+	if _, err := node.eval(&ruleEnv{item: item, feed: feed, now: now, cache: map[string]any{}}); !errors.Is(err, ErrRuleType) {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
+
+func Test_getUpdatesRules(t *testing.T) {
+	mockFeedFetcher := &MockGofeedParser{
+		ParseURLWithContextFunc: func(feedURL string, ctx context.Context) (*gofeed.Feed, error) {
+			return &gofeed.Feed{Updated: "1", Items: []*gofeed.Item{
+				{GUID: "ad", Title: "Sponsored: win a phone"},
+				{GUID: "go", Title: "Go 1.23 released", Categories: []string{"golang"}},
+				{GUID: "other", Title: "Weather"},
+			}}, nil
+		},
+	}
+
+	feeds := Feeds{
+		Settings: &Settings{Rules: []*Rule{
+			{Name: "no ads", When: `title contains "sponsored"`, Action: RULE_ACTION_DROP},
+			{When: `category contains "golang"`, Action: RULE_ACTION_TAG, Tag: "go"},
+		}},
+		Items: []*Feed{newFeed(FEED_TYPE_RSS, "https://example.com/feed.xml")},
+	}
+	userFeed := feeds.Items[0]
+	userFeed.Rules = []*Rule{
+		{When: `title contains "go"`, Action: RULE_ACTION_PRIORITY, Priority: 2},
+		{When: `title contains "go"`, Action: RULE_ACTION_DELAY, Delay: "1d"},
+		{When: `broken ==`, Action: RULE_ACTION_DROP},
+	}
+	feeds.attachSettings()
+
+	before := time.Now()
+	if err := getUpdates(context.Background(), mockFeedFetcher, userFeed, setupLogger(io.Discard)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if len(userFeed.UnprocessedItems) != 2 || !userFeed.seen("ad") {
+		t.Fatalf("expected the ad to be dropped, got %+v", userFeed.UnprocessedItems)
+	}
+	goItem := userFeed.UnprocessedItems[0]
+	if len(goItem.Tags) != 1 || goItem.Tags[0] != "go" || goItem.Priority != 2 {
+		t.Errorf("expected tag and priority, got %+v", goItem)
+	}
+	if goItem.DeliverAfter.Before(before.Add(24 * time.Hour)) {
+		t.Errorf("expected delivery delayed by a day, got %v", goItem.DeliverAfter)
+	}
+	if other := userFeed.UnprocessedItems[1]; other.Tags != nil || other.Priority != 0 || !other.DeliverAfter.IsZero() {
+		t.Errorf("expected the other item untouched, got %+v", other)
+	}
+}
+
+func Test_rulesCommand(t *testing.T) {
+	mockFeedsIO := &MockFeedsIO{
+		LoadFeedsFunc: func(userFeedsFile string) (Feeds, error) {
+			feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed.xml")
+			feed.Rules = []*Rule{
+				{Name: "no ads", When: `title contains "sponsored"`, Action: RULE_ACTION_DROP},
+				{Name: "weather", When: `title == "weather"`, Action: RULE_ACTION_TAG, Tag: "local"},
+			}
+			return Feeds{Items: []*Feed{feed}}, nil
+		},
+		SaveUpdatesFunc: func(feeds Feeds, userFeedsFile string) error {
+			t.Error("rules test must not save anything")
+			return nil
+		},
+	}
+	mockFeedFetcher := &MockGofeedParser{
+		ParseURLWithContextFunc: func(feedURL string, ctx context.Context) (*gofeed.Feed, error) {
+			return &gofeed.Feed{Items: []*gofeed.Item{{GUID: "1", Title: "Sponsored post"}, {GUID: "2", Title: "Weather"}, {GUID: "3", Title: "News"}}}, nil
+		},
+	}
+
+	var stdoutBuf bytes.Buffer
+	exitCode := run([]string{"rss_reader", "rules", "test", TestAppArgs[1], "https://example.com/feed.xml"}, mockFeedsIO, mockFeedFetcher, &stdoutBuf)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d. Output: %s", exitCode, stdoutBuf.String())
+	}
+	out := stdoutBuf.String()
+	for _, want := range []string{"drop   Sponsored post [no ads]", "change Weather tags=local", "keep   News", "3 items: 1 dropped, 1 changed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	exitCode = run([]string{"rss_reader", "rules", "apply"}, mockFeedsIO, mockFeedFetcher, io.Discard)
+	if exitCode != E_BAD_COMMAND_ARGS {
+		t.Errorf("expected exit code %d for an unknown subcommand, got %d", E_BAD_COMMAND_ARGS, exitCode)
+	}
+}
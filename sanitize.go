@@ -0,0 +1,245 @@
+package rss_reader
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// sanitizePolicy is an allowlist: elements in tags keep the listed
+// attributes, elements in drop are removed with everything inside them and
+// any other element is replaced by its children.
+type sanitizePolicy struct {
+	tags    map[string][]string
+	drop    map[string]bool
+	schemes map[string]bool
+}
+
+var defaultSanitizePolicy = &sanitizePolicy{
+	tags: map[string][]string{
+		"a":          {"href", "title"},
+		"abbr":       {"title"},
+		"b":          nil,
+		"blockquote": {"cite"},
+		"br":         nil,
+		"code":       nil,
+		"dd":         nil,
+		"del":        nil,
+		"div":        nil,
+		"dl":         nil,
+		"dt":         nil,
+		"em":         nil,
+		"figcaption": nil,
+		"figure":     nil,
+		"h1":         nil,
+		"h2":         nil,
+		"h3":         nil,
+		"h4":         nil,
+		"h5":         nil,
+		"h6":         nil,
+		"hr":         nil,
+		"i":          nil,
+		"img":        {"src", "alt", "title", "width", "height"},
+		"ins":        nil,
+		"li":         nil,
+		"mark":       nil,
+		"ol":         {"start"},
+		"p":          nil,
+		"pre":        nil,
+		"q":          {"cite"},
+		"s":          nil,
+		"small":      nil,
+		"span":       nil,
+		"strike":     nil,
+		"strong":     nil,
+		"sub":        nil,
+		"sup":        nil,
+		"table":      nil,
+		"tbody":      nil,
+		"td":         {"colspan", "rowspan"},
+		"tfoot":      nil,
+		"th":         {"colspan", "rowspan"},
+		"thead":      nil,
+		"tr":         nil,
+		"u":          nil,
+		"ul":         nil,
+	},
+	drop: map[string]bool{
+		"applet": true, "audio": true, "base": true, "button": true, "canvas": true,
+		"embed": true, "form": true, "frame": true, "frameset": true, "head": true,
+		"iframe": true, "input": true, "link": true, "math": true, "meta": true,
+		"noscript": true, "object": true, "script": true, "select": true, "style": true,
+		"svg": true, "template": true, "textarea": true, "title": true, "video": true,
+	},
+	schemes: map[string]bool{"http": true, "https": true, "mailto": true},
+}
+
+// trackerHosts serve nothing but counting pixels.
+var trackerHosts = map[string]bool{
+	"ad.doubleclick.net":       true,
+	"b.scorecardresearch.com":  true,
+	"feeds.feedburner.com":     true,
+	"pi.feedsportal.com":       true,
+	"pixel.quantserve.com":     true,
+	"pixel.wp.com":             true,
+	"stats.wordpress.com":      true,
+	"www.google-analytics.com": true,
+}
+
+// sanitizeHTML makes untrusted HTML from a feed safe to store and pass on
+// with the default policy. Relative links and images are resolved against
+// base, which may be empty.
+func sanitizeHTML(fragment, base string) string {
+	return defaultSanitizePolicy.sanitize(fragment, base)
+}
+
+func (p *sanitizePolicy) sanitize(fragment, base string) string {
+	if strings.TrimSpace(fragment) == "" {
+		return fragment
+	}
+	root, err := p.sanitizeTree(fragment, base)
+	if err != nil {
+		return html.EscapeString(fragment)
+	}
+	var sb strings.Builder
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&sb, c)
+	}
+	return sb.String()
+}
+
+// sanitizeTree parses fragment into the children of a container element
+// and cleans them in place.
+func (p *sanitizePolicy) sanitizeTree(fragment, base string) (*html.Node, error) {
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), container)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		container.AppendChild(n)
+	}
+
+	baseURL, _ := url.Parse(base)
+	if baseURL != nil && !baseURL.IsAbs() {
+		baseURL = nil
+	}
+	p.clean(container, baseURL)
+	return container, nil
+}
+
+func (p *sanitizePolicy) clean(n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			tag := strings.ToLower(c.Data)
+			attrs, allowed := p.tags[tag]
+			switch {
+			case p.drop[tag]:
+				n.RemoveChild(c)
+			case !allowed:
+				p.clean(c, base)
+				unwrap(c)
+			default:
+				if tag == "img" && isTrackingPixel(c) {
+					n.RemoveChild(c)
+					break
+				}
+				p.cleanAttrs(c, attrs, base)
+				if tag == "img" && getAttr(c, "src") == "" {
+					n.RemoveChild(c)
+					break
+				}
+				p.clean(c, base)
+			}
+		default:
+			// comments, doctypes and stray documents
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+func (p *sanitizePolicy) cleanAttrs(n *html.Node, allowed []string, base *url.URL) {
+	kept := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !slices.Contains(allowed, key) {
+			continue
+		}
+		if key == "href" || key == "src" || key == "cite" {
+			safe, ok := p.safeURL(attr.Val, base)
+			if !ok {
+				continue
+			}
+			attr.Val = safe
+		}
+		attr.Key = key
+		kept = append(kept, attr)
+	}
+	n.Attr = kept
+}
+
+// safeURL resolves ref against base and accepts it only with an allowed
+// scheme. Relative references stay relative when there is no base.
+func (p *sanitizePolicy) safeURL(ref string, base *url.URL) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	u, err := url.Parse(ref)
+	if err != nil || ref == "" {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme == "" {
+		return u.String(), true
+	}
+	return u.String(), p.schemes[strings.ToLower(u.Scheme)]
+}
+
+// isTrackingPixel tells 1x1 and hidden images and images from known
+// counters.
+func isTrackingPixel(img *html.Node) bool {
+	if isHidden(img) {
+		return true
+	}
+	if tooSmall(getAttr(img, "width"), 2) || tooSmall(getAttr(img, "height"), 2) {
+		return true
+	}
+	u, err := url.Parse(getAttr(img, "src"))
+	if err != nil {
+		return false
+	}
+	return trackerHosts[strings.ToLower(u.Hostname())] || strings.Contains(u.Path, "/~r/")
+}
+
+func isHidden(n *html.Node) bool {
+	style := strings.ToLower(strings.ReplaceAll(getAttr(n, "style"), " ", ""))
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// unwrap replaces n by its children.
+func unwrap(n *html.Node) {
+	parent := n.Parent
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		n.RemoveChild(c)
+		parent.InsertBefore(c, n)
+		c = next
+	}
+	parent.RemoveChild(n)
+}
@@ -0,0 +1,38 @@
+package rss_reader
+
+import "testing"
+
+func Test_sanitizeHTML(t *testing.T) {
+	const base = "https://example.com/posts/1"
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"KeepsAllowed", `<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{"DropsScripts", `<p>Hi<script>alert(1)</script><style>p{}</style></p>`, `<p>Hi</p>`},
+		{"DropsIframes", `<iframe src="https://evil.example"></iframe><p>text</p>`, `<p>text</p>`},
+		{"DropsEventHandlers", `<a href="/next" onclick="steal()" style="color:red">next</a>`, `<a href="https://example.com/next">next</a>`},
+		{"DropsJavascriptLinks", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"UnwrapsUnknown", `<section><font color="red">red</font></section>`, `red`},
+		{"ResolvesImages", `<img src="../img/a.png" alt="A" class="wide">`, `<img src="https://example.com/img/a.png" alt="A"/>`},
+		{"DropsPixels", `<p>x<img src="/p.gif" width="1" height="1"><img src="https://feeds.feedburner.com/~r/foo/~4/bar"><img src="/a.png" style="display: none"></p>`, `<p>x</p>`},
+		{"DropsComments", `<p>a<!-- note -->b</p>`, `<p>ab</p>`},
+		{"EscapesText", `a < b & c`, `a &lt; b &amp; c`},
+		{"Empty", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeHTML(tt.in, base); got != tt.want {
+				t.Errorf("sanitizeHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sanitizeHTMLWithoutBase(t *testing.T) {
+	got := sanitizeHTML(`<a href="/relative">x</a><a href="data:text/html,hi">y</a>`, "")
+	if want := `<a href="/relative">x</a><a>y</a>`; got != want {
+		t.Errorf("sanitizeHTML() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+)
+
+var (
+	ErrScrapeNoSelectors = errors.New("scrape feed has no item selector")
+)
+
+func scrapeFeed(ctx context.Context, pageURL string, config *ScrapeConfig) (*gofeed.Feed, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	page, err := httpGet(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return scrapePage(page, config)
+}
+
+// validate compiles every selector up front: goquery silently matches
+// nothing on a broken selector, which would look like an empty page.
+func (c *ScrapeConfig) validate() error {
+	if c == nil || c.Item == "" {
+		return ErrScrapeNoSelectors
+	}
+	for _, sel := range []string{c.Item, c.Title, c.Link, c.Date, c.Summary} {
+		if sel == "" {
+			continue
+		}
+		if _, err := cascadia.Compile(sel); err != nil {
+			return fmt.Errorf("invalid selector %q: %w", sel, err)
+		}
+	}
+	return nil
+}
+
+func scrapePage(page *fetchedPage, config *ScrapeConfig) (*gofeed.Feed, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &gofeed.Feed{
+		Title:    collapseSpaces(doc.Find("title").First().Text()),
+		Link:     page.URL.String(),
+		FeedType: FEED_TYPE_SCRAPE,
+	}
+
+	var guids []string
+	doc.Find(config.Item).Each(func(_ int, s *goquery.Selection) {
+		item := scrapeItem(s, page.URL, config)
+		if item == nil {
+			return
+		}
+		feed.Items = append(feed.Items, item)
+		guids = append(guids, item.GUID)
+	})
+
+	// pages carry no "updated" stamp, so the item list itself is the version
+	feed.Updated = GetSHA256(strings.Join(guids, "\n"))
+
+	return feed, nil
+}
+
+func scrapeItem(s *goquery.Selection, base *url.URL, config *ScrapeConfig) *gofeed.Item {
+	item := &gofeed.Item{}
+
+	linkSel := s.Find("a[href]").First()
+	if config.Link != "" {
+		linkSel = s.Find(config.Link).First()
+	} else if goquery.NodeName(s) == "a" {
+		linkSel = s
+	}
+	if href, ok := linkSel.Attr("href"); ok {
+		if ref, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			item.Link = ref.String()
+		}
+	}
+
+	if config.Title != "" {
+		item.Title = collapseSpaces(s.Find(config.Title).First().Text())
+	} else {
+		item.Title = collapseSpaces(linkSel.Text())
+	}
+
+	if config.Date != "" {
+		dateSel := s.Find(config.Date).First()
+		raw, ok := dateSel.Attr("datetime")
+		if !ok {
+			raw, ok = dateSel.Attr("content")
+		}
+		if !ok {
+			raw = dateSel.Text()
+		}
+		item.Published = collapseSpaces(raw)
+		if t, ok := parseDate(item.Published); ok {
+			item.PublishedParsed = &t
+		}
+	}
+
+	if config.Summary != "" {
+		item.Description = collapseSpaces(s.Find(config.Summary).First().Text())
+	}
+
+	switch {
+	case item.Link != "":
+		item.GUID = item.Link
+	case item.Title != "":
+		item.GUID = GetSHA256(item.Title)
+	default:
+		return nil
+	}
+
+	return item
+}
@@ -0,0 +1,135 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const scrapeTestPage = `<html><head><title>News page</title></head><body>
+<div class="post">
+  <h2><a href="/news/1">First   post</a></h2>
+  <time datetime="2024-05-01T10:00:00Z">May 1</time>
+  <p class="lead">Lead of the first post</p>
+</div>
+<div class="post">
+  <h2><a href="https://other.example.com/2">Second post</a></h2>
+  <time>2 Jan 2024</time>
+</div>
+<div class="post"><span>no link, no title</span></div>
+</body></html>`
+
+func newScrapeTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, scrapeTestPage)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_scrapeFeed(t *testing.T) {
+	srv := newScrapeTestServer(t)
+
+	config := &ScrapeConfig{Item: "div.post", Title: "h2", Date: "time", Summary: "p.lead"}
+	feed, err := scrapeFeed(context.Background(), srv.URL, config)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if feed.Title != "News page" {
+		t.Errorf("expected title 'News page', got %q", feed.Title)
+	}
+	if len(feed.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(feed.Items))
+	}
+
+	first := feed.Items[0]
+	if first.Link != srv.URL+"/news/1" || first.GUID != first.Link {
+		t.Errorf("expected resolved link as guid, got link %q guid %q", first.Link, first.GUID)
+	}
+	if first.Title != "First post" {
+		t.Errorf("expected collapsed title, got %q", first.Title)
+	}
+	if first.Description != "Lead of the first post" {
+		t.Errorf("unexpected summary %q", first.Description)
+	}
+	if first.PublishedParsed == nil || !first.PublishedParsed.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected published date %v", first.PublishedParsed)
+	}
+
+	if feed.Items[1].PublishedParsed == nil {
+		t.Errorf("expected text date %q to be parsed", feed.Items[1].Published)
+	}
+
+	again, _ := scrapeFeed(context.Background(), srv.URL, config)
+	if again.Updated != feed.Updated {
+		t.Errorf("expected stable Updated for unchanged page")
+	}
+
+	t.Run("InvalidSelector", func(t *testing.T) {
+		_, err := scrapeFeed(context.Background(), srv.URL, &ScrapeConfig{Item: "div[["})
+		if err == nil {
+			t.Fatal("expected an error for invalid selector")
+		}
+	})
+
+	t.Run("NoConfig", func(t *testing.T) {
+		_, err := scrapeFeed(context.Background(), srv.URL, nil)
+		if err != ErrScrapeNoSelectors {
+			t.Fatalf("expected ErrScrapeNoSelectors, got %v", err)
+		}
+	})
+}
+
+func Test_getUpdatesScrape(t *testing.T) {
+	srv := newScrapeTestServer(t)
+
+	userFeed := &Feed{
+		Type:             FEED_TYPE_SCRAPE,
+		Url:              srv.URL,
+		UnprocessedGUID:  UnrpocessedGUIDSet{},
+		UnprocessedItems: []*UnprocessedItem{},
+		Scrape:           &ScrapeConfig{Item: "div.post"},
+	}
+
+	err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, setupLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if len(userFeed.UnprocessedItems) != 2 {
+		t.Fatalf("expected 2 new items, got %d", len(userFeed.UnprocessedItems))
+	}
+	if _, ok := userFeed.UnprocessedGUID[srv.URL+"/news/1"]; !ok {
+		t.Errorf("expected scraped link to be recorded as guid, got %v", userFeed.UnprocessedGUID)
+	}
+}
+
+func Test_testScrapeCommand(t *testing.T) {
+	srv := newScrapeTestServer(t)
+
+	var stdoutBuf bytes.Buffer
+	args := []string{"rss_reader", "test-scrape", "-item", "div.post", "-date", "time", srv.URL}
+	exitCode := run(args, &MockFeedsIO{}, &MockGofeedParser{}, &stdoutBuf)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d. Output: %s", exitCode, stdoutBuf.String())
+	}
+
+	output := stdoutBuf.String()
+	for _, want := range []string{"2 items", "1. First post", "link: " + srv.URL + "/news/1", "2024-05-01T10:00:00Z"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+
+	stdoutBuf.Reset()
+	exitCode = run([]string{"rss_reader", "test-scrape", srv.URL}, &MockFeedsIO{}, &MockGofeedParser{}, &stdoutBuf)
+	if exitCode != E_COMMAND_FAILURE {
+		t.Errorf("expected exit code %d without selectors, got %d", E_COMMAND_FAILURE, exitCode)
+	}
+}
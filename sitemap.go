@@ -0,0 +1,173 @@
+package rss_reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	defaultSitemapChildren = 10
+	defaultSitemapItems    = 500
+	// an index may point at other indexes; deeper nesting is ignored
+	maxSitemapDepth = 2
+)
+
+type sitemapDoc struct {
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapRef   `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string       `xml:"loc"`
+	LastMod string       `xml:"lastmod"`
+	News    *sitemapNews `xml:"news"`
+}
+
+type sitemapNews struct {
+	Title           string `xml:"title"`
+	PublicationDate string `xml:"publication_date"`
+}
+
+type sitemapRef struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapFeed reads a sitemap or a sitemap index and turns every <url> that
+// carries a date (lastmod or news:publication_date) into an item, newest
+// first. Only the MaxChildren most recently modified child sitemaps of an
+// index are followed.
+func sitemapFeed(ctx context.Context, feedURL string, config *SitemapConfig) (*gofeed.Feed, error) {
+	maxChildren, maxItems := defaultSitemapChildren, defaultSitemapItems
+	if config != nil && config.MaxChildren > 0 {
+		maxChildren = config.MaxChildren
+	}
+	if config != nil && config.MaxItems > 0 {
+		maxItems = config.MaxItems
+	}
+
+	var entries []sitemapEntry
+	if err := collectSitemap(ctx, feedURL, maxChildren, 0, &entries); err != nil {
+		return nil, err
+	}
+
+	feed := &gofeed.Feed{
+		Title:    feedURL,
+		Link:     feedURL,
+		FeedType: FEED_TYPE_SITEMAP,
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" || seen[loc] {
+			continue
+		}
+
+		raw, title := strings.TrimSpace(entry.LastMod), ""
+		if entry.News != nil {
+			title = collapseSpaces(entry.News.Title)
+			if date := strings.TrimSpace(entry.News.PublicationDate); date != "" {
+				raw = date
+			}
+		}
+		date, ok := parseDate(raw)
+		if !ok {
+			continue
+		}
+		seen[loc] = true
+
+		if title == "" {
+			title = loc
+		}
+		date = date.UTC()
+		feed.Items = append(feed.Items, &gofeed.Item{
+			GUID:            loc,
+			Link:            loc,
+			Title:           title,
+			Published:       raw,
+			PublishedParsed: &date,
+		})
+	}
+
+	sort.SliceStable(feed.Items, func(i, j int) bool {
+		return feed.Items[i].PublishedParsed.After(*feed.Items[j].PublishedParsed)
+	})
+	if len(feed.Items) > maxItems {
+		feed.Items = feed.Items[:maxItems]
+	}
+	feed.Updated = itemsFingerprint(feed.Items)
+
+	return feed, nil
+}
+
+func collectSitemap(ctx context.Context, feedURL string, maxChildren, depth int, entries *[]sitemapEntry) error {
+	page, err := httpGet(ctx, feedURL)
+	if err != nil {
+		return err
+	}
+
+	body, err := gunzipIfNeeded(page.Body)
+	if err != nil {
+		return err
+	}
+
+	var doc sitemapDoc
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return err
+	}
+	*entries = append(*entries, doc.URLs...)
+
+	if len(doc.Sitemaps) == 0 || depth >= maxSitemapDepth {
+		return nil
+	}
+
+	children := doc.Sitemaps
+	sort.SliceStable(children, func(i, j int) bool {
+		return sitemapTime(children[i].LastMod).After(sitemapTime(children[j].LastMod))
+	})
+	if len(children) > maxChildren {
+		children = children[:maxChildren]
+	}
+
+	for _, child := range children {
+		ref, err := page.URL.Parse(strings.TrimSpace(child.Loc))
+		if err != nil {
+			continue
+		}
+		if err := collectSitemap(ctx, ref.String(), maxChildren, depth+1, entries); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// one broken child should not hide the rest of the site
+			continue
+		}
+	}
+
+	return nil
+}
+
+func sitemapTime(s string) time.Time {
+	t, _ := parseDate(s)
+	return t
+}
+
+func gunzipIfNeeded(body []byte) ([]byte, error) {
+	if len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxResponseSize))
+}
@@ -0,0 +1,103 @@
+package rss_reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newSitemapTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sitemap_index.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>/old.xml</loc><lastmod>2020-01-01</lastmod></sitemap>
+  <sitemap><loc>/news.xml.gz</loc><lastmod>2024-03-02T10:00:00Z</lastmod></sitemap>
+  <sitemap><loc>/pages.xml</loc><lastmod>2024-03-01</lastmod></sitemap>
+</sitemapindex>`)
+	})
+	mux.HandleFunc("/news.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		io.WriteString(zw, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:news="http://www.google.com/schemas/sitemap-news/0.9">
+  <url>
+    <loc>https://example.com/news/budget</loc>
+    <news:news>
+      <news:publication><news:name>Example</news:name><news:language>en</news:language></news:publication>
+      <news:publication_date>2024-03-02T09:30:00+01:00</news:publication_date>
+      <news:title>Budget approved</news:title>
+    </news:news>
+  </url>
+  <url><loc>https://example.com/news/undated</loc></url>
+</urlset>`)
+		zw.Close()
+		w.Write(buf.Bytes())
+	})
+	mux.HandleFunc("/pages.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/about</loc><lastmod>2024-03-01</lastmod></url>
+  <url><loc>https://example.com/news/budget</loc><lastmod>2024-03-01</lastmod></url>
+</urlset>`)
+	})
+	mux.HandleFunc("/old.xml", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the oldest child sitemap should not be followed")
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_sitemapFeed(t *testing.T) {
+	srv := newSitemapTestServer(t)
+
+	feed, err := sitemapFeed(context.Background(), srv.URL+"/sitemap_index.xml", &SitemapConfig{MaxChildren: 2})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if len(feed.Items) != 2 {
+		t.Fatalf("expected 2 dated unique items, got %d", len(feed.Items))
+	}
+
+	news := feed.Items[0]
+	if news.GUID != "https://example.com/news/budget" || news.Title != "Budget approved" {
+		t.Errorf("unexpected news item %+v", news)
+	}
+	if got := news.PublishedParsed.Format("2006-01-02T15:04:05Z07:00"); got != "2024-03-02T08:30:00Z" {
+		t.Errorf("expected publication date normalized to UTC, got %s", got)
+	}
+	if feed.Items[1].GUID != "https://example.com/about" || feed.Items[1].Title != feed.Items[1].Link {
+		t.Errorf("unexpected page item %+v", feed.Items[1])
+	}
+
+	t.Run("MaxItems", func(t *testing.T) {
+		feed, err := sitemapFeed(context.Background(), srv.URL+"/sitemap_index.xml", &SitemapConfig{MaxChildren: 2, MaxItems: 1})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(feed.Items) != 1 || feed.Items[0].Title != "Budget approved" {
+			t.Errorf("expected only the newest item, got %+v", feed.Items)
+		}
+	})
+}
+
+func Test_getUpdatesSitemap(t *testing.T) {
+	srv := newSitemapTestServer(t)
+
+	userFeed := newFeed(FEED_TYPE_SITEMAP, srv.URL+"/pages.xml")
+	for range 2 {
+		if err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, setupLogger(io.Discard)); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+
+	if len(userFeed.UnprocessedItems) != 2 {
+		t.Errorf("expected 2 items queued once, got %d", len(userFeed.UnprocessedItems))
+	}
+}
@@ -0,0 +1,104 @@
+package rss_reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	FEED_TYPE_RSS     = "rss"
+	FEED_TYPE_SCRAPE  = "scrape"
+	FEED_TYPE_WATCH   = "watch"
+	FEED_TYPE_MAILDIR = "maildir"
+	FEED_TYPE_COMMAND = "command"
+	FEED_TYPE_SITEMAP = "sitemap"
+)
+
+const (
+	userAgent       = "rss_reader/1.0 (+https://github.com/rooslun/rss_reader)"
+	maxResponseSize = 10 << 20
+)
+
+var (
+	ErrUnexpectedStatus = errors.New("unexpected http status")
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+type fetchedPage struct {
+	Body        []byte
+	URL         *url.URL
+	ContentType string
+	Header      http.Header
+}
+
+// fetchFeed returns the remote state of userFeed as a gofeed.Feed, so every
+// source type goes through the same item processing in getUpdates.
+func fetchFeed(ctx context.Context, feedParser FeedFetcher, userFeed *Feed, log *slog.Logger) (*gofeed.Feed, error) {
+	switch userFeed.Type {
+	case FEED_TYPE_SCRAPE:
+		return scrapeFeed(ctx, userFeed.Url, userFeed.Scrape)
+	case FEED_TYPE_WATCH:
+		if userFeed.Watch == nil {
+			userFeed.Watch = &WatchConfig{}
+		}
+		return watchFeed(ctx, userFeed.Url, userFeed.Watch)
+	case FEED_TYPE_MAILDIR:
+		return mailFeed(userFeed.Url, userFeed.Mail)
+	case FEED_TYPE_COMMAND:
+		return commandFeed(ctx, userFeed.Url, userFeed.Command, log)
+	case FEED_TYPE_SITEMAP:
+		return sitemapFeed(ctx, userFeed.Url, userFeed.Sitemap)
+	default:
+		return feedParser.ParseURLWithContext(userFeed.Url, ctx)
+	}
+}
+
+// itemsFingerprint versions a source that has no "updated" stamp of its
+// own by its item list.
+func itemsFingerprint(items []*gofeed.Item) string {
+	guids := make([]string, 0, len(items))
+	for _, item := range items {
+		guids = append(guids, item.GUID+"\x00"+item.Link)
+	}
+	return GetSHA256(strings.Join(guids, "\n"))
+}
+
+func httpGet(ctx context.Context, rawURL string) (*fetchedPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: %s %s", ErrUnexpectedStatus, rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &fetchedPage{
+		Body:        body,
+		URL:         resp.Request.URL,
+		ContentType: resp.Header.Get("Content-Type"),
+		Header:      resp.Header,
+	}, nil
+}
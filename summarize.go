@@ -0,0 +1,289 @@
+package rss_reader
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSummarySentences = 3
+	// sentences with fewer words say too little to stand in a summary
+	minSummaryWords  = 4
+	maxSummaryInput  = 400
+	textRankDamping  = 0.85
+	textRankEpsilon  = 1e-4
+	textRankMaxSteps = 100
+)
+
+// stopwords are the words summaries ignore when comparing sentences, per
+// language. Texts in other languages are compared on all their words.
+var stopwords = buildStopwords(map[string]string{
+	"en": `a about above after again against all also am an and any are as at be because been before being
+		below between both but by can could did do does doing down during each few for from further had has
+		have having he her here hers herself him himself his how i if in into is it its itself just me more
+		most my myself no nor not now of off on once only or other our ours out over own said same she should
+		so some such than that the their theirs them then there these they this those through to too under
+		until up very was we were what when where which while who whom why will with would you your yours`,
+	"de": `aber alle allem allen aller alles als also am an ander andere anderen auch auf aus bei bin bis bist
+		da damit dann das dass dem den denn der des dich die dies diese diesem diesen dieser dir doch dort du
+		durch ein eine einem einen einer eines er es etwas euch euer für gegen hab habe haben hat hatte hier
+		hin ich ihm ihn ihnen ihr ihre im in ist jede jedem jeden jeder jetzt kann kein keine man mich mir mit
+		muss nach nicht nichts noch nun nur ob oder ohne sehr sein seine sich sie sind so solche soll sondern
+		um und uns unser unter viel vom von vor war waren was weil welche wenn wer werden wie wieder will wir
+		wird wo zu zum zur über`,
+	"fr": `a ai au aux avec avait avoir c ce cela ces cet cette comme d dans de des du elle elles en est et eu
+		été être il ils j je l la le les leur leurs lui m ma mais me même mes moi mon n ne nos notre nous on ont
+		ou où par pas plus pour qu que qui s sa sans se ses si son sont sur ta te tes toi ton tous tout très tu
+		un une vos votre vous y`,
+	"es": `a al algo como con contra cual cuando de del desde donde durante e el ella ellas ellos en entre era
+		es esa ese eso esta estaba estado este esto estos fue ha han hasta hay la las le les lo los más me mi
+		muy nada ni no nos o os otra otro para pero poco por porque que quien se ser si sin sobre su sus también
+		te tiene todo tu un una uno unos y ya yo`,
+	"it": `a ad al alla alle anche avere che chi ci come con contro cui da dal dalla degli dei del della delle
+		di dove e ed era essere fa gli ha hanno i il in io la le lei lo loro lui ma mi mio ne nei nel nella
+		noi non o per perché più quale quando quello questa questo se si sia sono su sua suo sul sulla tra tu
+		tutto un una uno vi voi è`,
+	"pt": `a ao aos as até com como da das de dela dele do dos e ela ele eles em entre era essa esse esta este
+		eu foi há isso já lhe mais mas me mesmo meu minha muito na nas nem no nos não o os ou para pela pelo
+		por qual quando que quem se sem ser seu sua são também te tem tu um uma você é`,
+	"nl": `aan al alles als bij dan dat de der deze die dit doch doen door dus een en er ge geen had heb hebben
+		heeft hem het hier hij hoe hun ik in is je kan kon maar me meer men met mij mijn moet na naar niet
+		niets nog nu of om omdat ons ook op over reeds te tegen toch toen tot u uit van veel voor want was wat
+		we wel werd wezen wie wij wil worden zal ze zei zelf zich zij zijn zo zonder zou`,
+	"sv": `alla att av blev bli de dem den denna deras dess det detta dig din dina du där efter ej eller en er
+		era ett från för ha hade han hans har henne hennes hon honom hur här i icke in inom inte jag ju kan
+		man med men mig min mina mot mycket ni nu när och om oss på samma sedan sig sin sina sitt skulle som
+		så till under upp ut vad var vara vi vid vilka vilken är över`,
+	"pl": `a aby ale bardzo bez bo być był była było były co czy dla do gdy gdzie i ich jak jako jednak jej
+		jest jego jeszcze już ku lub ma mnie może na nad nie nim o od oraz po pod przez przy się są ta tak
+		także tam te tego tej ten to tu tylko tym w we wszystko z za że`,
+	"ru": `а без бы был была были было быть в вам вас весь во вот все всё вы где да для до его ее её если
+		есть еще ещё же за и из или им их к как когда кто ли мне мы на над не него нет ни но ну о об однако
+		он она они оно от по под при с со так также такой там те то того тоже только том ты у уже чем что
+		чтобы это этот я`,
+	"uk": `а або але без би був була були було бути в вам вас ви від він вона вони все де для до є же з за
+		і із їх й к коли котрий лише мені ми на над не ні но об один по при про с та так також там те то
+		тобі тому ту ти у уже це цей ці чи що щоб як який я`,
+})
+
+func buildStopwords(lists map[string]string) map[string]map[string]bool {
+	sets := make(map[string]map[string]bool, len(lists))
+	for language, list := range lists {
+		set := map[string]bool{}
+		for _, word := range strings.Fields(list) {
+			set[word] = true
+		}
+		sets[language] = set
+	}
+	return sets
+}
+
+// summarizer is the "summarize" middleware: it picks the most central
+// sentences of an item with TextRank and stores them, in text order, as
+// the item's summary. It works offline on the expanded article, or on the
+// feed's content where there is none.
+type summarizer struct {
+	config *SummarizeConfig
+}
+
+func newSummarizer(config *SummarizeConfig) *summarizer {
+	return &summarizer{config: config}
+}
+
+func (s *summarizer) Name() string {
+	return "summarize"
+}
+
+func (s *summarizer) Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	if s.config == nil {
+		return nil
+	}
+	count := s.config.Sentences
+	if count <= 0 {
+		count = defaultSummarySentences
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// a summary is made again when the text it came from changed, say
+		// by expanding the article; one it didn't make is replaced
+		text := itemText(item)
+		source := GetSHA256(strconv.Itoa(count) + "\x00" + item.Language + "\x00" + text)
+		if item.SummarySource == source {
+			continue
+		}
+		item.Summary = summarize(text, item.Language, count)
+		item.SummarySource = source
+	}
+	return nil
+}
+
+// itemText is the longest plain text there is of an item.
+func itemText(item *UnprocessedItem) string {
+	if item.FullText != "" {
+		return item.FullText
+	}
+	if text := htmlText(item.Content); text != "" {
+		return text
+	}
+	return htmlText(item.Description)
+}
+
+// summarize returns the count best ranked sentences of text. Texts that
+// are no longer than that need no summary and get none.
+func summarize(text, language string, count int) string {
+	sentences := splitSentences(text)
+	if len(sentences) > maxSummaryInput {
+		sentences = sentences[:maxSummaryInput]
+	}
+
+	var candidates []int
+	words := make([]map[string]bool, len(sentences))
+	for i, sentence := range sentences {
+		words[i] = sentenceWords(sentence, stopwords[language])
+		if len(strings.Fields(sentence)) >= minSummaryWords {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) <= count {
+		return ""
+	}
+
+	scores := textRank(candidates, words)
+	ranked := slices.Clone(candidates)
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return scores[ranked[a]] > scores[ranked[b]]
+	})
+	picked := ranked[:count]
+	sort.Ints(picked)
+
+	summary := make([]string, len(picked))
+	for i, index := range picked {
+		summary[i] = sentences[index]
+	}
+	return strings.Join(summary, " ")
+}
+
+// textRank scores the candidate sentences by PageRank over a graph that
+// links sentences sharing words, weighted by how much they share.
+func textRank(candidates []int, words []map[string]bool) map[int]float64 {
+	n := len(candidates)
+	weights := make([][]float64, n)
+	totals := make([]float64, n)
+	for i := range n {
+		weights[i] = make([]float64, n)
+	}
+	for i := range n {
+		for j := i + 1; j < n; j++ {
+			w := sentenceSimilarity(words[candidates[i]], words[candidates[j]])
+			weights[i][j], weights[j][i] = w, w
+			totals[i] += w
+			totals[j] += w
+		}
+	}
+
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1
+	}
+	for range textRankMaxSteps {
+		next := make([]float64, n)
+		delta := 0.0
+		for i := range n {
+			sum := 0.0
+			for j := range n {
+				if weights[j][i] > 0 {
+					sum += weights[j][i] / totals[j] * scores[j]
+				}
+			}
+			next[i] = 1 - textRankDamping + textRankDamping*sum
+			delta = max(delta, math.Abs(next[i]-scores[i]))
+		}
+		scores = next
+		if delta < textRankEpsilon {
+			break
+		}
+	}
+
+	byIndex := make(map[int]float64, n)
+	for i, index := range candidates {
+		byIndex[index] = scores[i]
+	}
+	return byIndex
+}
+
+// sentenceSimilarity is the overlap measure of the TextRank paper: shared
+// words, normalized by the log of the sentence lengths so long sentences
+// do not win by length alone.
+func sentenceSimilarity(a, b map[string]bool) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	if shared == 0 {
+		return 0
+	}
+	return float64(shared) / (math.Log(float64(len(a))) + math.Log(float64(len(b))))
+}
+
+func sentenceWords(sentence string, stop map[string]bool) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(sentence), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) > 1 && !stop[word] {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// splitSentences splits text after sentence punctuation that is followed
+// by a space and a capital letter, a digit or a quote, and at line breaks.
+// That keeps most abbreviations and numbers like 3.5 in one piece.
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(line)
+		start := 0
+		for i := 0; i < len(runes); i++ {
+			if !strings.ContainsRune(".!?。！？", runes[i]) {
+				continue
+			}
+			end := i + 1
+			for end < len(runes) && strings.ContainsRune(".!?\"'”’)", runes[end]) {
+				end++
+			}
+			if end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("。！？", runes[i]) {
+				continue
+			}
+			next := end
+			for next < len(runes) && unicode.IsSpace(runes[next]) {
+				next++
+			}
+			if next < len(runes) && unicode.IsLower(runes[next]) {
+				continue
+			}
+			if sentence := strings.TrimSpace(string(runes[start:end])); sentence != "" {
+				sentences = append(sentences, collapseSpaces(sentence))
+			}
+			start, i = next, next-1
+		}
+		if sentence := strings.TrimSpace(string(runes[start:])); sentence != "" {
+			sentences = append(sentences, collapseSpaces(sentence))
+		}
+	}
+	return sentences
+}
@@ -0,0 +1,105 @@
+package rss_reader
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const summarizeTestArticle = `The city council approved the new budget for public transport on Tuesday.
+The budget adds twelve new bus lines and extends the tram network to the northern districts.
+Council members argued for hours about the cost of the tram extension.
+Local bakeries reported record sales of cinnamon buns during the festival weekend.
+Supporters say the new bus lines and the tram extension will cut travel times in half.
+The weather stayed sunny for most of the week.
+Work on the tram network and the first bus lines starts in spring, the council said.`
+
+func Test_splitSentences(t *testing.T) {
+	got := splitSentences(`Prices rose 3.5 percent, e.g. for bread. "Why?" she asked. Then it ended!
+Second line without a stop`)
+	want := []string{"Prices rose 3.5 percent, e.g. for bread.", `"Why?" she asked.`, "Then it ended!", "Second line without a stop"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitSentences() = %q, want %q", got, want)
+	}
+}
+
+func Test_summarize(t *testing.T) {
+	summary := summarize(summarizeTestArticle, "en", 2)
+	for _, offTopic := range []string{"cinnamon", "sunny"} {
+		if strings.Contains(summary, offTopic) {
+			t.Errorf("expected the off-topic sentence about %s to be left out: %q", offTopic, summary)
+		}
+	}
+	if sentences := splitSentences(summary); len(sentences) != 2 {
+		t.Fatalf("expected 2 sentences, got %q", sentences)
+	}
+	if strings.Index(summarizeTestArticle, splitSentences(summary)[0]) > strings.Index(summarizeTestArticle, splitSentences(summary)[1]) {
+		t.Errorf("expected the sentences in text order: %q", summary)
+	}
+
+	if got := summarize("Too short to summarize. Really.", "en", 2); got != "" {
+		t.Errorf("expected no summary of a short text, got %q", got)
+	}
+}
+
+func Test_summarizer(t *testing.T) {
+	item := &UnprocessedItem{GUID: "1", Content: "<p>" + strings.ReplaceAll(summarizeTestArticle, "\n", "</p><p>") + "</p>", Language: "en"}
+	teaser := &UnprocessedItem{GUID: "2", Summary: "Read all about it.", FullText: summarizeTestArticle}
+
+	if err := newSummarizer(nil).Process(context.Background(), nil, []*UnprocessedItem{item}); err != nil || item.Summary != "" {
+		t.Fatalf("expected nothing to happen without config")
+	}
+	summarizer := newSummarizer(&SummarizeConfig{Sentences: 1})
+	if err := summarizer.Process(context.Background(), nil, []*UnprocessedItem{item, teaser}); err != nil {
+		t.Fatal(err)
+	}
+	if len(splitSentences(item.Summary)) != 1 {
+		t.Errorf("expected a one sentence summary, got %q", item.Summary)
+	}
+	if teaser.Summary == "Read all about it." || !strings.Contains(summarizeTestArticle, teaser.Summary) {
+		t.Errorf("expected a summary the summarizer didn't make to be replaced, got %q", teaser.Summary)
+	}
+
+	// the same text keeps its summary, an expanded article gets a new one
+	item.Summary = "edited"
+	if err := summarizer.Process(context.Background(), nil, []*UnprocessedItem{item}); err != nil || item.Summary != "edited" {
+		t.Errorf("expected the summary of an unchanged text to stay, got %q", item.Summary)
+	}
+	item.FullText = summarizeTestArticle + "\nThe tram network will get new stops for the bus lines as well."
+	if err := summarizer.Process(context.Background(), nil, []*UnprocessedItem{item}); err != nil || item.Summary == "edited" {
+		t.Errorf("expected a summary of the expanded article, got %q", item.Summary)
+	}
+}
+
+func Test_summarizerHTML(t *testing.T) {
+	content := `<figure><img src="/bus.jpg" alt=""><figcaption>A new bus at the depot</figcaption></figure>
+<p>The city council approved the new budget for public transport on Tuesday. It passed with a narrow majority.</p>
+<h2>Twelve new lines</h2>
+<p>The budget adds twelve new bus lines and extends the tram network to the northern districts.
+Council members argued for hours about the cost of the tram extension.</p>
+<blockquote><p>Supporters say the new bus lines and the tram extension will cut travel times in half.</p></blockquote>
+<ul><li>Local bakeries reported record sales</li><li>The weather stayed sunny</li></ul>
+<p>Work on the tram network and the first bus lines starts in spring, the council said.</p>
+<p><a href="/subscribe">Subscribe to our newsletter</a></p>`
+	item := &UnprocessedItem{GUID: "1", Description: "Council approves budget", Content: content, Summary: "Council approves budget", Language: "en"}
+
+	if err := newSummarizer(&SummarizeConfig{Sentences: 2}).Process(context.Background(), nil, []*UnprocessedItem{item}); err != nil {
+		t.Fatal(err)
+	}
+	sentences := splitSentences(item.Summary)
+	if len(sentences) != 2 {
+		t.Fatalf("expected 2 sentences, got %q", item.Summary)
+	}
+	text := htmlText(content)
+	for _, sentence := range sentences {
+		if !strings.Contains(text, sentence) {
+			t.Errorf("expected a sentence of the article, got %q", sentence)
+		}
+		for _, block := range []string{"Twelve new lines", "A new bus at the depot", "Subscribe", "bakeries"} {
+			if strings.Contains(sentence, block) {
+				t.Errorf("expected no sentence across or from the %q block, got %q", block, sentence)
+			}
+		}
+	}
+}
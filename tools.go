@@ -6,6 +6,10 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -40,3 +44,81 @@ func deepCopy(originalMap UnrpocessedGUIDSet) UnrpocessedGUIDSet {
 	}
 	return deepCopyMap
 }
+
+var dateLayouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.ANSIC,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// parseDate tries the date formats commonly found on web pages and feeds.
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// normalizedDate returns parsed, or raw parsed by parseDate when the feed
+// parser could not make sense of it, in UTC.
+func normalizedDate(parsed *time.Time, raw string) time.Time {
+	if parsed != nil {
+		return parsed.UTC()
+	}
+	if t, ok := parseDate(raw); ok {
+		return t.UTC()
+	}
+	return time.Time{}
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseDuration reads durations like "3723", "62:03" or "1:02:03" into
+// seconds. It returns 0 for anything else.
+func parseDuration(s string) int {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	total := 0
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return total
+}
@@ -0,0 +1,215 @@
+package rss_reader
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultKeywordCount = 5
+	minKeywordRunes     = 3
+	// past this many items the statistics are halved, so they follow what
+	// the user reads now
+	maxTermDocuments = 5000
+)
+
+const NO_TOPIC = "(no topic)"
+
+// topicTagger is the "topics" middleware: it extracts the keywords of new
+// items by TF-IDF against all items the user got before and tags them
+// with the topics of the user's dictionaries they mention.
+type topicTagger struct {
+	config *TopicsConfig
+	// feeds holds the term statistics, which feeds updated at the same
+	// time share
+	feeds *Feeds
+	mu    sync.Mutex
+}
+
+func newTopicTagger(feeds *Feeds, config *TopicsConfig) *topicTagger {
+	return &topicTagger{config: config, feeds: feeds}
+}
+
+func (t *topicTagger) Name() string {
+	return "topics"
+}
+
+func (t *topicTagger) Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	if t.config == nil {
+		return nil
+	}
+	count := t.config.Keywords
+	if count <= 0 {
+		count = defaultKeywordCount
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if item.Keywords != nil || item.Topics != nil {
+			continue
+		}
+		title, text := topicWords(item.Title), topicWords(itemText(item))
+
+		t.mu.Lock()
+		if t.feeds.Terms == nil {
+			t.feeds.Terms = &TermStats{}
+		}
+		item.Keywords = t.feeds.Terms.keywords(title, text, stopwords[item.Language], count)
+		t.mu.Unlock()
+
+		item.Topics = matchTopics(t.config.Dictionaries, append(title, text...))
+	}
+	return nil
+}
+
+// keywords adds a document of title and text words to the statistics and
+// returns its count words with the highest TF-IDF. Title words count
+// twice.
+func (s *TermStats) keywords(title, text []string, stop map[string]bool, count int) []string {
+	counts := map[string]int{}
+	total := 0
+	for i, words := range [][]string{title, text} {
+		for _, word := range words {
+			if !isKeyword(word, stop) {
+				continue
+			}
+			weight := 1
+			if i == 0 {
+				weight = 2
+			}
+			counts[word] += weight
+			total += weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	s.add(counts)
+
+	type scored struct {
+		word  string
+		score float64
+	}
+	ranked := make([]scored, 0, len(counts))
+	for word, n := range counts {
+		idf := math.Log(float64(s.Documents+1)/float64(s.Frequencies[word]+1)) + 1
+		ranked = append(ranked, scored{word, float64(n) / float64(total) * idf})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].word < ranked[j].word
+	})
+
+	keywords := make([]string, 0, count)
+	for _, r := range ranked[:min(count, len(ranked))] {
+		keywords = append(keywords, r.word)
+	}
+	return keywords
+}
+
+func (s *TermStats) add(words map[string]int) {
+	if s.Frequencies == nil {
+		s.Frequencies = map[string]int{}
+	}
+	s.Documents++
+	for word := range words {
+		s.Frequencies[word]++
+	}
+
+	if s.Documents > maxTermDocuments {
+		s.Documents /= 2
+		for word, n := range s.Frequencies {
+			if n /= 2; n == 0 {
+				delete(s.Frequencies, word)
+			} else {
+				s.Frequencies[word] = n
+			}
+		}
+	}
+}
+
+func isKeyword(word string, stop map[string]bool) bool {
+	if utf8.RuneCountInString(word) < minKeywordRunes || stop[word] {
+		return false
+	}
+	return strings.IndexFunc(word, unicode.IsLetter) >= 0
+}
+
+// topicWords splits text into lower case words, in order.
+func topicWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchTopics returns the sorted topics of dictionaries with a term among
+// words.
+func matchTopics(dictionaries map[string][]string, words []string) []string {
+	var topics []string
+	for topic, terms := range dictionaries {
+		for _, term := range terms {
+			if containsTerm(words, term) {
+				topics = append(topics, topic)
+				break
+			}
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func containsTerm(words []string, term string) bool {
+	prefix := strings.HasSuffix(term, "*")
+	phrase := topicWords(strings.TrimSuffix(term, "*"))
+	if len(phrase) == 0 {
+		return false
+	}
+	last := len(phrase) - 1
+
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j, word := range phrase {
+			got := words[i+j]
+			if got != word && !(prefix && j == last && strings.HasPrefix(got, word)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingByTopic groups the queued items of all feeds by topic. Items
+// without one are under NO_TOPIC.
+func (f *Feeds) pendingByTopic() map[string][]pendingItem {
+	byTopic := map[string][]pendingItem{}
+	for _, feed := range f.Items {
+		for _, item := range feed.UnprocessedItems {
+			topics := item.Topics
+			if len(topics) == 0 {
+				topics = []string{NO_TOPIC}
+			}
+			for _, topic := range topics {
+				byTopic[topic] = append(byTopic[topic], pendingItem{feed, item})
+			}
+		}
+	}
+	return byTopic
+}
+
+type pendingItem struct {
+	feed *Feed
+	item *UnprocessedItem
+}
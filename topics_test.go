@@ -0,0 +1,102 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_topicTagger(t *testing.T) {
+	feeds := &Feeds{}
+	config := &TopicsConfig{
+		Keywords: 3,
+		Dictionaries: map[string][]string{
+			"space":    {"rocket", "space station"},
+			"politics": {"elect*", "parliament"},
+			"sports":   {"football"},
+		},
+	}
+	tagger := newTopicTagger(feeds, config)
+
+	// the corpus makes "today" common, so it is no keyword below
+	var background []*UnprocessedItem
+	for _, title := range []string{"Markets today", "Weather today", "Traffic today"} {
+		background = append(background, &UnprocessedItem{Title: title, Language: "en"})
+	}
+	item := &UnprocessedItem{
+		Title:       "Rocket docks at the space station today",
+		Description: "<p>The cargo rocket reached the station after a two day flight. Rocket engineers were pleased.</p>",
+		Language:    "en",
+	}
+	voting := &UnprocessedItem{Title: "Elections called for parliament", Language: "en"}
+
+	if err := tagger.Process(context.Background(), nil, append(background, item, voting)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"rocket", "station", "docks"}; !reflect.DeepEqual(item.Keywords, want) {
+		t.Errorf("expected keywords %v, got %v", want, item.Keywords)
+	}
+	if want := []string{"space"}; !reflect.DeepEqual(item.Topics, want) {
+		t.Errorf("expected topics %v, got %v", want, item.Topics)
+	}
+	if want := []string{"politics"}; !reflect.DeepEqual(voting.Topics, want) {
+		t.Errorf("expected topics %v, got %v", want, voting.Topics)
+	}
+	if feeds.Terms == nil || feeds.Terms.Documents != 5 || feeds.Terms.Frequencies["today"] != 4 {
+		t.Errorf("unexpected term statistics %+v", feeds.Terms)
+	}
+}
+
+func Test_TermStatsDecay(t *testing.T) {
+	stats := &TermStats{Documents: maxTermDocuments, Frequencies: map[string]int{"rare": 1, "common": 4000}}
+	stats.add(map[string]int{"common": 1})
+	if stats.Documents != (maxTermDocuments+1)/2 || stats.Frequencies["common"] != 2000 {
+		t.Errorf("expected the statistics to be halved, got %d documents, %v", stats.Documents, stats.Frequencies)
+	}
+	if _, ok := stats.Frequencies["rare"]; ok {
+		t.Errorf("expected words that drop to zero to be forgotten")
+	}
+}
+
+func Test_pendingCommand(t *testing.T) {
+	mockFeedsIO := &MockFeedsIO{
+		LoadFeedsFunc: func(userFeedsFile string) (Feeds, error) {
+			a := newFeed(FEED_TYPE_RSS, "https://a.example.com/feed")
+			a.UnprocessedItems = []*UnprocessedItem{
+				{URL: "https://a.example.com/1", Title: "Launch", Topics: []string{"space"}},
+				{URL: "https://a.example.com/2", Title: "Misc"},
+			}
+			b := newFeed(FEED_TYPE_RSS, "https://b.example.com/feed")
+			b.UnprocessedItems = []*UnprocessedItem{
+				{URL: "https://b.example.com/1", Title: "Vote on moon base", Topics: []string{"politics", "space"}},
+			}
+			return Feeds{Items: []*Feed{a, b}}, nil
+		},
+	}
+
+	var stdoutBuf bytes.Buffer
+	if code := run([]string{"rss_reader", "pending", TestAppArgs[1]}, mockFeedsIO, &MockGofeedParser{}, &stdoutBuf); code != 0 {
+		t.Fatalf("expected exit code 0, got %d. Output: %s", code, stdoutBuf.String())
+	}
+	want := `politics (1)
+  Vote on moon base <https://b.example.com/1> from https://b.example.com/feed
+space (2)
+  Launch <https://a.example.com/1> from https://a.example.com/feed
+  Vote on moon base <https://b.example.com/1> from https://b.example.com/feed
+(no topic) (1)
+  Misc <https://a.example.com/2> from https://a.example.com/feed
+`
+	if got := stdoutBuf.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("unexpected output:\n%s\nwant\n%s", got, want)
+	}
+
+	stdoutBuf.Reset()
+	if code := run([]string{"rss_reader", "pending", "-topic", "politics", TestAppArgs[1]}, mockFeedsIO, &MockGofeedParser{}, &stdoutBuf); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if got := stdoutBuf.String(); strings.Contains(got, "Launch") || !strings.Contains(got, "politics (1)") {
+		t.Errorf("expected only politics, got:\n%s", got)
+	}
+}
@@ -0,0 +1,241 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	TRANSLATE_BACKEND_LIBRETRANSLATE = "libretranslate"
+	TRANSLATE_BACKEND_DICTIONARY     = "dictionary"
+)
+
+var (
+	ErrUnknownTranslateBackend = errors.New("unknown translation backend")
+	ErrLanguageUndetected      = errors.New("language not detected")
+)
+
+// Translator is a translation backend. Translate translates every text
+// from source to target, keeping the order.
+type Translator interface {
+	Detect(ctx context.Context, text string) (string, error)
+	Translate(ctx context.Context, texts []string, source, target string) ([]string, error)
+}
+
+func newTranslator(config *TranslateConfig) (Translator, error) {
+	switch config.Backend {
+	case TRANSLATE_BACKEND_LIBRETRANSLATE, "":
+		return &libreTranslator{url: strings.TrimRight(config.URL, "/"), apiKey: config.APIKey}, nil
+	case TRANSLATE_BACKEND_DICTIONARY:
+		return &dictionaryTranslator{language: config.Source, words: config.Dictionary}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTranslateBackend, config.Backend)
+	}
+}
+
+// translateMiddleware is the "translate" middleware: it translates title
+// and summary of new items into the user's target language. The originals
+// stay on the item, the translation is stored next to them.
+type translateMiddleware struct {
+	config     *TranslateConfig
+	translator Translator
+	err        error
+}
+
+func newTranslateMiddleware(config *TranslateConfig) *translateMiddleware {
+	m := &translateMiddleware{config: config}
+	if config != nil {
+		m.translator, m.err = newTranslator(config)
+	}
+	return m
+}
+
+func (m *translateMiddleware) Name() string {
+	return "translate"
+}
+
+func (m *translateMiddleware) Process(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	if m.config == nil || m.config.Target == "" {
+		return nil
+	}
+	if m.err != nil {
+		return m.err
+	}
+
+	var errs []error
+	for _, item := range items {
+		if item.Translation != nil {
+			continue
+		}
+		if err := m.translate(ctx, item); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", item.GUID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *translateMiddleware) translate(ctx context.Context, item *UnprocessedItem) error {
+	summary := htmlText(item.Description)
+	if item.Title == "" && summary == "" {
+		return nil
+	}
+
+	source := item.Language
+	if source == "" {
+		detected, err := m.translator.Detect(ctx, item.Title+"\n"+summary)
+		if err != nil {
+			return err
+		}
+		source = detected
+	}
+	if source == "" {
+		return ErrLanguageUndetected
+	}
+	if source == m.config.Target {
+		return nil
+	}
+
+	translated, err := m.translator.Translate(ctx, []string{item.Title, summary}, source, m.config.Target)
+	if err != nil {
+		return err
+	}
+	if len(translated) != 2 {
+		return fmt.Errorf("expected 2 translations, got %d", len(translated))
+	}
+
+	item.Translation = &Translation{
+		Source:      source,
+		Language:    m.config.Target,
+		Title:       translated[0],
+		Description: translated[1],
+	}
+	return nil
+}
+
+// htmlText is the plain text of an HTML fragment.
+func htmlText(fragment string) string {
+	if fragment == "" {
+		return ""
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return collapseSpaces(fragment)
+	}
+	return normalizedText(doc.Selection)
+}
+
+// libreTranslator talks to a LibreTranslate compatible server.
+type libreTranslator struct {
+	url    string
+	apiKey string
+}
+
+type libreDetection struct {
+	Language   string  `json:"language"`
+	Confidence float64 `json:"confidence"`
+}
+
+type libreTranslation struct {
+	TranslatedText []string `json:"translatedText"`
+	Error          string   `json:"error"`
+}
+
+func (l *libreTranslator) Detect(ctx context.Context, text string) (string, error) {
+	var detections []libreDetection
+	if err := l.post(ctx, "/detect", map[string]any{"q": text}, &detections); err != nil {
+		return "", err
+	}
+	best := libreDetection{}
+	for _, d := range detections {
+		if d.Confidence > best.Confidence || best.Language == "" {
+			best = d
+		}
+	}
+	return best.Language, nil
+}
+
+func (l *libreTranslator) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	var result libreTranslation
+	request := map[string]any{"q": texts, "source": source, "target": target, "format": "text"}
+	if err := l.post(ctx, "/translate", request, &result); err != nil {
+		return nil, err
+	}
+	return result.TranslatedText, nil
+}
+
+func (l *libreTranslator) post(ctx context.Context, path string, request map[string]any, response any) error {
+	if l.apiKey != "" {
+		request["api_key"] = l.apiKey
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &failure)
+		return fmt.Errorf("%w: %s %s %s", ErrUnexpectedStatus, l.url+path, resp.Status, failure.Error)
+	}
+	return json.Unmarshal(data, response)
+}
+
+// dictionaryTranslator translates word by word from a fixed dictionary and
+// claims every text is in language. With no words it changes nothing; it
+// stands in for a real backend in tests and offline setups.
+type dictionaryTranslator struct {
+	language string
+	words    map[string]string
+}
+
+func (d *dictionaryTranslator) Detect(ctx context.Context, text string) (string, error) {
+	return d.language, nil
+}
+
+func (d *dictionaryTranslator) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	translated := make([]string, len(texts))
+	for i, text := range texts {
+		if phrase, ok := d.words[strings.ToLower(text)]; ok {
+			translated[i] = phrase
+			continue
+		}
+		words := strings.Fields(text)
+		for j, word := range words {
+			if t, ok := d.words[strings.ToLower(word)]; ok {
+				words[j] = t
+			}
+		}
+		translated[i] = strings.Join(words, " ")
+	}
+	return translated, nil
+}
@@ -0,0 +1,106 @@
+package rss_reader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newLibreTranslateStandIn answers like LibreTranslate: everything with
+// "der" in it is German, and translation upper-cases the text.
+func newLibreTranslateStandIn(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /detect", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Q      string `json:"q"`
+			APIKey string `json:"api_key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.APIKey != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid API key"})
+			return
+		}
+		lang := "en"
+		if strings.Contains(req.Q, "der") {
+			lang = "de"
+		}
+		json.NewEncoder(w).Encode([]libreDetection{{Language: "fr", Confidence: 10}, {Language: lang, Confidence: 90}})
+	})
+	mux.HandleFunc("POST /translate", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Q      []string `json:"q"`
+			Source string   `json:"source"`
+			Target string   `json:"target"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		out := make([]string, len(req.Q))
+		for i, q := range req.Q {
+			out[i] = req.Source + ">" + req.Target + ":" + strings.ToUpper(q)
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"translatedText": out})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_translateMiddleware(t *testing.T) {
+	srv := newLibreTranslateStandIn(t)
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed.xml")
+
+	t.Run("LibreTranslate", func(t *testing.T) {
+		m := newTranslateMiddleware(&TranslateConfig{Target: "en", URL: srv.URL + "/", APIKey: "secret"})
+		german := &UnprocessedItem{GUID: "1", Title: "Nachrichten der Woche", Description: "<p>Alles <b>neu</b></p>"}
+		english := &UnprocessedItem{GUID: "2", Title: "News of the week"}
+
+		if err := m.Process(context.Background(), feed, []*UnprocessedItem{german, english}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		want := &Translation{Source: "de", Language: "en", Title: "de>en:NACHRICHTEN DER WOCHE", Description: "de>en:ALLES NEU"}
+		if got := german.Translation; got == nil || *got != *want {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+		if german.Title != "Nachrichten der Woche" {
+			t.Errorf("expected the original title to be kept, got %q", german.Title)
+		}
+		if english.Translation != nil {
+			t.Errorf("expected items in the target language to be left alone")
+		}
+	})
+
+	t.Run("BackendError", func(t *testing.T) {
+		m := newTranslateMiddleware(&TranslateConfig{Target: "en", URL: srv.URL})
+		err := m.Process(context.Background(), feed, []*UnprocessedItem{{GUID: "1", Title: "der"}})
+		if err == nil || !strings.Contains(err.Error(), "Invalid API key") {
+			t.Errorf("expected the backend error, got %v", err)
+		}
+	})
+
+	t.Run("Dictionary", func(t *testing.T) {
+		m := newTranslateMiddleware(&TranslateConfig{
+			Target:     "en",
+			Backend:    TRANSLATE_BACKEND_DICTIONARY,
+			Source:     "es",
+			Dictionary: map[string]string{"hola": "hello", "mundo": "world"},
+		})
+		item := &UnprocessedItem{GUID: "1", Title: "Hola mundo", Language: "es"}
+		if err := m.Process(context.Background(), feed, []*UnprocessedItem{item}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if item.Translation == nil || item.Translation.Title != "hello world" {
+			t.Errorf("unexpected translation %+v", item.Translation)
+		}
+	})
+
+	t.Run("UnknownBackend", func(t *testing.T) {
+		m := newTranslateMiddleware(&TranslateConfig{Target: "en", Backend: "babelfish"})
+		if err := m.Process(context.Background(), feed, []*UnprocessedItem{{Title: "x"}}); err == nil {
+			t.Errorf("expected an error for an unknown backend")
+		}
+	})
+}
@@ -2,13 +2,45 @@ package rss_reader
 
 import (
 	"context"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
 )
 
 type Feeds struct {
-	Version string  `json:"version"`
-	Items   []*Feed `json:"items"`
+	Version  string    `json:"version"`
+	Settings *Settings `json:"settings,omitempty"`
+	Items    []*Feed   `json:"items"`
+	// Stories are the recently queued stories, for cross-feed dedupe
+	Stories []*Story `json:"stories,omitempty"`
+	// Terms are the word statistics keywords are weighed against
+	Terms *TermStats `json:"terms,omitempty"`
+	// LastDigest is when the last email digest went out
+	LastDigest time.Time `json:"last_digest,omitzero"`
+}
+
+// Settings are the user-wide options kept in the user's feeds file.
+type Settings struct {
+	Media     *MediaConfig     `json:"media,omitempty"`
+	Picturize *PicturizeConfig `json:"picturize,omitempty"`
+	Translate *TranslateConfig `json:"translate,omitempty"`
+	Rules     []*Rule          `json:"rules,omitempty"`
+	Dedupe    *DedupeConfig    `json:"dedupe,omitempty"`
+	Canonical *CanonicalConfig `json:"canonical,omitempty"`
+	Summarize *SummarizeConfig `json:"summarize,omitempty"`
+	Topics    *TopicsConfig    `json:"topics,omitempty"`
+	// Watchlists alert the user to new items at once, through Alerts
+	Watchlists []*Watchlist  `json:"watchlists,omitempty"`
+	Alerts     *AlertSink    `json:"alerts,omitempty"`
+	Digest     *DigestConfig `json:"digest,omitempty"`
+	// Webhooks and chats get every new item as it is queued
+	Webhooks []*WebhookConfig `json:"webhooks,omitempty"`
+	Slack    []*SlackConfig   `json:"slack,omitempty"`
+	Discord  []*DiscordConfig `json:"discord,omitempty"`
+	Matrix   []*MatrixConfig  `json:"matrix,omitempty"`
 }
 
 type UnrpocessedGUIDSet map[string]struct{}
@@ -17,16 +49,274 @@ type Feed struct {
 	Type             string             `json:"type"`
 	Hash             string             `json:"hash"`
 	Url              string             `json:"url"`
+	Title            string             `json:"title,omitempty"`
 	Updated          string             `json:"updated"`
 	UnprocessedGUID  UnrpocessedGUIDSet `json:"unprocessed_set"`
 	UnprocessedItems []*UnprocessedItem `json:"unprocessed_items"`
+	Scrape           *ScrapeConfig      `json:"scrape,omitempty"`
+	Watch            *WatchConfig       `json:"watch,omitempty"`
+	Mail             *MailConfig        `json:"mail,omitempty"`
+	Command          *CommandConfig     `json:"command,omitempty"`
+	Sitemap          *SitemapConfig     `json:"sitemap,omitempty"`
+	WebSub           *WebSubState       `json:"websub,omitempty"`
+	Expand           *ExpandConfig      `json:"expand,omitempty"`
+	Languages        *LanguageFilter    `json:"languages,omitempty"`
+	Rules            []*Rule            `json:"rules,omitempty"`
+
+	// settings are the owner's Feeds.Settings, see attachSettings
+	settings *Settings
+}
+
+// attachSettings makes the user-wide settings reachable from every feed,
+// for the item processing that depends on them.
+func (f *Feeds) attachSettings() {
+	for _, feed := range f.Items {
+		feed.settings = f.Settings
+	}
+}
+
+func newFeed(feedType, url string) *Feed {
+	return &Feed{
+		Type:             feedType,
+		Hash:             GetSHA256(url),
+		Url:              url,
+		UnprocessedGUID:  UnrpocessedGUIDSet{},
+		UnprocessedItems: []*UnprocessedItem{},
+	}
+}
+
+func (f *Feed) seen(guid string) bool {
+	_, exists := f.UnprocessedGUID[guid]
+	return exists
 }
 
+// seenItem tells whether remoteItem, known as id, was seen before. Older
+// versions stored its GUID untrimmed, or canonicalized when it was a link.
+func (f *Feed) seenItem(remoteItem *gofeed.Item, id string) bool {
+	return f.seen(id) || f.seen(remoteItem.GUID) || f.seen(canonicalURL(remoteItem.GUID))
+}
+
+func (f *Feed) markSeen(guid string) {
+	if f.UnprocessedGUID == nil {
+		f.UnprocessedGUID = UnrpocessedGUIDSet{}
+	}
+	f.UnprocessedGUID[guid] = struct{}{}
+}
+
+// findFeed looks a feed up by URL or hash. An exact match wins over a
+// canonical one, so feeds that canonicalize alike stay addressable.
+func (f *Feeds) findFeed(url string) *Feed {
+	for _, feed := range f.Items {
+		if feed.Url == url || feed.Hash == url {
+			return feed
+		}
+	}
+	for _, feed := range f.Items {
+		if canonicalURL(feed.Url) == canonicalURL(url) {
+			return feed
+		}
+	}
+	return nil
+}
+
+// ScrapeConfig describes how to cut items out of an HTML page for feeds of
+// type "scrape". Selectors inside an item are relative to the item container.
+type ScrapeConfig struct {
+	Item    string `json:"item"`
+	Title   string `json:"title,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Date    string `json:"date,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// UnprocessedItem is an item waiting for delivery. Only URL and GUID were
+// stored by older versions, everything else may be empty. Dates are in UTC.
 type UnprocessedItem struct {
-	URL  string `json:"url"`
+	URL         string         `json:"url"`
+	GUID        string         `json:"guid"`
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Content     string         `json:"content,omitempty"`
+	Authors     []*Author      `json:"authors,omitempty"`
+	Published   time.Time      `json:"published,omitzero"`
+	Updated     time.Time      `json:"updated,omitzero"`
+	Categories  []string       `json:"categories,omitempty"`
+	Image       string         `json:"image,omitempty"`
+	Enclosures  []*Enclosure   `json:"enclosures,omitempty"`
+	ITunes      *ITunesInfo    `json:"itunes,omitempty"`
+	Extensions  ext.Extensions `json:"extensions,omitempty"`
+	// FullHTML and FullText are the article behind URL, see ExpandConfig
+	FullHTML  string     `json:"full_html,omitempty"`
+	FullText  string     `json:"full_text,omitempty"`
+	LeadImage *LeadImage `json:"lead_image,omitempty"`
+	// Summary are the key sentences of the item, see SummarizeConfig, and
+	// SummarySource identifies the text and settings they were picked from
+	Summary       string `json:"summary,omitempty"`
+	SummarySource string `json:"summary_source,omitempty"`
+	// Keywords and Topics are set by topic tagging, see TopicsConfig
+	Keywords []string `json:"keywords,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
+	// Language is the detected language of the item
+	Language    string       `json:"language,omitempty"`
+	Translation *Translation `json:"translation,omitempty"`
+	// Tags, Priority and DeliverAfter are set by rules
+	Tags         []string  `json:"tags,omitempty"`
+	Priority     int       `json:"priority,omitempty"`
+	DeliverAfter time.Time `json:"deliver_after,omitzero"`
+	// AlsoSeenIn are the duplicates of this item dropped from other feeds
+	AlsoSeenIn []*StoryRef `json:"also_seen_in,omitempty"`
+	// Alerts are the watchlist alerts of the item not sent yet
+	Alerts []*Alert `json:"alerts,omitempty"`
+	// Deliveries is how far the item got with each consumer, by consumer ID
+	Deliveries map[string]*Delivery `json:"deliveries,omitempty"`
+}
+
+// Delivery is the state of an item with one consumer, one of the
+// DELIVERY_* states. Failed deliveries are tried again at NextRetry.
+// Batch is the key of the message the item last went out in; a retry
+// sends the same batch again, under the same key.
+type Delivery struct {
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRetry time.Time `json:"next_retry,omitzero"`
+	Updated   time.Time `json:"updated,omitzero"`
+	Batch     string    `json:"batch,omitempty"`
+}
+
+type StoryRef struct {
+	Feed string `json:"feed"`
+	URL  string `json:"url,omitempty"`
 	GUID string `json:"guid"`
 }
 
+// Story is the fingerprint of a queued item: its link and a SimHash of its
+// title and summary, zero when the text is too short.
+type Story struct {
+	Feed    string    `json:"feed"`
+	GUID    string    `json:"guid"`
+	URL     string    `json:"url,omitempty"`
+	SimHash uint64    `json:"simhash,omitempty"`
+	Seen    time.Time `json:"seen"`
+}
+
+// Translation holds title and summary of an item translated from Source
+// into Language. The summary is plain text.
+type Translation struct {
+	Source      string `json:"source"`
+	Language    string `json:"language"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// LeadImage is the picture representing an item. Thumbnail files are
+// relative to the shared images directory.
+type LeadImage struct {
+	URL        string       `json:"url"`
+	Source     string       `json:"source"`
+	Width      int          `json:"width"`
+	Height     int          `json:"height"`
+	SHA256     string       `json:"sha256"`
+	Thumbnails []*Thumbnail `json:"thumbnails,omitempty"`
+}
+
+type Thumbnail struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	File   string `json:"file"`
+}
+
+type Author struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// Enclosure is an attached media file. Duration is in seconds; File and
+// SHA256 are set once the media has been downloaded.
+type Enclosure struct {
+	URL      string `json:"url"`
+	Type     string `json:"type,omitempty"`
+	Length   int64  `json:"length,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	File     string `json:"file,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+type ITunesInfo struct {
+	Author      string `json:"author,omitempty"`
+	Subtitle    string `json:"subtitle,omitempty"`
+	Summary     string `json:"summary,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Explicit    string `json:"explicit,omitempty"`
+	Episode     string `json:"episode,omitempty"`
+	Season      string `json:"season,omitempty"`
+	EpisodeType string `json:"episode_type,omitempty"`
+}
+
+func newUnprocessedItem(remoteItem *gofeed.Item) *UnprocessedItem {
+	item := &UnprocessedItem{
+		GUID:       itemID(remoteItem),
+		URL:        canonicalURL(remoteItem.Link),
+		Title:      strings.TrimSpace(remoteItem.Title),
+		Published:  normalizedDate(remoteItem.PublishedParsed, remoteItem.Published),
+		Updated:    normalizedDate(remoteItem.UpdatedParsed, remoteItem.Updated),
+		Categories: remoteItem.Categories,
+		Extensions: remoteItem.Extensions,
+	}
+	// feed content is untrusted, only sanitized HTML is stored
+	item.Description = sanitizeHTML(remoteItem.Description, item.URL)
+	item.Content = sanitizeHTML(remoteItem.Content, item.URL)
+
+	authors := remoteItem.Authors
+	if len(authors) == 0 && remoteItem.Author != nil {
+		authors = []*gofeed.Person{remoteItem.Author}
+	}
+	for _, person := range authors {
+		if person != nil && (person.Name != "" || person.Email != "") {
+			item.Authors = append(item.Authors, &Author{Name: person.Name, Email: person.Email})
+		}
+	}
+
+	if remoteItem.Image != nil {
+		item.Image = remoteItem.Image.URL
+	}
+
+	var duration int
+	if itunes := remoteItem.ITunesExt; itunes != nil {
+		if item.Image == "" {
+			item.Image = itunes.Image
+		}
+		duration = parseDuration(itunes.Duration)
+		item.ITunes = &ITunesInfo{
+			Author:      itunes.Author,
+			Subtitle:    itunes.Subtitle,
+			Summary:     itunes.Summary,
+			Image:       itunes.Image,
+			Explicit:    itunes.Explicit,
+			Episode:     itunes.Episode,
+			Season:      itunes.Season,
+			EpisodeType: itunes.EpisodeType,
+		}
+	}
+
+	for _, enc := range remoteItem.Enclosures {
+		if enc.URL == "" {
+			continue
+		}
+		length, _ := strconv.ParseInt(strings.TrimSpace(enc.Length), 10, 64)
+		item.Enclosures = append(item.Enclosures, &Enclosure{
+			URL:      enc.URL,
+			Type:     enc.Type,
+			Length:   length,
+			Duration: duration,
+		})
+	}
+
+	item.Language = detectLanguage(itemLanguageText(item))
+
+	return item
+}
+
 type FeedFetcher interface {
 	ParseURL(feedURL string) (*gofeed.Feed, error)
 	ParseURLWithContext(feedURL string, ctx context.Context) (*gofeed.Feed, error)
@@ -51,4 +341,222 @@ func (m *MockGofeedParser) ParseURLWithContext(feedURL string, ctx context.Conte
 		return m.ParseURLWithContextFunc(feedURL, ctx)
 	}
 	return &gofeed.Feed{}, nil // Default
-}
\ No newline at end of file
+}
+
+// WatchConfig is the setup and the last seen state of a "watch" feed.
+// Selector narrows the page down to a fragment; empty means the whole body.
+type WatchConfig struct {
+	Selector string `json:"selector,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// MailConfig narrows a "maildir" feed down to one sender, which makes it a
+// virtual feed of that sender's newsletters.
+type MailConfig struct {
+	Sender string `json:"sender,omitempty"`
+}
+
+// CommandConfig is an external producer for "command" feeds: an executable
+// that prints RSS, Atom or JSON Feed to stdout. Timeout and MaxCPU are in
+// seconds, MaxMemory in bytes; zero means the default limit.
+type CommandConfig struct {
+	Path      string   `json:"path"`
+	Args      []string `json:"args,omitempty"`
+	Env       []string `json:"env,omitempty"`
+	Timeout   int      `json:"timeout,omitempty"`
+	MaxCPU    int      `json:"max_cpu,omitempty"`
+	MaxMemory int64    `json:"max_memory,omitempty"`
+	MaxFiles  int      `json:"max_files,omitempty"`
+}
+
+// SitemapConfig limits how much of a site a "sitemap" feed reads: how many
+// child sitemaps of an index are followed and how many items are kept.
+type SitemapConfig struct {
+	MaxChildren int `json:"max_children,omitempty"`
+	MaxItems    int `json:"max_items,omitempty"`
+}
+
+// PicturizeConfig turns on lead images for a user's new items, with
+// thumbnails of the given widths.
+type PicturizeConfig struct {
+	Widths []int `json:"widths,omitempty"`
+}
+
+// SummarizeConfig turns on extractive summaries of a user's new items, of
+// Sentences sentences.
+type SummarizeConfig struct {
+	Sentences int `json:"sentences,omitempty"`
+}
+
+// TopicsConfig turns on keyword extraction for a user's new items, Keywords
+// per item. Items get the topics of Dictionaries whose terms they mention;
+// a term is a word or phrase, a trailing "*" matches any word starting
+// with it.
+type TopicsConfig struct {
+	Keywords     int                 `json:"keywords,omitempty"`
+	Dictionaries map[string][]string `json:"dictionaries,omitempty"`
+}
+
+// TermStats count the items of a user, Documents, and how many of them
+// each word occurs in.
+type TermStats struct {
+	Documents   int            `json:"documents"`
+	Frequencies map[string]int `json:"frequencies,omitempty"`
+}
+
+// TranslateConfig turns on translation of new items into Target. Backend
+// is "libretranslate" (the default, served at URL) or "dictionary", which
+// translates word by word with Dictionary and takes every item to be in
+// Source.
+type TranslateConfig struct {
+	Target     string            `json:"target"`
+	Backend    string            `json:"backend,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	APIKey     string            `json:"api_key,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Dictionary map[string]string `json:"dictionary,omitempty"`
+}
+
+// LanguageFilter limits the items of a feed to languages in Allow, when
+// set, and not in Deny. Codes are ISO 639-1, as detected for the items.
+type LanguageFilter struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Rule applies Action to the items matching When, see rules.go for the
+// expression language. Tag, Priority and Delay are the arguments of the
+// tag, priority and delay actions.
+type Rule struct {
+	Name     string `json:"name,omitempty"`
+	When     string `json:"when"`
+	Action   string `json:"action"`
+	Tag      string `json:"tag,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Delay    string `json:"delay,omitempty"`
+
+	ruleCache
+}
+
+// Watchlist is a list of Terms, words or phrases, and Regexes the user
+// wants to hear of immediately. Matching items are queued with Priority,
+// 100 unless set.
+type Watchlist struct {
+	Name     string   `json:"name"`
+	Terms    []string `json:"terms,omitempty"`
+	Regexes  []string `json:"regexes,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+
+	watchlistCache
+}
+
+// DigestConfig turns on email digests of the pending items, sent Every
+// interval (a day by default), at At ("08:00") in Timezone when set.
+type DigestConfig struct {
+	To       []string   `json:"to"`
+	From     string     `json:"from"`
+	Subject  string     `json:"subject,omitempty"`
+	Every    string     `json:"every,omitempty"`
+	At       string     `json:"at,omitempty"`
+	Timezone string     `json:"timezone,omitempty"`
+	MaxItems int        `json:"max_items,omitempty"`
+	SMTP     SMTPConfig `json:"smtp"`
+}
+
+// SMTPConfig is the server digests are sent through. Security is
+// "starttls" (the default), "tls" or "none"; Port defaults to 587, 465 or
+// 25 accordingly.
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Security string `json:"security,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// WebhookConfig is an endpoint new items are POSTed to as JSON, one
+// request per item or, with Batch, one per feed and run. Requests are
+// signed with Secret when set. Failing deliveries are retried on later
+// runs, with backoff, up to MaxAttempts times (8 by default) before they
+// go to the dead-letter store; the same goes for the chat notifiers.
+type WebhookConfig struct {
+	URL         string `json:"url"`
+	Secret      string `json:"secret,omitempty"`
+	Batch       bool   `json:"batch,omitempty"`
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+}
+
+// SlackConfig posts new items to a Slack incoming webhook, URL.
+type SlackConfig struct {
+	URL         string `json:"url"`
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+}
+
+// DiscordConfig posts new items to a Discord webhook, URL, under Username
+// when set instead of the webhook's name.
+type DiscordConfig struct {
+	URL         string `json:"url"`
+	Username    string `json:"username,omitempty"`
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+}
+
+// MatrixConfig posts new items to Room, an ID like "!abc:example.org", on
+// Homeserver ("https://matrix.example.org") as the owner of AccessToken,
+// who must have joined the room.
+type MatrixConfig struct {
+	Homeserver  string `json:"homeserver"`
+	AccessToken string `json:"access_token"`
+	Room        string `json:"room"`
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+}
+
+// AlertSink is where watchlist alerts go: appended to File, to the stdin
+// of Command and POSTed to URL, whichever are set.
+type AlertSink struct {
+	File    string         `json:"file,omitempty"`
+	Command *CommandConfig `json:"command,omitempty"`
+	URL     string         `json:"url,omitempty"`
+}
+
+// DedupeConfig turns on cross-feed dedupe of stories. Distance is how many
+// of the 64 SimHash bits near-duplicates may differ in, stories are
+// remembered for RetentionDays.
+type DedupeConfig struct {
+	Distance      int `json:"distance,omitempty"`
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// CanonicalConfig turns on the link resolution that needs the item's page:
+// ResolveCanonical follows its rel=canonical, ExpandShorteners the
+// redirects of shortened links.
+type CanonicalConfig struct {
+	ResolveCanonical bool `json:"resolve_canonical,omitempty"`
+	ExpandShorteners bool `json:"expand_shorteners,omitempty"`
+}
+
+// ExpandConfig turns on full-text extraction for a feed's items. Selector
+// picks the article where the heuristics fail; Remove drops leftovers
+// inside it.
+type ExpandConfig struct {
+	Selector string   `json:"selector,omitempty"`
+	Remove   []string `json:"remove,omitempty"`
+}
+
+// WebSubState is a push subscription of a feed at its hub. The lease is
+// active until LeaseExpires; a zero LeaseExpires means not verified yet.
+type WebSubState struct {
+	Hub          string    `json:"hub"`
+	Topic        string    `json:"topic"`
+	Secret       string    `json:"secret"`
+	LeaseSeconds int       `json:"lease_seconds,omitempty"`
+	LeaseExpires time.Time `json:"lease_expires,omitzero"`
+}
+
+// MediaConfig turns on enclosure downloads for a user. Types are MIME type
+// prefixes to download, audio and video by default.
+type MediaConfig struct {
+	QuotaBytes    int64    `json:"quota_bytes"`
+	RetentionDays int      `json:"retention_days,omitempty"`
+	Types         []string `json:"types,omitempty"`
+}
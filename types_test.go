@@ -0,0 +1,80 @@
+package rss_reader
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func Test_newUnprocessedItem(t *testing.T) {
+	published := time.Date(2024, 3, 2, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+	item := newUnprocessedItem(&gofeed.Item{
+		GUID:            "guid1",
+		Link:            "https://example.com/post",
+		Title:           "  Post  ",
+		Description:     "<p>Teaser of the post</p>",
+		Content:         "<p>Full text</p>",
+		Author:          &gofeed.Person{Name: "Old Style"},
+		Authors:         []*gofeed.Person{{Name: "Ann", Email: "ann@example.com"}, {}},
+		PublishedParsed: &published,
+		Updated:         "Sat, 02 Mar 2024 12:00:00 +0100",
+		Categories:      []string{"go", "rss"},
+		Image:           &gofeed.Image{URL: "https://example.com/cover.png"},
+	})
+
+	want := &UnprocessedItem{
+		GUID:        "guid1",
+		URL:         "https://example.com/post",
+		Title:       "Post",
+		Description: "<p>Teaser of the post</p>",
+		Content:     "<p>Full text</p>",
+		Authors:     []*Author{{Name: "Ann", Email: "ann@example.com"}},
+		Published:   time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC),
+		Updated:     time.Date(2024, 3, 2, 11, 0, 0, 0, time.UTC),
+		Categories:  []string{"go", "rss"},
+		Image:       "https://example.com/cover.png",
+		Language:    "en",
+	}
+	if !reflect.DeepEqual(item, want) {
+		t.Errorf("expected %+v, got %+v", want, item)
+	}
+}
+
+func Test_UnprocessedItemJSON(t *testing.T) {
+	t.Run("OldFormat", func(t *testing.T) {
+		var item UnprocessedItem
+		if err := json.Unmarshal([]byte(`{"url":"url1","guid":"guid1"}`), &item); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if item.URL != "url1" || item.GUID != "guid1" || !item.Published.IsZero() {
+			t.Errorf("unexpected item %+v", item)
+		}
+
+		data, _ := json.Marshal(&item)
+		if string(data) != `{"url":"url1","guid":"guid1"}` {
+			t.Errorf("expected an old item to be saved unchanged, got %s", data)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		item := &UnprocessedItem{
+			URL:       "url1",
+			GUID:      "guid1",
+			Title:     "Title",
+			Authors:   []*Author{{Name: "Ann"}},
+			Published: time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC),
+		}
+		data, _ := json.Marshal(item)
+
+		var decoded UnprocessedItem
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if !reflect.DeepEqual(&decoded, item) {
+			t.Errorf("expected %+v, got %+v", item, decoded)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+const (
+	maxDiffLines = 200
+	// above this many cells the LCS table is too big, the diff falls back
+	// to "everything removed, everything added"
+	maxDiffCells = 4_000_000
+)
+
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// watchFeed turns a single page into a feed: whenever the normalized text of
+// the page (or of the fragment picked by config.Selector) changes, it emits
+// one synthetic item carrying a line diff. The first look only records a
+// baseline. The last seen text is kept in config so the next run can diff.
+func watchFeed(ctx context.Context, pageURL string, config *WatchConfig) (*gofeed.Feed, error) {
+	page, err := httpGet(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	title := collapseSpaces(doc.Find("title").First().Text())
+	if title == "" {
+		title = pageURL
+	}
+
+	sel := doc.Find("body")
+	if config.Selector != "" {
+		sel = doc.Find(config.Selector)
+	}
+	sel.Find("script, style, noscript, template").Remove()
+
+	text := normalizedText(sel)
+	hash := GetSHA256(text)
+
+	feed := &gofeed.Feed{
+		Title:    title,
+		Link:     pageURL,
+		FeedType: FEED_TYPE_WATCH,
+		Updated:  hash,
+	}
+
+	if config.Hash == hash {
+		return feed, nil
+	}
+
+	if config.Hash != "" {
+		now := time.Now().UTC()
+		feed.Items = []*gofeed.Item{{
+			GUID:            "watch:" + config.Hash[:16] + ":" + hash[:16],
+			Title:           "Changed: " + title,
+			Link:            pageURL,
+			Description:     lineDiff(splitLines(config.Text), splitLines(text)),
+			Published:       now.Format(time.RFC3339),
+			PublishedParsed: &now,
+		}}
+	}
+
+	config.Hash = hash
+	config.Text = text
+
+	return feed, nil
+}
+
+// normalizedText renders the selection as plain text, one line per block
+// element, with whitespace collapsed, so markup-only changes are ignored.
+func normalizedText(sel *goquery.Selection) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if blockElements[n.Data] {
+				b.WriteByte('\n')
+				defer b.WriteByte('\n')
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range sel.Nodes {
+		walk(n)
+		b.WriteByte('\n')
+	}
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = collapseSpaces(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// lineDiff returns the changed lines between a and b, "- " for removed and
+// "+ " for added, in document order.
+func lineDiff(a, b []string) string {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	var out []string
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			out = append(out, "- "+line)
+		}
+		for _, line := range b {
+			out = append(out, "+ "+line)
+		}
+	} else {
+		out = lcsDiff(a, b)
+	}
+
+	if len(out) > maxDiffLines {
+		more := len(out) - maxDiffLines
+		out = append(out[:maxDiffLines], fmt.Sprintf("... %d more changed lines", more))
+	}
+
+	return strings.Join(out, "\n")
+}
+
+func lcsDiff(a, b []string) []string {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+package rss_reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_lineDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{"no change", []string{"a", "b"}, []string{"a", "b"}, ""},
+		{"changed line", []string{"a", "price 10", "c"}, []string{"a", "price 12", "c"}, "- price 10\n+ price 12"},
+		{"added line", []string{"a", "c"}, []string{"a", "b", "c"}, "+ b"},
+		{"removed lines", []string{"a", "b", "c", "d"}, []string{"a", "d"}, "- b\n- c"},
+		{"from empty", nil, []string{"x"}, "+ x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineDiff(tt.a, tt.b); got != tt.want {
+				t.Errorf("lineDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getUpdatesWatch(t *testing.T) {
+	price := "10"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Shop</title><script>var t = %q</script></head><body>
+<div id="ad">random %s</div>
+<div id="price"><h1>Widget</h1>  <p>Price:   %s EUR</p></div></body></html>`, r.URL.RawQuery, r.RemoteAddr, price)
+	}))
+	defer srv.Close()
+
+	userFeed := &Feed{
+		Type:             FEED_TYPE_WATCH,
+		Url:              srv.URL,
+		UnprocessedGUID:  UnrpocessedGUIDSet{},
+		UnprocessedItems: []*UnprocessedItem{},
+		Watch:            &WatchConfig{Selector: "#price"},
+	}
+	log := setupLogger(io.Discard)
+
+	// baseline
+	if err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, log); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(userFeed.UnprocessedItems) != 0 {
+		t.Fatalf("expected no items on first look, got %d", len(userFeed.UnprocessedItems))
+	}
+	if userFeed.Watch.Text != "Widget\nPrice: 10 EUR" {
+		t.Errorf("unexpected normalized text %q", userFeed.Watch.Text)
+	}
+
+	// unchanged fragment
+	if err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, log); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(userFeed.UnprocessedItems) != 0 {
+		t.Fatalf("expected no items for unchanged page, got %d", len(userFeed.UnprocessedItems))
+	}
+
+	price = "12"
+	if err := getUpdates(context.Background(), &MockGofeedParser{}, userFeed, log); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(userFeed.UnprocessedItems) != 1 {
+		t.Fatalf("expected one change item, got %d", len(userFeed.UnprocessedItems))
+	}
+	guid := userFeed.UnprocessedItems[0].GUID
+	if !strings.HasPrefix(guid, "watch:") {
+		t.Errorf("unexpected synthetic guid %q", guid)
+	}
+	if userFeed.Watch.Text != "Widget\nPrice: 12 EUR" {
+		t.Errorf("expected stored text to follow the page, got %q", userFeed.Watch.Text)
+	}
+}
+
+func Test_watchFeedDiff(t *testing.T) {
+	body := "<p>one</p><p>two</p>"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html><body>"+body+"</body></html>")
+	}))
+	defer srv.Close()
+
+	config := &WatchConfig{}
+	if _, err := watchFeed(context.Background(), srv.URL, config); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	body = "<p>one</p><p>three</p>"
+	feed, err := watchFeed(context.Background(), srv.URL, config)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(feed.Items) != 1 || feed.Items[0].Description != "- two\n+ three" {
+		t.Fatalf("expected a diff item, got %+v", feed.Items)
+	}
+}
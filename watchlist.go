@@ -0,0 +1,315 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	defaultAlertPriority = 100
+	// runes of context around the match in an alert's snippet
+	alertContextRunes = 80
+	// alerts sent at once; a sink may take its whole timeout
+	maxConcurrentAlerts = 4
+)
+
+var (
+	ErrAlertNotConfigured = errors.New("no alert sink configured")
+	ErrWatchlistPattern   = errors.New("invalid watchlist pattern")
+)
+
+// Alert is what an alert sink gets for a watched item: one line of JSON
+// appended to the file, on the command's stdin or as the POST body.
+type Alert struct {
+	Watchlist string    `json:"watchlist"`
+	Term      string    `json:"term"`
+	Feed      string    `json:"feed"`
+	GUID      string    `json:"guid"`
+	URL       string    `json:"url,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Snippet   string    `json:"snippet"`
+	Time      time.Time `json:"time"`
+}
+
+// watchPattern is a compiled term or regex of a watchlist.
+type watchPattern struct {
+	term string
+	re   *regexp.Regexp
+}
+
+type watchlistCache struct {
+	once     sync.Once
+	patterns []watchPattern
+	err      error
+}
+
+// watchOutcome is what the watchlists of a user make of an item.
+type watchOutcome struct {
+	Alerts   []*Alert
+	Priority int
+	Errors   []error
+}
+
+// compile builds a pattern for every term and regex. Both match on word
+// boundaries, against text folded to lower case without accents, so
+// "Munchen" finds "München".
+func (w *Watchlist) compile() ([]watchPattern, error) {
+	w.once.Do(func() {
+		var errs []error
+		for _, term := range w.Terms {
+			words := strings.Fields(foldText(term))
+			if len(words) == 0 {
+				continue
+			}
+			quoted := make([]string, len(words))
+			for i, word := range words {
+				quoted[i] = regexp.QuoteMeta(word)
+			}
+			re, _ := watchRegexp(strings.Join(quoted, `\s+`))
+			w.patterns = append(w.patterns, watchPattern{term: term, re: re})
+		}
+		for _, expr := range w.Regexes {
+			// lower casing would change escapes like \D, case is ignored
+			// instead
+			re, err := watchRegexp("(?i:" + removeAccents(expr) + ")")
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%w: %s: %s", ErrWatchlistPattern, w.Name, err))
+				continue
+			}
+			w.patterns = append(w.patterns, watchPattern{term: expr, re: re})
+		}
+		w.err = errors.Join(errs...)
+	})
+	return w.patterns, w.err
+}
+
+// watchRegexp wraps expr in word boundaries; Go's \b knows ASCII only.
+func watchRegexp(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(`(?:^|[^\p{L}\p{N}])(` + expr + `)(?:[^\p{L}\p{N}]|$)`)
+}
+
+func removeAccents(s string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			sb.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(sb.String())
+}
+
+// foldText lowercases s and strips its accents, rune by rune, so offsets
+// can be mapped back, see foldWithOffsets.
+func foldText(s string) string {
+	folded, _ := foldWithOffsets(s)
+	return folded
+}
+
+// foldWithOffsets also returns, for every byte of the folded text, the
+// offset of the rune of s it came from, plus one entry for the end.
+func foldWithOffsets(s string) (string, []int) {
+	var sb strings.Builder
+	offsets := make([]int, 0, len(s)+1)
+	for i, r := range s {
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			before := sb.Len()
+			sb.WriteRune(unicode.ToLower(d))
+			for range sb.Len() - before {
+				offsets = append(offsets, i)
+			}
+		}
+	}
+	offsets = append(offsets, len(s))
+	return sb.String(), offsets
+}
+
+// watchItem matches item against the watchlists of the user. It makes an
+// alert for every watchlist that matches, reporting its first match.
+func (f *Feed) watchItem(item *UnprocessedItem, now time.Time) watchOutcome {
+	var outcome watchOutcome
+	if f.settings == nil || len(f.settings.Watchlists) == 0 {
+		return outcome
+	}
+
+	text := strings.Join([]string{item.Title, htmlText(item.Description), htmlText(item.Content)}, "\n")
+	folded, offsets := foldWithOffsets(text)
+
+	for _, list := range f.settings.Watchlists {
+		patterns, err := list.compile()
+		if err != nil {
+			outcome.Errors = append(outcome.Errors, err)
+		}
+		for _, p := range patterns {
+			m := p.re.FindStringSubmatchIndex(folded)
+			if m == nil {
+				continue
+			}
+			outcome.Alerts = append(outcome.Alerts, &Alert{
+				Watchlist: list.Name,
+				Term:      p.term,
+				Feed:      f.Url,
+				GUID:      item.GUID,
+				URL:       item.URL,
+				Title:     item.Title,
+				Snippet:   highlight(text, offsets[m[2]], offsets[m[3]]),
+				Time:      now.UTC(),
+			})
+			outcome.Priority = max(outcome.Priority, list.priority())
+			break
+		}
+	}
+	return outcome
+}
+
+// alertItem raises the priority of an item on a watchlist and holds its
+// alerts on it until sendAlerts, after dedupe: a story carried by several
+// feeds alerts once.
+func alertItem(userFeed *Feed, item *UnprocessedItem, log *slog.Logger) {
+	outcome := userFeed.watchItem(item, time.Now())
+	for _, err := range outcome.Errors {
+		log.Warn("watchlist failed", "error", err)
+	}
+	if len(outcome.Alerts) == 0 {
+		return
+	}
+	item.Priority = max(item.Priority, outcome.Priority)
+	for _, alert := range outcome.Alerts {
+		log.Info("watchlist match", "guid", item.GUID, "watchlist", alert.Watchlist, "term", alert.Term)
+	}
+	item.Alerts = append(item.Alerts, outcome.Alerts...)
+}
+
+// sendAlerts sends the alerts held on queued items, maxConcurrentAlerts
+// at a time, and forgets them. Failed alerts are logged, not tried again.
+func (f *Feeds) sendAlerts(ctx context.Context, log *slog.Logger) {
+	var sink *AlertSink
+	if f.Settings != nil {
+		sink = f.Settings.Alerts
+	}
+
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentAlerts)
+	for _, feed := range f.Items {
+		for _, item := range feed.UnprocessedItems {
+			for _, alert := range item.Alerts {
+				g.Go(func() error {
+					if err := sink.send(ctx, alert); err != nil {
+						log.Warn("alert failed", "guid", alert.GUID, "watchlist", alert.Watchlist, "error", err)
+					}
+					return nil
+				})
+			}
+			item.Alerts = nil
+		}
+	}
+	g.Wait()
+}
+
+// highlight cuts the text around text[start:end] and marks the match with
+// double asterisks.
+func highlight(text string, start, end int) string {
+	before := []rune(text[:start])
+	after := []rune(text[end:])
+
+	prefix, suffix := "", ""
+	if len(before) > alertContextRunes {
+		before, prefix = before[len(before)-alertContextRunes:], ellipsis
+	}
+	if len(after) > alertContextRunes {
+		after, suffix = after[:alertContextRunes], ellipsis
+	}
+	return collapseSpaces(prefix + string(before) + "**" + text[start:end] + "**" + string(after) + suffix)
+}
+
+func (w *Watchlist) priority() int {
+	if w.Priority == 0 {
+		return defaultAlertPriority
+	}
+	return w.Priority
+}
+
+// send pushes alert to every sink the user configured.
+func (s *AlertSink) send(ctx context.Context, alert *Alert) error {
+	if s == nil || (s.File == "" && s.Command == nil && s.URL == "") {
+		return ErrAlertNotConfigured
+	}
+	line, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	var errs []error
+	if s.File != "" {
+		errs = append(errs, appendAlert(s.File, line))
+	}
+	if s.Command != nil {
+		errs = append(errs, runAlertCommand(ctx, s.Command, line))
+	}
+	if s.URL != "" {
+		errs = append(errs, postAlert(ctx, s.URL, line))
+	}
+	return errors.Join(errs...)
+}
+
+func appendAlert(name string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runAlertCommand runs the alert command like a command feed, with the
+// alert on stdin.
+func runAlertCommand(ctx context.Context, config *CommandConfig, line []byte) error {
+	stderr, err := runCommand(ctx, config, bytes.NewReader(line), nil)
+	if err != nil && stderr != "" {
+		return fmt.Errorf("%w: %s", err, stderr)
+	}
+	return err
+}
+
+func postAlert(ctx context.Context, url string, line []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s %s", ErrUnexpectedStatus, url, resp.Status)
+	}
+	return nil
+}
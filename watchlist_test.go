@@ -0,0 +1,202 @@
+package rss_reader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func Test_watchItem(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	feed.settings = &Settings{Watchlists: []*Watchlist{
+		{Name: "cities", Terms: []string{"Munchen", "new  york"}},
+		{Name: "models", Regexes: []string{`gpt-?\d+`}, Priority: 50},
+		{Name: "pets", Terms: []string{"cat"}},
+	}}
+
+	tests := []struct {
+		name     string
+		item     *UnprocessedItem
+		lists    []string
+		snippet  string
+		priority int
+	}{
+		{"Folding", &UnprocessedItem{Title: "Oktoberfest in MÜNCHEN opens"}, []string{"cities"}, "Oktoberfest in **MÜNCHEN** opens", 100},
+		{"Phrase", &UnprocessedItem{Description: "<p>Flights to New\nYork resume</p>"}, []string{"cities"}, "Flights to **New York** resume", 100},
+		{"Regex", &UnprocessedItem{Title: "GPT4 and gpt-5 compared"}, []string{"models"}, "**GPT4** and gpt-5 compared", 50},
+		{"WordBoundary", &UnprocessedItem{Title: "Concatenate the cats"}, nil, "", 0},
+		{"Several", &UnprocessedItem{Title: "A cat in New York"}, []string{"cities", "pets"}, "A cat in **New York**", 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome := feed.watchItem(tt.item, now)
+			if len(outcome.Errors) > 0 {
+				t.Fatal(outcome.Errors)
+			}
+			var lists []string
+			for _, alert := range outcome.Alerts {
+				lists = append(lists, alert.Watchlist)
+			}
+			if strings.Join(lists, ",") != strings.Join(tt.lists, ",") {
+				t.Fatalf("expected alerts of %v, got %v", tt.lists, lists)
+			}
+			if len(outcome.Alerts) > 0 && outcome.Alerts[0].Snippet != tt.snippet {
+				t.Errorf("expected snippet %q, got %q", tt.snippet, outcome.Alerts[0].Snippet)
+			}
+			if outcome.Priority != tt.priority {
+				t.Errorf("expected priority %d, got %d", tt.priority, outcome.Priority)
+			}
+		})
+	}
+
+	broken := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	broken.settings = &Settings{Watchlists: []*Watchlist{{Name: "broken", Regexes: []string{"(unclosed"}}}}
+	if outcome := broken.watchItem(&UnprocessedItem{Title: "anything"}, now); len(outcome.Errors) != 1 {
+		t.Errorf("expected an error for an invalid regex, got %v", outcome.Errors)
+	}
+}
+
+func Test_highlight(t *testing.T) {
+	text := strings.Repeat("word ", 40) + "MATCH" + strings.Repeat(" tail", 40)
+	start := strings.Index(text, "MATCH")
+	got := highlight(text, start, start+len("MATCH"))
+	if !strings.HasPrefix(got, ellipsis) || !strings.HasSuffix(got, ellipsis) || !strings.Contains(got, "**MATCH**") {
+		t.Errorf("unexpected snippet %q", got)
+	}
+}
+
+func Test_AlertSink(t *testing.T) {
+	dir := t.TempDir()
+	var posted Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &posted)
+	}))
+	defer srv.Close()
+
+	sink := &AlertSink{File: filepath.Join(dir, "alerts", "alerts.jsonl"), URL: srv.URL}
+	if runtime.GOOS != "windows" {
+		sink.Command = &CommandConfig{Path: "/bin/sh", Args: []string{"-c", "cat > " + filepath.Join(dir, "stdin.json")}}
+	}
+
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	feed.settings = &Settings{Watchlists: []*Watchlist{{Name: "launches", Terms: []string{"rocket"}}}, Alerts: sink}
+	item := &UnprocessedItem{GUID: "1", Title: "Rocket launch today", Priority: 5}
+
+	alertItem(feed, item, setupLogger(io.Discard))
+
+	if item.Priority != defaultAlertPriority {
+		t.Errorf("expected the item to get priority %d, got %d", defaultAlertPriority, item.Priority)
+	}
+	if len(item.Alerts) != 1 {
+		t.Fatalf("expected the alert held on the item, got %+v", item.Alerts)
+	}
+	feed.UnprocessedItems = []*UnprocessedItem{item}
+	feeds := &Feeds{Settings: feed.settings, Items: []*Feed{feed}}
+	feeds.sendAlerts(context.Background(), setupLogger(io.Discard))
+	if item.Alerts != nil {
+		t.Errorf("expected sent alerts to be forgotten, got %+v", item.Alerts)
+	}
+	data, err := os.ReadFile(sink.File)
+	if err != nil || !strings.Contains(string(data), `"snippet":"**Rocket** launch today"`) {
+		t.Errorf("expected the alert in the file, got %q %v", data, err)
+	}
+	if posted.GUID != "1" || posted.Term != "rocket" {
+		t.Errorf("expected the alert to be posted, got %+v", posted)
+	}
+	if sink.Command != nil {
+		if stdin, err := os.ReadFile(filepath.Join(dir, "stdin.json")); err != nil || !strings.Contains(string(stdin), `"watchlist":"launches"`) {
+			t.Errorf("expected the alert on the command's stdin, got %q %v", stdin, err)
+		}
+	}
+
+	if err := (*AlertSink)(nil).send(context.Background(), &Alert{}); err != ErrAlertNotConfigured {
+		t.Errorf("expected ErrAlertNotConfigured, got %v", err)
+	}
+}
+
+func Test_alertsAfterDedupe(t *testing.T) {
+	alerts := filepath.Join(t.TempDir(), "alerts.jsonl")
+	feeds := &Feeds{
+		Settings: &Settings{
+			Dedupe:     &DedupeConfig{},
+			Watchlists: []*Watchlist{{Name: "rates", Terms: []string{"central bank"}}},
+			Alerts:     &AlertSink{File: alerts},
+		},
+	}
+	for i, site := range []string{"news.example.com", "aggregator.example.org", "other.example.net"} {
+		feeds.Items = append(feeds.Items, newFeed(FEED_TYPE_RSS, "https://"+site+"/feed"))
+		feeds.Items[i].Updated = "old"
+	}
+	feeds.attachSettings()
+	log := setupLogger(io.Discard)
+
+	queued := queuedCounts(feeds.Items)
+	for _, feed := range feeds.Items {
+		remoteFeed := &gofeed.Feed{Updated: "new", Items: []*gofeed.Item{{
+			GUID:        feed.Url + "#rates",
+			Link:        "https://news.example.com/rates",
+			Title:       "Central bank raises rates",
+			Description: dedupeTestSummary,
+		}}}
+		if err := applyUpdates(context.Background(), feed, remoteFeed, log); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	feeds.finishUpdate(context.Background(), queued, filepath.Join(t.TempDir(), "feeds.json"), log)
+
+	data, err := os.ReadFile(alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("expected one alert for a story in three feeds, got %d:\n%s", lines, data)
+	}
+}
+
+func Test_sendAlertsSlowSink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not available")
+	}
+	sink := &AlertSink{Command: &CommandConfig{Path: "/bin/sh", Args: []string{"-c", "sleep 1"}}}
+	feeds := &Feeds{
+		Settings: &Settings{Watchlists: []*Watchlist{{Name: "launches", Terms: []string{"rocket"}}}, Alerts: sink},
+		Items:    []*Feed{newFeed(FEED_TYPE_RSS, "https://example.com/feed")},
+	}
+	feeds.Items[0].Updated = "old"
+	feeds.attachSettings()
+	log := setupLogger(io.Discard)
+
+	remoteFeed := &gofeed.Feed{Updated: "new"}
+	for _, guid := range []string{"1", "2", "3", "4"} {
+		remoteFeed.Items = append(remoteFeed.Items, &gofeed.Item{GUID: guid, Title: "Rocket launch " + guid})
+	}
+
+	start := time.Now()
+	if err := applyUpdates(context.Background(), feeds.Items[0], remoteFeed, log); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected queueing not to wait for the sink, took %s", elapsed)
+	}
+	if len(feeds.Items[0].UnprocessedItems) != 4 {
+		t.Fatalf("expected 4 queued items, got %d", len(feeds.Items[0].UnprocessedItems))
+	}
+
+	start = time.Now()
+	feeds.sendAlerts(context.Background(), log)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected alerts to be sent side by side, took %s", elapsed)
+	}
+}
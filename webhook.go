@@ -0,0 +1,122 @@
+package rss_reader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	WEBHOOK_SIGNATURE_HEADER   = "X-Signature-256"
+	WEBHOOK_IDEMPOTENCY_HEADER = "Idempotency-Key"
+)
+
+// webhookPayload is the body of a webhook request. Single items are sent
+// in the same shape, as a batch of one.
+type webhookPayload struct {
+	Feed  webhookFeed    `json:"feed"`
+	Items []*webhookItem `json:"items"`
+}
+
+type webhookFeed struct {
+	URL   string `json:"url"`
+	Hash  string `json:"hash"`
+	Title string `json:"title,omitempty"`
+}
+
+type webhookItem struct {
+	Key         string    `json:"key"`
+	GUID        string    `json:"guid"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Authors     []*Author `json:"authors,omitempty"`
+	Published   time.Time `json:"published,omitzero"`
+	Updated     time.Time `json:"updated,omitzero"`
+	Image       string    `json:"image,omitempty"`
+	Categories  []string  `json:"categories,omitempty"`
+	Keywords    []string  `json:"keywords,omitempty"`
+	Topics      []string  `json:"topics,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Priority    int       `json:"priority,omitempty"`
+	Language    string    `json:"language,omitempty"`
+}
+
+// webhookNotifier POSTs new items as JSON to a webhook. Every request
+// carries an idempotency key, the same on retries, and is signed like
+// WebSub content: sha256=<hex HMAC of the body>.
+type webhookNotifier struct {
+	config   *WebhookConfig
+	endpoint *endpoint
+}
+
+func newWebhookNotifier(config *WebhookConfig, dead *deadLetters) *webhookNotifier {
+	return &webhookNotifier{
+		config:   config,
+		endpoint: newEndpoint("webhook", config.URL, config.MaxAttempts, dead),
+	}
+}
+
+func (w *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (w *webhookNotifier) ID() string {
+	return w.endpoint.consumer
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, userFeed *Feed, items []*UnprocessedItem) error {
+	size := 1
+	if w.config.Batch {
+		size = max(len(items), 1)
+	}
+	feed := webhookFeed{URL: userFeed.Url, Hash: userFeed.Hash, Title: userFeed.Title}
+	return notifyBatches(ctx, w.endpoint, userFeed, items, chunksOf(size), func(key string, batch []*UnprocessedItem) (*message, error) {
+		payload := webhookPayload{Feed: feed}
+		for _, item := range batch {
+			payload.Items = append(payload.Items, newWebhookItem(userFeed, item))
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		msg := &message{key: key, url: w.config.URL, header: http.Header{}, body: body}
+		msg.header.Set(WEBHOOK_IDEMPOTENCY_HEADER, key)
+		if w.config.Secret != "" {
+			msg.header.Set(WEBHOOK_SIGNATURE_HEADER, signPayload(w.config.Secret, body))
+		}
+		return msg, nil
+	})
+}
+
+func newWebhookItem(userFeed *Feed, item *UnprocessedItem) *webhookItem {
+	return &webhookItem{
+		Key:         itemKey(userFeed, item),
+		GUID:        item.GUID,
+		URL:         item.URL,
+		Title:       item.Title,
+		Summary:     item.Summary,
+		Description: item.Description,
+		Authors:     item.Authors,
+		Published:   item.Published,
+		Updated:     item.Updated,
+		Image:       itemImage(item),
+		Categories:  item.Categories,
+		Keywords:    item.Keywords,
+		Topics:      item.Topics,
+		Tags:        item.Tags,
+		Priority:    item.Priority,
+		Language:    item.Language,
+	}
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
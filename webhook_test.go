@@ -0,0 +1,296 @@
+package rss_reader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+type webhookRequest struct {
+	key       string
+	signature string
+	body      []byte
+}
+
+// webhookStandIn answers with the statuses in order, then with 200.
+func webhookStandIn(t *testing.T, statuses ...int) (*httptest.Server, func() []webhookRequest) {
+	var mu sync.Mutex
+	var requests []webhookRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, webhookRequest{
+			key:       r.Header.Get(WEBHOOK_IDEMPOTENCY_HEADER),
+			signature: r.Header.Get(WEBHOOK_SIGNATURE_HEADER),
+			body:      body,
+		})
+		if len(requests) <= len(statuses) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(statuses[len(requests)-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookRequest(nil), requests...)
+	}
+}
+
+func webhookFixture(t *testing.T) (*Feed, []*UnprocessedItem, *deadLetters) {
+	backoff := deliveryBackoff
+	deliveryBackoff = time.Millisecond
+	t.Cleanup(func() { deliveryBackoff = backoff })
+
+	feed := newFeed(FEED_TYPE_RSS, "https://example.com/feed")
+	feed.Title = "Example"
+	items := []*UnprocessedItem{
+		{GUID: "https://example.com/1", URL: "https://example.com/1", Title: "One"},
+		{GUID: "https://example.com/2", URL: "https://example.com/2", Title: "Two"},
+	}
+	return feed, items, newDeadLetters(filepath.Join(t.TempDir(), "deadletter.jsonl"))
+}
+
+func Test_webhookNotifier(t *testing.T) {
+	feed, items, dead := webhookFixture(t)
+	srv, requests := webhookStandIn(t)
+
+	notifier := newWebhookNotifier(&WebhookConfig{URL: srv.URL, Secret: "s3cret"}, dead)
+	if err := notifier.Notify(context.Background(), feed, items); err != nil {
+		t.Fatal(err)
+	}
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(got))
+	}
+	for i, req := range got {
+		if req.key != itemKey(feed, items[i]) {
+			t.Errorf("expected the key of item %d, got %q", i, req.key)
+		}
+		if !validSignature("s3cret", req.signature, req.body) {
+			t.Errorf("invalid signature %q", req.signature)
+		}
+		if d := items[i].Deliveries[notifier.ID()]; d.State != DELIVERY_DONE || d.Attempts != 1 {
+			t.Errorf("expected item %d done, got %+v", i, d)
+		}
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(got[1].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Feed.Title != "Example" || len(payload.Items) != 1 || payload.Items[0].Title != "Two" {
+		t.Errorf("unexpected payload %s", got[1].body)
+	}
+}
+
+func Test_webhookNotifierRetry(t *testing.T) {
+	feed, items, dead := webhookFixture(t)
+	srv, requests := webhookStandIn(t, http.StatusServiceUnavailable)
+	config := &WebhookConfig{URL: srv.URL, Secret: "s3cret"}
+
+	before := time.Now()
+	if err := newWebhookNotifier(config, dead).Notify(context.Background(), feed, items); err == nil {
+		t.Fatal("expected an error")
+	}
+	// the failure takes the endpoint down for the run, the second item
+	// waits without losing an attempt
+	if got := requests(); len(got) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(got))
+	}
+	first, second := items[0].Deliveries, items[1].Deliveries
+	consumer := newWebhookNotifier(config, dead).ID()
+	if d := first[consumer]; d.State != DELIVERY_FAILED || d.Attempts != 1 || !d.NextRetry.After(before) || d.LastError == "" {
+		t.Errorf("expected the first item failed, got %+v", d)
+	}
+	if d := second[consumer]; d.State != DELIVERY_PENDING || d.Attempts != 0 || d.NextRetry.IsZero() {
+		t.Errorf("expected the second item pending, got %+v", d)
+	}
+
+	// the next run
+	if err := newWebhookNotifier(config, dead).Notify(context.Background(), feed, items); err != nil {
+		t.Fatal(err)
+	}
+	got := requests()
+	if len(got) != 3 || got[1].key != got[0].key {
+		t.Fatalf("expected the first item again with the same key, got %d requests", len(got))
+	}
+	if d := first[consumer]; d.State != DELIVERY_DONE || d.Attempts != 2 || d.LastError != "" || !d.NextRetry.IsZero() {
+		t.Errorf("expected the first item done, got %+v", d)
+	}
+	if entries, _ := dead.list(); len(entries) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(entries))
+	}
+}
+
+func Test_webhookNotifierBatch(t *testing.T) {
+	feed, items, dead := webhookFixture(t)
+	srv, requests := webhookStandIn(t)
+
+	notifier := newWebhookNotifier(&WebhookConfig{URL: srv.URL, Batch: true}, dead)
+	if err := notifier.Notify(context.Background(), feed, items); err != nil {
+		t.Fatal(err)
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(got))
+	}
+	if got[0].signature != "" {
+		t.Errorf("expected no signature without a secret, got %q", got[0].signature)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(got[0].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Items) != 2 || payload.Items[1].Key != itemKey(feed, items[1]) {
+		t.Errorf("unexpected payload %s", got[0].body)
+	}
+}
+
+func Test_webhookNotifierBatchRetry(t *testing.T) {
+	feed, items, dead := webhookFixture(t)
+	srv, requests := webhookStandIn(t, http.StatusServiceUnavailable)
+	config := &WebhookConfig{URL: srv.URL, Batch: true}
+
+	if err := newWebhookNotifier(config, dead).Notify(context.Background(), feed, items); err == nil {
+		t.Fatal("expected an error")
+	}
+	// the retry goes with an item new since, still under the first key
+	items = append(items, &UnprocessedItem{GUID: "https://example.com/3", URL: "https://example.com/3", Title: "Three"})
+	if err := newWebhookNotifier(config, dead).Notify(context.Background(), feed, items); err != nil {
+		t.Fatal(err)
+	}
+
+	got := requests()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(got))
+	}
+	if got[1].key != got[0].key || got[2].key == got[0].key {
+		t.Errorf("expected the retried batch under its first key and the new item under its own")
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(got[2].body, &payload); err != nil || len(payload.Items) != 1 || payload.Items[0].Title != "Three" {
+		t.Errorf("unexpected payload %s", got[2].body)
+	}
+}
+
+// Duplicate stories are dropped before anything is delivered.
+func Test_webhookAfterDedupe(t *testing.T) {
+	srv, requests := webhookStandIn(t)
+	userFeedsFile := filepath.Join(t.TempDir(), "user.json")
+
+	mockFeedsIO := &MockFeedsIO{
+		GetFeedsFileFunc: func(string) (string, error) { return userFeedsFile, nil },
+		LoadFeedsFunc: func(string) (Feeds, error) {
+			return Feeds{
+				Settings: &Settings{
+					Dedupe:   &DedupeConfig{},
+					Webhooks: []*WebhookConfig{{URL: srv.URL}},
+					Slack:    []*SlackConfig{{URL: srv.URL}},
+				},
+				Items: []*Feed{newFeed(FEED_TYPE_RSS, "https://a.example.com/feed"), newFeed(FEED_TYPE_RSS, "https://b.example.com/feed")},
+			}, nil
+		},
+	}
+	mockFeedFetcher := &MockGofeedParser{
+		ParseURLWithContextFunc: func(feedURL string, ctx context.Context) (*gofeed.Feed, error) {
+			return &gofeed.Feed{Updated: "1", Items: []*gofeed.Item{{GUID: feedURL + "#1", Link: "https://example.com/story", Title: "Story"}}}, nil
+		},
+	}
+
+	if code := run(TestAppArgs, mockFeedsIO, mockFeedFetcher, io.Discard); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	// once to the webhook, once to Slack
+	if got := requests(); len(got) != 2 {
+		t.Errorf("expected the story delivered once per notifier, got %d requests", len(got))
+	}
+}
+
+func Test_webhookNotifierDeadLetter(t *testing.T) {
+	t.Run("Rejected", func(t *testing.T) {
+		feed, items, dead := webhookFixture(t)
+		srv, requests := webhookStandIn(t, http.StatusBadRequest)
+
+		notifier := newWebhookNotifier(&WebhookConfig{URL: srv.URL}, dead)
+		if err := notifier.Notify(context.Background(), feed, items); err == nil {
+			t.Fatal("expected an error")
+		}
+		// a rejected payload is not retried, the endpoint still takes others
+		if got := requests(); len(got) != 2 {
+			t.Fatalf("expected 2 requests, got %d", len(got))
+		}
+		entries, err := dead.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 dead letter, got %d", len(entries))
+		}
+		entry := entries[0]
+		if entry.Attempts != 1 || entry.Key != itemKey(feed, items[0]) || entry.Consumer != notifier.ID() {
+			t.Errorf("unexpected dead letter %+v", entry)
+		}
+		if entry.Feed != feed.Hash || len(entry.GUIDs) != 1 || entry.GUIDs[0] != items[0].GUID {
+			t.Errorf("expected the dead item in the dead letter, got %s %v", entry.Feed, entry.GUIDs)
+		}
+		if items[0].Deliveries[notifier.ID()].State != DELIVERY_DEAD || items[1].Deliveries[notifier.ID()].State != DELIVERY_DONE {
+			t.Errorf("expected the first item dead and the second done")
+		}
+		var payload webhookPayload
+		if err := json.Unmarshal(entry.Payload, &payload); err != nil || payload.Items[0].GUID != items[0].GUID {
+			t.Errorf("unexpected dead letter payload %s", entry.Payload)
+		}
+	})
+
+	t.Run("Attempts", func(t *testing.T) {
+		feed, items, dead := webhookFixture(t)
+		srv, requests := webhookStandIn(t, 500, 500, 500)
+
+		for range 2 {
+			notifier := newWebhookNotifier(&WebhookConfig{URL: srv.URL, MaxAttempts: 2}, dead)
+			if err := notifier.Notify(context.Background(), feed, items[:1]); err == nil {
+				t.Fatal("expected an error")
+			}
+		}
+		if got := requests(); len(got) != 2 {
+			t.Fatalf("expected 2 attempts, got %d", len(got))
+		}
+		entries, err := dead.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Attempts != 2 {
+			t.Fatalf("unexpected dead letters %+v", entries)
+		}
+		for _, d := range items[0].Deliveries {
+			if d.State != DELIVERY_DEAD {
+				t.Errorf("expected the item dead, got %+v", d)
+			}
+		}
+	})
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("expected 2m, got %s", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("expected about an hour, got %s", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("expected 0, got %s", got)
+	}
+}
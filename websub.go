@@ -0,0 +1,514 @@
+package rss_reader
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	defaultLeaseSeconds  = 10 * 24 * 60 * 60
+	maxLeaseRenewMargin  = 24 * time.Hour
+	webSubCheckInterval  = time.Minute
+	webSubVerifyTimeout  = 10 * time.Minute
+	defaultPollInterval  = 30 * time.Minute
+	webSubSecretSize     = 32
+	webSubCallbackPrefix = "/websub/"
+)
+
+var (
+	ErrHubRejected = errors.New("hub rejected subscription")
+)
+
+// webSubListener keeps a user's feeds up to date in a long-running process.
+// Feeds that advertise a WebSub hub are subscribed to with a callback under
+// callbackBase and get their content pushed; everything else is polled.
+//
+// update is held by one update of the feeds at a time, fetching and the
+// pipeline included; it guards the feeds except their WebSub states. mu guards
+// those and the rest of the listener's state, and is only held briefly: the
+// HTTP handlers take just mu, so hubs never wait for an update. Take update
+// before mu.
+type webSubListener struct {
+	update        sync.Mutex
+	mu            sync.Mutex
+	feeds         *Feeds
+	userFeedsFile string
+	feedsIO       FeedsIO
+	feedFetcher   FeedFetcher
+	callbackBase  string
+	pollInterval  time.Duration
+	pipeline      pipeline
+	log           *slog.Logger
+
+	// hubless remembers feeds without a hub, so discovery runs once per feed
+	hubless   map[string]bool
+	requested map[string]time.Time
+	// pushes waits for applyPushes, wake tells run there are some
+	pushes []webSubPush
+	wake   chan struct{}
+	// dirty is set when a lease changed but the feeds were not saved yet
+	dirty bool
+}
+
+type webSubPush struct {
+	feed       *Feed
+	remoteFeed *gofeed.Feed
+}
+
+func newWebSubListener(feeds *Feeds, userFeedsFile string, feedsIO FeedsIO, feedFetcher FeedFetcher, callbackBase string, log *slog.Logger) *webSubListener {
+	for _, feed := range feeds.Items {
+		if feed.Hash == "" {
+			feed.Hash = GetSHA256(feed.Url)
+		}
+	}
+	return &webSubListener{
+		feeds:         feeds,
+		userFeedsFile: userFeedsFile,
+		feedsIO:       feedsIO,
+		feedFetcher:   feedFetcher,
+		callbackBase:  strings.TrimRight(callbackBase, "/"),
+		pollInterval:  defaultPollInterval,
+		pipeline:      newPipeline(feeds, userFeedsFile),
+		log:           log,
+		hubless:       map[string]bool{},
+		requested:     map[string]time.Time{},
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// run subscribes, renews and polls until ctx is cancelled.
+func (l *webSubListener) run(ctx context.Context) {
+	l.maintain(ctx)
+	l.poll(ctx)
+
+	check := time.NewTicker(webSubCheckInterval)
+	defer check.Stop()
+	poll := time.NewTicker(l.pollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+			l.maintain(ctx)
+		case <-l.wake:
+			l.applyPushes(ctx)
+		case <-poll.C:
+			l.poll(ctx)
+		}
+	}
+}
+
+func (l *webSubListener) callbackURL(feed *Feed) string {
+	return l.callbackBase + webSubCallbackPrefix + feed.Hash
+}
+
+func pushable(feed *Feed) bool {
+	switch feed.Type {
+	case "", FEED_TYPE_RSS:
+		return true
+	}
+	return false
+}
+
+func (s *WebSubState) active(now time.Time) bool {
+	return s != nil && now.Before(s.LeaseExpires)
+}
+
+// renewDue reports whether the lease is close enough to its end to renew:
+// a tenth of the lease before expiry, but no earlier than a day before.
+func (s *WebSubState) renewDue(now time.Time) bool {
+	lease := time.Duration(s.LeaseSeconds) * time.Second
+	margin := min(lease/10, maxLeaseRenewMargin)
+	return now.After(s.LeaseExpires.Add(-margin))
+}
+
+// maintain discovers hubs of new feeds and (re)subscribes where a lease is
+// missing or about to run out.
+func (l *webSubListener) maintain(ctx context.Context) {
+	l.mu.Lock()
+	var discover, subscribe []*Feed
+	now := time.Now()
+	for _, feed := range l.feeds.Items {
+		switch {
+		case !pushable(feed) || l.hubless[feed.Hash]:
+		case feed.WebSub == nil:
+			discover = append(discover, feed)
+		case feed.WebSub.active(now) && !feed.WebSub.renewDue(now):
+		case now.Sub(l.requested[feed.Hash]) > webSubVerifyTimeout:
+			subscribe = append(subscribe, feed)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, feed := range discover {
+		hub, topic, err := discoverHub(ctx, feed.Url)
+		if err != nil {
+			l.log.Error("hub discovery failed", "url", feed.Url, "error", err)
+			continue
+		}
+
+		l.mu.Lock()
+		if hub == "" {
+			l.hubless[feed.Hash] = true
+		} else {
+			feed.WebSub = &WebSubState{Hub: hub, Topic: topic, LeaseSeconds: defaultLeaseSeconds}
+			subscribe = append(subscribe, feed)
+		}
+		l.mu.Unlock()
+	}
+
+	for _, feed := range subscribe {
+		if err := l.subscribe(ctx, feed); err != nil {
+			l.log.Error("websub subscription failed", "url", feed.Url, "error", err)
+		}
+	}
+
+	// leases verified since the last update
+	l.mu.Lock()
+	dirty := l.dirty
+	l.mu.Unlock()
+	if dirty {
+		l.save()
+	}
+}
+
+// subscribe asks the hub for a subscription. The hub confirms it later by
+// calling the callback, see verify.
+func (l *webSubListener) subscribe(ctx context.Context, feed *Feed) error {
+	l.mu.Lock()
+	if feed.WebSub.Secret == "" {
+		feed.WebSub.Secret = randomHex(webSubSecretSize)
+	}
+	if feed.WebSub.LeaseSeconds == 0 {
+		feed.WebSub.LeaseSeconds = defaultLeaseSeconds
+	}
+	state := *feed.WebSub
+	l.requested[feed.Hash] = time.Now()
+	l.mu.Unlock()
+
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {state.Topic},
+		"hub.callback":      {l.callbackURL(feed)},
+		"hub.secret":        {state.Secret},
+		"hub.lease_seconds": {strconv.Itoa(state.LeaseSeconds)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, state.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxStderrSize))
+		return fmt.Errorf("%w: %s %s", ErrHubRejected, resp.Status, bytes.TrimSpace(body))
+	}
+
+	l.log.Info("websub subscription requested", "url", feed.Url, "hub", state.Hub)
+	return nil
+}
+
+// poll updates the feeds that get no pushes: no hub or no active lease.
+func (l *webSubListener) poll(ctx context.Context) {
+	l.update.Lock()
+	defer l.update.Unlock()
+
+	var polled []*Feed
+	now := time.Now()
+	l.mu.Lock()
+	for _, feed := range l.feeds.Items {
+		if !feed.WebSub.active(now) {
+			polled = append(polled, feed)
+		}
+	}
+	l.mu.Unlock()
+	if len(polled) == 0 {
+		return
+	}
+
+	// one broken feed must not stop the listener; failures are logged
+	queued := queuedCounts(polled)
+	updateFeeds(ctx, l.feedFetcher, polled, l.pipeline, l.log)
+	l.finishUpdate(ctx, queued)
+}
+
+// applyPushes queues the items of the content pushed since the last call
+// and runs them through the pipeline.
+func (l *webSubListener) applyPushes(ctx context.Context) {
+	l.update.Lock()
+	defer l.update.Unlock()
+
+	l.mu.Lock()
+	pushes := l.pushes
+	l.pushes = nil
+	l.mu.Unlock()
+	if len(pushes) == 0 {
+		return
+	}
+
+	var pushed []*Feed
+	for _, push := range pushes {
+		pushed = append(pushed, push.feed)
+	}
+	queued := queuedCounts(pushed)
+	for _, push := range pushes {
+		before := len(push.feed.UnprocessedItems)
+		if err := applyUpdates(ctx, push.feed, push.remoteFeed, l.log); err != nil {
+			l.log.Error("can't apply pushed content", "url", push.feed.Url, "error", err)
+		}
+		l.pipeline.process(ctx, push.feed, push.feed.UnprocessedItems[before:], l.log)
+	}
+	l.finishUpdate(ctx, queued)
+}
+
+// finishUpdate dedupes and delivers the items new since queued, like a
+// run does, and saves the feeds; l.update must be held.
+func (l *webSubListener) finishUpdate(ctx context.Context, queued map[*Feed]int) {
+	l.feeds.finishUpdate(ctx, queued, l.userFeedsFile, l.log)
+	l.saveLocked()
+}
+
+// save writes the feeds, after the update that may be running.
+func (l *webSubListener) save() {
+	l.update.Lock()
+	defer l.update.Unlock()
+	l.saveLocked()
+}
+
+// saveLocked writes the feeds; l.update must be held.
+func (l *webSubListener) saveLocked() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dirty = false
+	if err := l.feedsIO.SaveUpdates(*l.feeds, l.userFeedsFile); err != nil {
+		l.log.Error("can't save updates", "path", l.userFeedsFile, "error", err)
+	}
+}
+
+func (l *webSubListener) findByHash(hash string) *Feed {
+	for _, feed := range l.feeds.Items {
+		if feed.Hash == hash {
+			return feed
+		}
+	}
+	return nil
+}
+
+func (l *webSubListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, webSubCallbackPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	hash := path.Base(r.URL.Path)
+
+	switch r.Method {
+	case http.MethodGet:
+		l.verify(w, r, hash)
+	case http.MethodPost:
+		l.receive(w, r, hash)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verify answers the hub's intent verification by echoing the challenge,
+// but only for subscriptions this listener asked for. The lease is saved
+// with the next update, or by maintain.
+func (l *webSubListener) verify(w http.ResponseWriter, r *http.Request, hash string) {
+	q := r.URL.Query()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	feed := l.findByHash(hash)
+	if feed == nil || feed.WebSub == nil || q.Get("hub.topic") != feed.WebSub.Topic {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch q.Get("hub.mode") {
+	case "subscribe":
+		lease := feed.WebSub.LeaseSeconds
+		if seconds, err := strconv.Atoi(q.Get("hub.lease_seconds")); err == nil && seconds > 0 {
+			lease = seconds
+		}
+		feed.WebSub.LeaseSeconds = lease
+		feed.WebSub.LeaseExpires = time.Now().Add(time.Duration(lease) * time.Second).UTC()
+		delete(l.requested, hash)
+		l.dirty = true
+
+		l.log.Info("websub subscription verified", "url", feed.Url, "lease", lease)
+		io.WriteString(w, q.Get("hub.challenge"))
+	case "denied":
+		feed.WebSub.LeaseExpires = time.Time{}
+		l.dirty = true
+		l.log.Warn("websub subscription denied", "url", feed.Url, "reason", q.Get("hub.reason"))
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// receive takes pushed content. Content with a bad signature is dropped but
+// still acknowledged, as the spec requires, so forgers learn nothing. The
+// hub is answered before the content goes through the pipeline, in run.
+func (l *webSubListener) receive(w http.ResponseWriter, r *http.Request, hash string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxResponseSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	l.mu.Lock()
+	feed := l.findByHash(hash)
+	var secret string
+	if feed != nil && feed.WebSub != nil {
+		secret = feed.WebSub.Secret
+	}
+	l.mu.Unlock()
+	if feed == nil || feed.WebSub == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+
+	if !validSignature(secret, r.Header.Get("X-Hub-Signature"), body) {
+		l.log.Warn("websub push with invalid signature dropped", "url", feed.Url)
+		return
+	}
+
+	remoteFeed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		l.log.Error("can't parse pushed content", "url", feed.Url, "error", err)
+		return
+	}
+	if remoteFeed.Updated == "" {
+		remoteFeed.Updated = itemsFingerprint(remoteFeed.Items)
+	}
+
+	l.log.Info("websub push received", "url", feed.Url, "items", len(remoteFeed.Items))
+	l.mu.Lock()
+	l.pushes = append(l.pushes, webSubPush{feed: feed, remoteFeed: remoteFeed})
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func validSignature(secret, header string, body []byte) bool {
+	if secret == "" {
+		return false
+	}
+	method, signature, ok := strings.Cut(header, "=")
+	if !ok {
+		return false
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(method) {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// discoverHub finds the hub and the topic (rel="self") of a feed, from HTTP
+// Link headers or from links inside the document. hub is empty when the feed
+// has none.
+func discoverHub(ctx context.Context, feedURL string) (hub, topic string, err error) {
+	page, err := httpGet(ctx, feedURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	rels := feedLinkRels(page.Body)
+	for rel, href := range linkHeaderRels(page.Header.Values("Link")) {
+		rels[rel] = href
+	}
+
+	topic = feedURL
+	if self := rels["self"]; self != "" {
+		if ref, err := page.URL.Parse(self); err == nil {
+			topic = ref.String()
+		}
+	}
+	if rels["hub"] != "" {
+		if ref, err := page.URL.Parse(rels["hub"]); err == nil {
+			hub = ref.String()
+		}
+	}
+
+	return hub, topic, nil
+}
+
+// linkHeaderRels parses RFC 8288 Link headers: <href>; rel="a b", ...
+func linkHeaderRels(headers []string) map[string]string {
+	rels := map[string]string{}
+	for _, header := range headers {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			href := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			for _, param := range parts[1:] {
+				key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(key, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					rels[strings.ToLower(rel)] = href
+				}
+			}
+		}
+	}
+	return rels
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,294 @@
+package rss_reader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const webSubTestPush = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Pushed</title><id>urn:p</id>
+<updated>2024-06-01T00:00:00Z</updated>
+<entry><id>pushed-1</id><title>Pushed entry</title><link href="https://example.com/pushed-1"/></entry>
+</feed>`
+
+// testHub is a minimal WebSub hub: it verifies intent right after accepting
+// a subscription and remembers what it needs to push later.
+type testHub struct {
+	t         *testing.T
+	mu        sync.Mutex
+	callback  string
+	topic     string
+	secret    string
+	verified  chan string
+	leaseSecs string
+}
+
+func (h *testHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	h.mu.Lock()
+	h.callback = r.Form.Get("hub.callback")
+	h.topic = r.Form.Get("hub.topic")
+	h.secret = r.Form.Get("hub.secret")
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+
+	go func() {
+		q := url.Values{
+			"hub.mode":          {"subscribe"},
+			"hub.topic":         {h.topic},
+			"hub.challenge":     {"challenge-123"},
+			"hub.lease_seconds": {h.leaseSecs},
+		}
+		resp, err := http.Get(h.callback + "?" + q.Encode())
+		if err != nil {
+			h.t.Errorf("verification request failed: %v", err)
+			h.verified <- ""
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		h.verified <- string(body)
+	}()
+}
+
+func (h *testHub) push(t *testing.T, body, secret string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+
+	req, _ := http.NewRequest(http.MethodPost, h.callback, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/atom+xml")
+	req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("expected 202 for a push, got %d", resp.StatusCode)
+	}
+}
+
+func Test_webSubListener(t *testing.T) {
+	hub := &testHub{t: t, verified: make(chan string, 1), leaseSecs: "3600"}
+	hubSrv := httptest.NewServer(hub)
+	defer hubSrv.Close()
+
+	publisher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+<title>Published</title><atom:link rel="hub" href="%s"/><atom:link rel="self" href="https://example.com/canonical.xml"/>
+</channel></rss>`, hubSrv.URL)
+	}))
+	defer publisher.Close()
+
+	var listener *webSubListener
+	callbackSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listener.ServeHTTP(w, r)
+	}))
+	defer callbackSrv.Close()
+
+	// new items reach the notifiers in listen mode too
+	webhook, delivered := webhookStandIn(t)
+	deliveredGUIDs := func() string {
+		var guids []string
+		for _, req := range delivered() {
+			var payload webhookPayload
+			json.Unmarshal(req.body, &payload)
+			for _, item := range payload.Items {
+				guids = append(guids, item.GUID)
+			}
+		}
+		return strings.Join(guids, ",")
+	}
+
+	pushFeed := newFeed(FEED_TYPE_RSS, publisher.URL)
+	pollFeed := newFeed(FEED_TYPE_RSS, "http://example.com/no-hub.xml")
+	feeds := Feeds{Settings: &Settings{Webhooks: []*WebhookConfig{{URL: webhook.URL}}}, Items: []*Feed{pushFeed, pollFeed}}
+	feeds.attachSettings()
+
+	saves := 0
+	mockFeedsIO := &MockFeedsIO{
+		SaveUpdatesFunc: func(feeds Feeds, userFeedsFile string) error {
+			saves++
+			return nil
+		},
+	}
+	mockFeedFetcher := &MockGofeedParser{
+		ParseURLWithContextFunc: func(feedURL string, ctx context.Context) (*gofeed.Feed, error) {
+			if feedURL != pollFeed.Url {
+				t.Errorf("expected only the hubless feed to be polled, got %s", feedURL)
+			}
+			return &gofeed.Feed{Updated: "polled", Items: []*gofeed.Item{{GUID: "polled-1"}}}, nil
+		},
+	}
+
+	listener = newWebSubListener(&feeds, filepath.Join(t.TempDir(), "feeds.json"), mockFeedsIO, mockFeedFetcher, callbackSrv.URL, setupLogger(io.Discard))
+
+	listener.maintain(context.Background())
+
+	select {
+	case challenge := <-hub.verified:
+		if challenge != "challenge-123" {
+			t.Fatalf("expected the challenge to be echoed, got %q", challenge)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub never verified the subscription")
+	}
+
+	if hub.topic != "https://example.com/canonical.xml" {
+		t.Errorf("expected rel=self as topic, got %q", hub.topic)
+	}
+	if !strings.HasSuffix(hub.callback, "/websub/"+pushFeed.Hash) {
+		t.Errorf("unexpected callback %q", hub.callback)
+	}
+
+	listener.mu.Lock()
+	state := *pushFeed.WebSub
+	listener.mu.Unlock()
+	if !state.active(time.Now()) || state.LeaseSeconds != 3600 {
+		t.Errorf("expected an active lease of 3600s, got %+v", state)
+	}
+
+	t.Run("Push", func(t *testing.T) {
+		hub.push(t, webSubTestPush, hub.secret)
+		listener.applyPushes(context.Background())
+
+		listener.update.Lock()
+		defer listener.update.Unlock()
+		if !pushFeed.seen("pushed-1") {
+			t.Errorf("expected pushed entry to be seen")
+		}
+		if got := deliveredGUIDs(); got != "pushed-1" {
+			t.Errorf("expected the pushed entry delivered, got %q", got)
+		}
+		if len(pushFeed.UnprocessedItems) != 0 {
+			t.Errorf("expected the delivered entry dropped from the queue, got %+v", pushFeed.UnprocessedItems)
+		}
+	})
+
+	t.Run("ForgedPush", func(t *testing.T) {
+		hub.push(t, strings.ReplaceAll(webSubTestPush, "pushed-1", "forged-1"), "wrong secret")
+		listener.applyPushes(context.Background())
+
+		listener.update.Lock()
+		defer listener.update.Unlock()
+		if pushFeed.seen("forged-1") {
+			t.Errorf("expected a push with a bad signature to be dropped")
+		}
+	})
+
+	t.Run("DuringUpdate", func(t *testing.T) {
+		// hubs are answered while an update runs, the push waits for it
+		listener.update.Lock()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			next := strings.NewReplacer("pushed-1", "pushed-2", "2024-06-01", "2024-06-02").Replace(webSubTestPush)
+			hub.push(t, next, hub.secret)
+			q := url.Values{"hub.mode": {"subscribe"}, "hub.topic": {hub.topic}, "hub.challenge": {"c"}}
+			if resp, err := http.Get(hub.callback + "?" + q.Encode()); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("expected the hub answered while an update runs")
+		}
+		pending := pushFeed.seen("pushed-2")
+		listener.update.Unlock()
+		if pending {
+			t.Error("expected the push applied after the update")
+		}
+
+		listener.applyPushes(context.Background())
+		if !pushFeed.seen("pushed-2") {
+			t.Error("expected the push applied")
+		}
+	})
+
+	t.Run("Undated", func(t *testing.T) {
+		// content without a date of its own is versioned by its items
+		listener.update.Lock()
+		pushFeed.Updated = ""
+		listener.update.Unlock()
+
+		hub.push(t, `<?xml version="1.0"?><rss version="2.0"><channel><title>Pushed</title>
+<item><guid>undated-1</guid><title>Undated</title></item>
+</channel></rss>`, hub.secret)
+		listener.applyPushes(context.Background())
+
+		if !pushFeed.seen("undated-1") || !strings.HasSuffix(deliveredGUIDs(), ",undated-1") {
+			t.Errorf("expected the undated push queued and delivered, got %q", deliveredGUIDs())
+		}
+	})
+
+	t.Run("UnknownTopic", func(t *testing.T) {
+		resp, err := http.Get(hub.callback + "?hub.mode=subscribe&hub.topic=other&hub.challenge=x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected 404 for a subscription we did not ask for, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("PollFallback", func(t *testing.T) {
+		listener.poll(context.Background())
+		if !pollFeed.seen("polled-1") {
+			t.Errorf("expected the hubless feed to be polled")
+		}
+		if got := deliveredGUIDs(); !strings.HasSuffix(got, ",polled-1") {
+			t.Errorf("expected the polled item delivered, got %q", got)
+		}
+		if saves == 0 {
+			t.Errorf("expected updates to be saved")
+		}
+	})
+}
+
+func Test_WebSubState_renewDue(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		lease   int
+		expires time.Time
+		want    bool
+	}{
+		{"fresh lease", 3600, now.Add(time.Hour), false},
+		{"last tenth of lease", 3600, now.Add(5 * time.Minute), true},
+		{"long lease, two days left", defaultLeaseSeconds, now.Add(48 * time.Hour), false},
+		{"long lease, last day", defaultLeaseSeconds, now.Add(20 * time.Hour), true},
+		{"expired", 3600, now.Add(-time.Minute), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WebSubState{LeaseSeconds: tt.lease, LeaseExpires: tt.expires}
+			if got := s.renewDue(now); got != tt.want {
+				t.Errorf("renewDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_linkHeaderRels(t *testing.T) {
+	rels := linkHeaderRels([]string{`<https://hub.example.com/>; rel="hub", <https://example.com/feed>; rel=self`})
+	if rels["hub"] != "https://hub.example.com/" || rels["self"] != "https://example.com/feed" {
+		t.Errorf("unexpected rels %v", rels)
+	}
+}